@@ -0,0 +1,189 @@
+// Package collector periodically runs console commands on devices and turns their output into events.
+package collector
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/byuoitav/common/log"
+	"github.com/byuoitav/common/v2/events"
+	crestrontelnet "github.com/byuoitav/crestron-telnet-microservice/crestron-telnet"
+	"github.com/byuoitav/crestron-telnet-microservice/inventory"
+)
+
+// Task is something collected from a device's console on a schedule
+type Task struct {
+	Name     string
+	Interval time.Duration
+
+	// Collect runs whatever commands it needs on session and returns the events to send
+	Collect func(ctx context.Context, session *crestrontelnet.Session) ([]events.Event, error)
+
+	// Forget, if set, drops whatever the task remembers about hostname once it is no longer monitored
+	Forget func(hostname string)
+}
+
+// Collector runs a set of tasks against each device it is given
+type Collector struct {
+	Name  string
+	Tasks []Task
+
+	// DMPS is whether the devices are DMPSs, whose events go through the event rules
+	// so that they get the same ids as the events read from their consoles
+	DMPS bool
+}
+
+// Run runs c's tasks against dev until ctx is cancelled. One session is kept open to dev and reused
+// for every task, so collecting doesn't reconnect to the console every time something is due. It is
+// only reopened once it breaks. Whether it could be opened and used is reported to dev's connectivity.
+// It matches supervisor.RunFunc.
+func (c *Collector) Run(ctx context.Context, dev inventory.Device) {
+	var tasks []Task
+	for _, task := range c.Tasks {
+		if task.Interval > 0 {
+			tasks = append(tasks, task)
+		}
+	}
+
+	if len(tasks) == 0 {
+		return
+	}
+
+	// spread devices out so they aren't all queried at the same time
+	next := make([]time.Time, len(tasks))
+	for i := range tasks {
+		next[i] = time.Now().Add(stagger(tasks[i].Interval))
+	}
+
+	var session *crestrontelnet.Session
+	defer func() {
+		if session != nil {
+			session.Close()
+		}
+	}()
+
+	// how many times in a row a session couldn't be opened or broke
+	failures := 0
+
+	for {
+		earliest := next[0]
+		for _, t := range next[1:] {
+			if t.Before(earliest) {
+				earliest = t
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(earliest)):
+		}
+
+		var due []int
+		now := time.Now()
+		for i := range tasks {
+			if !next[i].After(now) {
+				due = append(due, i)
+			}
+		}
+
+		if session == nil {
+			var err error
+
+			session, err = crestrontelnet.OpenSession(ctx, dev)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+
+				failures++
+				delay := crestrontelnet.SessionRetryDelay(dev, err, failures)
+
+				log.L.Warnf("[%s] unable to open session to %s (trying again in %v): %s", c.Name, dev.Hostname, delay.Round(time.Second), err)
+				crestrontelnet.ReportSession(dev.Hostname, err)
+
+				for _, i := range due {
+					next[i] = time.Now().Add(delay)
+				}
+
+				continue
+			}
+
+			crestrontelnet.ReportSession(dev.Hostname, nil)
+		}
+
+		for _, i := range due {
+			c.collect(ctx, session, tasks[i])
+			next[i] = time.Now().Add(tasks[i].Interval)
+		}
+
+		err := session.Err()
+		if err == nil {
+			failures = 0
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		session.Close()
+		session = nil
+		failures++
+
+		// the console may have just closed an idle session, so the first time it breaks it is
+		// reopened right away without saying anything. If it keeps breaking, it backs off.
+		delay := time.Duration(0)
+		if failures > 1 {
+			delay = crestrontelnet.SessionRetryDelay(dev, err, failures-1)
+
+			log.L.Warnf("[%s] session to %s broke (trying again in %v): %s", c.Name, dev.Hostname, delay.Round(time.Second), err)
+			crestrontelnet.ReportSession(dev.Hostname, err)
+		}
+
+		for _, i := range due {
+			next[i] = time.Now().Add(delay)
+		}
+	}
+}
+
+// Forget drops whatever c's tasks remember about hostname. It should only be called once
+// hostname's worker has stopped and it is no longer monitored.
+func (c *Collector) Forget(hostname string) {
+	for _, task := range c.Tasks {
+		if task.Forget != nil {
+			task.Forget(hostname)
+		}
+	}
+}
+
+func (c *Collector) collect(ctx context.Context, session *crestrontelnet.Session, task Task) {
+	hostname := session.Device().Hostname
+	log.L.Debugf("[%s] collecting %s from %s", c.Name, task.Name, hostname)
+
+	xs, err := task.Collect(ctx, session)
+	if err != nil {
+		log.L.Warnf("[%s] unable to collect %s from %s: %s", c.Name, task.Name, hostname, err)
+	}
+
+	for _, x := range xs {
+		if c.DMPS {
+			crestrontelnet.SendDMPSEvent(hostname, x)
+			continue
+		}
+
+		if nerr := crestrontelnet.SendEvent(x); nerr != nil {
+			log.L.Warnf("Error sending event %v", nerr.Error())
+		}
+	}
+}
+
+func stagger(interval time.Duration) time.Duration {
+	max := interval
+	if max > time.Minute {
+		max = time.Minute
+	}
+
+	return time.Duration(rand.Int63n(int64(max)))
+}
@@ -0,0 +1,48 @@
+package collector
+
+import (
+	"testing"
+)
+
+func TestForgetDevice(t *testing.T) {
+	errorLogs := NewErrorLog()
+	errorLogs.update("CP1", parseErrorLog("1. Error: A.exe # 2019-07-01 08:00:00 # old\n"))
+	errorLogs.update("CP2", parseErrorLog("1. Error: A.exe # 2019-07-01 08:00:00 # old\n"))
+
+	telemetryTask := TelemetryTask(0)
+	if telemetryTask.Forget == nil {
+		t.Fatalf("the telemetry task can't forget devices")
+	}
+
+	c := &Collector{
+		Name:  "test",
+		Tasks: []Task{IdentityTask(0), telemetryTask, errorLogs.Task(0)},
+	}
+
+	c.Forget("CP1")
+
+	if recent := errorLogs.Recent("CP1", 0); len(recent) != 0 {
+		t.Errorf("got %+v from a forgotten device's error log, want nothing", recent)
+	}
+
+	if recent := errorLogs.Recent("CP2", 0); len(recent) != 1 {
+		t.Errorf("got %+v from a device that wasn't forgotten, want its entry", recent)
+	}
+
+	// forgotten devices start over, so the entries already in their log aren't sent again
+	if fresh := errorLogs.update("CP1", parseErrorLog("1. Error: A.exe # 2019-07-01 08:00:00 # old\n")); len(fresh) != 0 {
+		t.Errorf("sent %+v from the first read after forgetting, want nothing", fresh)
+	}
+}
+
+func TestForgetUptime(t *testing.T) {
+	tel := &telemetry{
+		uptimes: map[string]int64{"CP1": 100, "CP2": 200},
+	}
+
+	tel.forget("CP1")
+
+	if _, ok := tel.uptimes["CP1"]; ok || len(tel.uptimes) != 1 {
+		t.Errorf("got uptimes %v, want only CP2", tel.uptimes)
+	}
+}
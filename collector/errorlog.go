@@ -0,0 +1,244 @@
+package collector
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/byuoitav/common/v2/events"
+	crestrontelnet "github.com/byuoitav/crestron-telnet-microservice/crestron-telnet"
+)
+
+// maxRecentErrors is how many error log entries are kept per device for ErrorLog.Recent
+const maxRecentErrors = 200
+
+// ErrorEntry is a single entry from a processor's error log
+type ErrorEntry struct {
+	Index     int       `json:"index,omitempty"`
+	Severity  string    `json:"severity"`
+	Source    string    `json:"source,omitempty"`
+	Timestamp time.Time `json:"timestamp,omitempty"`
+	Message   string    `json:"message"`
+	Raw       string    `json:"raw"`
+}
+
+var (
+	// e.g. 25. Error: SystemMonitor.exe # 2019-07-03 10:08:28 # System Monitor: Memory low
+	errorLine = regexp.MustCompile(`(?i)^\s*(?:(\d+)\.\s*)?(notice|info|ok|warning|warn|error|fatal)\s*:\s*(.*)$`)
+
+	errorTimestampLayouts = []string{
+		"2006-01-02 15:04:05",
+		"2006-01-02 15:04:05.000",
+		"01-02-06 15:04:05",
+		"01/02/2006 15:04:05",
+		"01/02/06 15:04:05",
+		"Jan _2 2006 15:04:05",
+	}
+)
+
+// ErrorLog harvests processors' error logs, only sending entries it hasn't seen before, and
+// keeps the most recent entries from each device.
+type ErrorLog struct {
+	mu      sync.Mutex
+	devices map[string]*deviceErrors
+}
+
+type deviceErrors struct {
+	// highWater is the newest timestamp that has been seen. Entries older than it are never sent again.
+	highWater time.Time
+
+	// seen is every entry in the last read of the log, since entries without a parseable
+	// timestamp (or with the same one) can only be told apart by their contents
+	seen map[string]bool
+
+	// recent is the newest entries, newest first
+	recent []ErrorEntry
+}
+
+// NewErrorLog returns an empty ErrorLog
+func NewErrorLog() *ErrorLog {
+	return &ErrorLog{
+		devices: make(map[string]*deviceErrors),
+	}
+}
+
+// Task returns a task that reads each device's error log (ERR, or ERRLOG if ERR isn't supported)
+// every interval and sends each new entry as an event tagged error and with its severity.
+//
+// Entries that are already in the log the first time it is read are remembered, but not sent,
+// so that restarting the service doesn't resend every device's whole log.
+func (l *ErrorLog) Task(interval time.Duration) Task {
+	return Task{
+		Name:     "error-log",
+		Interval: interval,
+		Collect:  l.collect,
+		Forget:   l.Forget,
+	}
+}
+
+// Forget drops everything harvested from hostname's error log
+func (l *ErrorLog) Forget(hostname string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.devices, hostname)
+}
+
+// Recent returns up to n of the newest entries harvested from hostname's error log, newest first
+func (l *ErrorLog) Recent(hostname string, n int) []ErrorEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	d, ok := l.devices[hostname]
+	if !ok {
+		return []ErrorEntry{}
+	}
+
+	if n <= 0 || n > len(d.recent) {
+		n = len(d.recent)
+	}
+
+	entries := make([]ErrorEntry, n)
+	copy(entries, d.recent[:n])
+	return entries
+}
+
+func (l *ErrorLog) collect(ctx context.Context, session *crestrontelnet.Session) ([]events.Event, error) {
+	output, err := session.Execute(ctx, "ERR")
+	if err == nil && commandFailed(output) {
+		output, err = session.Execute(ctx, "ERRLOG")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if commandFailed(output) {
+		return nil, nil
+	}
+
+	dev := session.Device()
+	fresh := l.update(dev.Hostname, parseErrorLog(output))
+
+	var xs []events.Event
+	for _, entry := range fresh {
+		x, ok := crestrontelnet.DeviceEvent(dev, "error-log", entry.Message, "auto-generated", "error", entry.Severity)
+		if !ok {
+			continue
+		}
+
+		x.Data = entry
+		xs = append(xs, x)
+	}
+
+	return xs, nil
+}
+
+// update records entries as the latest read of hostname's log, returning the ones that haven't been seen before
+func (l *ErrorLog) update(hostname string, entries []ErrorEntry) []ErrorEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	d, ok := l.devices[hostname]
+	first := !ok
+	if first {
+		d = &deviceErrors{}
+		l.devices[hostname] = d
+	}
+
+	var fresh []ErrorEntry
+	seen := make(map[string]bool, len(entries))
+	highWater := d.highWater
+
+	for _, entry := range entries {
+		key := entryKey(entry)
+		seen[key] = true
+
+		if entry.Timestamp.After(highWater) {
+			highWater = entry.Timestamp
+		}
+
+		switch {
+		case d.seen[key]:
+			continue
+		case !entry.Timestamp.IsZero() && entry.Timestamp.Before(d.highWater):
+			continue
+		}
+
+		// remembered either way so the first read still shows up in Recent
+		d.recent = append([]ErrorEntry{entry}, d.recent...)
+		if !first {
+			fresh = append(fresh, entry)
+		}
+	}
+
+	if len(d.recent) > maxRecentErrors {
+		d.recent = d.recent[:maxRecentErrors]
+	}
+
+	d.seen = seen
+	d.highWater = highWater
+
+	return fresh
+}
+
+// entryKey identifies an entry by its contents, ignoring its index since that shifts as the log rolls over
+func entryKey(entry ErrorEntry) string {
+	return entry.Severity + "|" + entry.Source + "|" + entry.Timestamp.String() + "|" + entry.Message
+}
+
+// parseErrorLog parses the output of ERR, oldest entry first
+func parseErrorLog(output string) []ErrorEntry {
+	var entries []ErrorEntry
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+
+		m := errorLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		entry := ErrorEntry{
+			Severity: strings.ToLower(m[2]),
+			Raw:      line,
+		}
+
+		if entry.Severity == "warn" {
+			entry.Severity = "warning"
+		}
+
+		if len(m[1]) > 0 {
+			entry.Index, _ = strconv.Atoi(m[1])
+		}
+
+		// source # timestamp # message, though older firmware leaves out the source and timestamp
+		parts := strings.SplitN(m[3], "#", 3)
+		if len(parts) == 3 {
+			entry.Source = strings.TrimSpace(parts[0])
+			entry.Timestamp = parseErrorTimestamp(strings.TrimSpace(parts[1]))
+			entry.Message = strings.TrimSpace(parts[2])
+		} else {
+			entry.Message = strings.TrimSpace(m[3])
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func parseErrorTimestamp(s string) time.Time {
+	s = strings.Join(strings.Fields(s), " ")
+
+	for _, layout := range errorTimestampLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
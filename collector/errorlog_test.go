@@ -0,0 +1,67 @@
+package collector
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrorLog(t *testing.T) {
+	output := "1. Notice: Logos.exe # 2019-07-01 08:42:33 # Program Logos started\n" +
+		"Not an entry\n" +
+		"2. Error: SystemMonitor.exe # 2019-07-03 10:08:28  # System Monitor: Memory low # 12%\n" +
+		"Warning: Network cable unplugged\n"
+
+	entries := parseErrorLog(output)
+	if len(entries) != 3 {
+		t.Fatalf("got %v entries, want 3: %+v", len(entries), entries)
+	}
+
+	want := []ErrorEntry{
+		{Index: 1, Severity: "notice", Source: "Logos.exe", Timestamp: time.Date(2019, 7, 1, 8, 42, 33, 0, time.Local), Message: "Program Logos started"},
+		{Index: 2, Severity: "error", Source: "SystemMonitor.exe", Timestamp: time.Date(2019, 7, 3, 10, 8, 28, 0, time.Local), Message: "System Monitor: Memory low # 12%"},
+		{Severity: "warning", Message: "Network cable unplugged"},
+	}
+
+	for i := range want {
+		got := entries[i]
+		got.Raw = ""
+
+		if !got.Timestamp.Equal(want[i].Timestamp) {
+			t.Errorf("entry %v: got timestamp %v, want %v", i, got.Timestamp, want[i].Timestamp)
+		}
+
+		got.Timestamp, want[i].Timestamp = time.Time{}, time.Time{}
+		if got != want[i] {
+			t.Errorf("entry %v: got %+v, want %+v", i, got, want[i])
+		}
+	}
+}
+
+func TestErrorLogUpdate(t *testing.T) {
+	l := NewErrorLog()
+
+	first := parseErrorLog("1. Error: A.exe # 2019-07-01 08:00:00 # old\n")
+	if fresh := l.update("CP1", first); len(fresh) != 0 {
+		t.Errorf("first read sent %v entries, want 0", len(fresh))
+	}
+
+	second := parseErrorLog("1. Error: A.exe # 2019-07-01 08:00:00 # old\n" +
+		"2. Error: A.exe # 2019-07-01 09:00:00 # new\n")
+	fresh := l.update("CP1", second)
+	if len(fresh) != 1 || fresh[0].Message != "new" {
+		t.Errorf("got %+v, want just the new entry", fresh)
+	}
+
+	// the log was cleared, and an entry older than the high water mark shows up
+	third := parseErrorLog("1. Error: A.exe # 2019-07-01 07:00:00 # older\n" +
+		"2. Error: A.exe # 2019-07-01 10:00:00 # newer\n")
+	fresh = l.update("CP1", third)
+	if len(fresh) != 1 || fresh[0].Message != "newer" {
+		t.Errorf("got %+v, want just the newer entry", fresh)
+	}
+
+	recent := l.Recent("CP1", 2)
+	if len(recent) != 2 || recent[0].Message != "newer" || recent[1].Message != "new" {
+		t.Errorf("got %+v, want newer then new", recent)
+	}
+}
@@ -0,0 +1,174 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/byuoitav/common/v2/events"
+	crestrontelnet "github.com/byuoitav/crestron-telnet-microservice/crestron-telnet"
+)
+
+// Identity is what a device reports about its hardware, firmware, and network settings
+type Identity struct {
+	Model           string `json:"model,omitempty"`
+	FirmwareVersion string `json:"firmware-version,omitempty"`
+	FirmwareDate    string `json:"firmware-date,omitempty"`
+	TSID            string `json:"tsid,omitempty"`
+	SerialNumber    string `json:"serial-number,omitempty"`
+	Hostname        string `json:"hostname,omitempty"`
+	MACAddress      string `json:"mac-address,omitempty"`
+	IPAddress       string `json:"ip-address,omitempty"`
+	SubnetMask      string `json:"subnet-mask,omitempty"`
+	DefaultGateway  string `json:"default-gateway,omitempty"`
+	DHCPEnabled     string `json:"dhcp-enabled,omitempty"`
+}
+
+var (
+	// e.g. DMPS3-4K-150-C Cntrl Eng [v1.601.0050.27112 (Apr 01 2021), #00F1A1B2] @E-00107f9c9ea0
+	versionLine = regexp.MustCompile(`(?m)^\s*(\S+)\s.*?\[v?([^\s,\]]+)(?:\s+\(([^)]*)\))?(?:,\s*#([0-9A-Fa-f]+))?\]`)
+	versionMAC  = regexp.MustCompile(`@E-([0-9A-Fa-f]{12})`)
+	nonHex      = regexp.MustCompile(`[^0-9a-f]`)
+)
+
+// IdentityTask returns a task that runs VER, SHOWHW, IPCONFIG, ESTATUS, and HOSTNAME every
+// interval, and sends what they report as core-state events.
+func IdentityTask(interval time.Duration) Task {
+	return Task{
+		Name:     "identity",
+		Interval: interval,
+		Collect:  collectIdentity,
+	}
+}
+
+func collectIdentity(ctx context.Context, session *crestrontelnet.Session) ([]events.Event, error) {
+	outputs := make(map[string]string)
+
+	var errs []string
+	for _, cmd := range []string{"VER", "SHOWHW", "IPCONFIG", "ESTATUS", "HOSTNAME"} {
+		output, err := session.Execute(ctx, cmd)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		if !commandFailed(output) {
+			outputs[cmd] = output
+		}
+	}
+
+	id := parseIdentity(outputs)
+
+	var err error
+	if len(errs) > 0 {
+		err = fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+
+	return identityEvents(session, id), err
+}
+
+// parseIdentity builds an Identity from the output of each command, keyed by the command
+func parseIdentity(outputs map[string]string) Identity {
+	var id Identity
+
+	if m := versionLine.FindStringSubmatch(outputs["VER"]); m != nil {
+		id.Model = m[1]
+		id.FirmwareVersion = m[2]
+		id.FirmwareDate = m[3]
+		id.TSID = m[4]
+	}
+
+	if m := versionMAC.FindStringSubmatch(outputs["VER"]); m != nil {
+		id.MACAddress = normalizeMAC(m[1])
+	}
+
+	hw := parseFields(outputs["SHOWHW"])
+	id.SerialNumber = lookup(hw, "serial number", "serial", "serial no")
+	if len(id.Model) == 0 {
+		id.Model = lookup(hw, "model", "product")
+	}
+
+	// IPCONFIG is preferred, but not every model has it
+	network := append(parseFields(outputs["IPCONFIG"]), parseFields(outputs["ESTATUS"])...)
+
+	if mac := lookup(network, "mac address"); len(mac) > 0 {
+		id.MACAddress = normalizeMAC(mac)
+	}
+
+	id.IPAddress = lookup(network, "ip address")
+	id.SubnetMask = lookup(network, "subnet mask")
+	id.DefaultGateway = lookup(network, "default gateway", "gateway")
+
+	switch strings.ToLower(lookup(network, "dhcp")) {
+	case "on", "enabled", "yes", "true":
+		id.DHCPEnabled = "true"
+	case "off", "disabled", "no", "false":
+		id.DHCPEnabled = "false"
+	}
+
+	id.Hostname = lookup(parseFields(outputs["HOSTNAME"]), "host name", "hostname")
+	if len(id.Hostname) == 0 {
+		// some firmware just prints the name
+		id.Hostname = strings.TrimSpace(outputs["HOSTNAME"])
+		if strings.ContainsAny(id.Hostname, " \n:") {
+			id.Hostname = ""
+		}
+	}
+
+	return id
+}
+
+// identityEvents returns a core-state event for each field of id that was found
+func identityEvents(session *crestrontelnet.Session, id Identity) []events.Event {
+	values := []struct {
+		key   string
+		value string
+	}{
+		{"model", id.Model},
+		{"firmware-version", id.FirmwareVersion},
+		{"firmware-date", id.FirmwareDate},
+		{"tsid", id.TSID},
+		{"serial-number", id.SerialNumber},
+		{"hostname", id.Hostname},
+		{"mac-address", id.MACAddress},
+		{"ip-address", id.IPAddress},
+		{"subnet-mask", id.SubnetMask},
+		{"default-gateway", id.DefaultGateway},
+		{"dhcp-enabled", id.DHCPEnabled},
+	}
+
+	var xs []events.Event
+	for _, v := range values {
+		if len(v.value) == 0 {
+			continue
+		}
+
+		if x, ok := crestrontelnet.DeviceEvent(session.Device(), v.key, v.value, "auto-generated", "core-state", "identity"); ok {
+			xs = append(xs, x)
+		}
+	}
+
+	return xs
+}
+
+// normalizeMAC formats a mac address written as 00.10.7f.9c.9e.a0, 00107F9C9EA0, etc. as 00:10:7f:9c:9e:a0.
+// If there is more than one, only the first is used.
+func normalizeMAC(mac string) string {
+	if fields := strings.Fields(mac); len(fields) > 0 {
+		mac = fields[0]
+	}
+
+	hex := nonHex.ReplaceAllString(strings.ToLower(mac), "")
+	if len(hex) != 12 {
+		return strings.ToLower(strings.TrimSpace(mac))
+	}
+
+	parts := make([]string, 6)
+	for i := range parts {
+		parts[i] = hex[i*2 : i*2+2]
+	}
+
+	return strings.Join(parts, ":")
+}
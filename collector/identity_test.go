@@ -0,0 +1,93 @@
+package collector
+
+import (
+	"testing"
+)
+
+var identityTests = []struct {
+	name    string
+	outputs map[string]string
+	want    Identity
+}{
+	{
+		name: "DMPS3",
+		outputs: map[string]string{
+			"VER": "DMPS3-4K-150-C Cntrl Eng [v1.601.0050.27112 (Apr 01 2021), #00F1A1B2] @E-00107f9c9ea0",
+			"IPCONFIG": "Ethernet Adapter [LAN]:\n" +
+				"   Link Status ........ : Connected\n" +
+				"   DHCP ............... : OFF\n" +
+				"   MAC Address(es) .... : 00.10.7f.9c.9e.a0\n" +
+				"   IP Address ......... : 10.5.34.10\n" +
+				"   Subnet Mask ........ : 255.255.255.0\n" +
+				"   Default Gateway .... : 10.5.34.1\n" +
+				"Ethernet Adapter [CS]:\n" +
+				"   IP Address ......... : 172.22.0.1\n",
+			"HOSTNAME": "Host Name: ITB-1101-CP1",
+		},
+		want: Identity{
+			Model:           "DMPS3-4K-150-C",
+			FirmwareVersion: "1.601.0050.27112",
+			FirmwareDate:    "Apr 01 2021",
+			TSID:            "00F1A1B2",
+			Hostname:        "ITB-1101-CP1",
+			MACAddress:      "00:10:7f:9c:9e:a0",
+			IPAddress:       "10.5.34.10",
+			SubnetMask:      "255.255.255.0",
+			DefaultGateway:  "10.5.34.1",
+			DHCPEnabled:     "false",
+		},
+	},
+	{
+		name: "ESTATUSFallback",
+		outputs: map[string]string{
+			"VER": "CP3 Cntrl Eng [v1.503.3568.25373 (Sep 14 2017), #7C7A2C35]",
+			"SHOWHW": "Processor Type: \tMX53\n" +
+				"Serial Number: \t1234ABCD5678\n",
+			"ESTATUS": "Current Ethernet settings :\n" +
+				"   DHCP ............... : ON\n" +
+				"   MAC Address ........ : 00.10.7F.12.34.56\n" +
+				"   IP address ......... : 10.5.34.12\n",
+			"HOSTNAME": "ITB-1108-CP1",
+		},
+		want: Identity{
+			Model:           "CP3",
+			FirmwareVersion: "1.503.3568.25373",
+			FirmwareDate:    "Sep 14 2017",
+			TSID:            "7C7A2C35",
+			SerialNumber:    "1234ABCD5678",
+			Hostname:        "ITB-1108-CP1",
+			MACAddress:      "00:10:7f:12:34:56",
+			IPAddress:       "10.5.34.12",
+			DHCPEnabled:     "true",
+		},
+	},
+	{
+		name:    "Empty",
+		outputs: map[string]string{},
+		want:    Identity{},
+	},
+}
+
+func TestParseIdentity(t *testing.T) {
+	for _, tt := range identityTests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseIdentity(tt.outputs)
+			if got != tt.want {
+				t.Errorf("got\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFields(t *testing.T) {
+	fields := parseFields("Ethernet Adapter [LAN]:\n   MAC Address(es) .... : 00:10:7f:9c:9e:a0\nnot a field\n")
+
+	if len(fields) != 1 {
+		t.Fatalf("got %v fields, want 1: %+v", len(fields), fields)
+	}
+
+	want := field{Section: "ethernet adapter [lan]", Key: "mac address", Value: "00:10:7f:9c:9e:a0"}
+	if fields[0] != want {
+		t.Errorf("got %+v, want %+v", fields[0], want)
+	}
+}
@@ -0,0 +1,74 @@
+package collector
+
+import (
+	"regexp"
+	"strings"
+)
+
+// field is a single "Key ....... : Value" line from a console command's output
+type field struct {
+	Section string
+	Key     string
+	Value   string
+}
+
+var (
+	fieldLine    = regexp.MustCompile(`^\s*([^:]*[^:.\s])[\s.]*:\s*(.*?)\s*$`)
+	pluralSuffix = regexp.MustCompile(`\((e?s)\)`)
+	whitespace   = regexp.MustCompile(`\s+`)
+)
+
+// parseFields parses the "Key ....... : Value" lines most console commands print. Lines with a key
+// but no value (e.g. "Ethernet Adapter [LAN]:") start a new section. Keys are normalized with normalizeKey.
+func parseFields(output string) []field {
+	var fields []field
+	section := ""
+
+	for _, line := range strings.Split(output, "\n") {
+		m := fieldLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		key := normalizeKey(m[1])
+		if len(m[2]) == 0 {
+			section = key
+			continue
+		}
+
+		fields = append(fields, field{
+			Section: section,
+			Key:     key,
+			Value:   m[2],
+		})
+	}
+
+	return fields
+}
+
+// lookup returns the value of the first field with one of keys
+func lookup(fields []field, keys ...string) string {
+	for _, f := range fields {
+		for _, key := range keys {
+			if f.Key == key {
+				return f.Value
+			}
+		}
+	}
+
+	return ""
+}
+
+// normalizeKey lowercases key, drops any plural suffixes like (es), and collapses whitespace
+func normalizeKey(key string) string {
+	key = strings.ToLower(key)
+	key = pluralSuffix.ReplaceAllString(key, "")
+	key = whitespace.ReplaceAllString(key, " ")
+	return strings.TrimSpace(key)
+}
+
+// commandFailed returns true if the console didn't understand the command
+func commandFailed(output string) bool {
+	lower := strings.ToLower(output)
+	return strings.Contains(lower, "bad or incomplete command") || strings.Contains(lower, "unknown command")
+}
@@ -0,0 +1,287 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/byuoitav/common/v2/events"
+	crestrontelnet "github.com/byuoitav/crestron-telnet-microservice/crestron-telnet"
+)
+
+// Telemetry is a snapshot of a processor's resource usage and program state
+type Telemetry struct {
+	UptimeSeconds  int64     `json:"uptime-seconds,omitempty"`
+	CPULoadPercent float64   `json:"cpu-load-percent,omitempty"`
+	RAMFreeBytes   int64     `json:"ram-free-bytes,omitempty"`
+	RAMFreePercent float64   `json:"ram-free-percent,omitempty"`
+	Programs       []Program `json:"programs,omitempty"`
+}
+
+// Program is what a processor reports about one of its program slots
+type Program struct {
+	Slot       int    `json:"slot"`
+	File       string `json:"file,omitempty"`
+	CompiledOn string `json:"compiled-on,omitempty"`
+	Registered string `json:"registered,omitempty"`
+}
+
+var (
+	uptimeUnit   = regexp.MustCompile(`(?i)(\d+)\s*(days?|hours?|hrs?|minutes?|mins?|seconds?|secs?)\b`)
+	uptimeClock  = regexp.MustCompile(`(?:(\d+)\s*days?,?\s*)?(\d+):(\d{2})(?::(\d{2}))?`)
+	percent      = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*%`)
+	freeMemory   = regexp.MustCompile(`(?i)free[^0-9]*(\d+(?:\.\d+)?)\s*(bytes|kb|mb|gb|k|m|g|b|%)?`)
+	anyMemory    = regexp.MustCompile(`(?i)(\d+(?:\.\d+)?)\s*(bytes|kb|mb|gb|k|m|g|b|%)`)
+	programSlot  = regexp.MustCompile(`(?i)^\s*program\s*(?:slot\s*)?(\d+)\b\s*[:\-]?\s*(.*)$`)
+	memoryScales = map[string]float64{
+		"":      1,
+		"b":     1,
+		"bytes": 1,
+		"k":     1 << 10,
+		"kb":    1 << 10,
+		"m":     1 << 20,
+		"mb":    1 << 20,
+		"g":     1 << 30,
+		"gb":    1 << 30,
+	}
+)
+
+// telemetry remembers each device's last uptime so that reboots can be noticed
+type telemetry struct {
+	mu      sync.Mutex
+	uptimes map[string]int64
+}
+
+// TelemetryTask returns a task that runs UPTIME, CPULOAD, RAMFREE, PROGCOMMENTS, and PROGREGISTER
+// every interval, and sends what they report as health events. If a device's uptime goes backwards
+// between runs, a reboot-detected event is sent too.
+//
+// PROGRESET is never run, since it restarts the program.
+func TelemetryTask(interval time.Duration) Task {
+	t := &telemetry{
+		uptimes: make(map[string]int64),
+	}
+
+	return Task{
+		Name:     "telemetry",
+		Interval: interval,
+		Collect:  t.collect,
+		Forget:   t.forget,
+	}
+}
+
+func (t *telemetry) forget(hostname string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.uptimes, hostname)
+}
+
+func (t *telemetry) collect(ctx context.Context, session *crestrontelnet.Session) ([]events.Event, error) {
+	outputs := make(map[string]string)
+
+	var errs []string
+	for _, cmd := range []string{"UPTIME", "CPULOAD", "RAMFREE", "PROGCOMMENTS", "PROGREGISTER"} {
+		output, err := session.Execute(ctx, cmd)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		if !commandFailed(output) {
+			outputs[cmd] = output
+		}
+	}
+
+	tel := parseTelemetry(outputs)
+	dev := session.Device()
+
+	var xs []events.Event
+	metric := func(key, value string) {
+		if x, ok := crestrontelnet.DeviceEvent(dev, key, value, "health", "auto-generated", "telemetry"); ok {
+			xs = append(xs, x)
+		}
+	}
+
+	if _, ok := outputs["UPTIME"]; ok && tel.UptimeSeconds > 0 {
+		metric("uptime-seconds", strconv.FormatInt(tel.UptimeSeconds, 10))
+
+		t.mu.Lock()
+		prev, seen := t.uptimes[dev.Hostname]
+		t.uptimes[dev.Hostname] = tel.UptimeSeconds
+		t.mu.Unlock()
+
+		if seen && tel.UptimeSeconds < prev {
+			if x, ok := crestrontelnet.DeviceEvent(dev, "reboot-detected", "true", "health", "auto-generated", "telemetry", "reboot"); ok {
+				x.Data = map[string]int64{
+					"previous-uptime-seconds": prev,
+					"uptime-seconds":          tel.UptimeSeconds,
+				}
+
+				xs = append(xs, x)
+			}
+		}
+	}
+
+	if _, ok := outputs["CPULOAD"]; ok {
+		metric("cpu-load-percent", strconv.FormatFloat(tel.CPULoadPercent, 'f', -1, 64))
+	}
+
+	if _, ok := outputs["RAMFREE"]; ok {
+		if tel.RAMFreeBytes > 0 {
+			metric("ram-free-bytes", strconv.FormatInt(tel.RAMFreeBytes, 10))
+		}
+
+		if tel.RAMFreePercent > 0 {
+			metric("ram-free-percent", strconv.FormatFloat(tel.RAMFreePercent, 'f', -1, 64))
+		}
+	}
+
+	for _, p := range tel.Programs {
+		prefix := fmt.Sprintf("program-%02d-", p.Slot)
+
+		if len(p.File) > 0 {
+			metric(prefix+"file", p.File)
+		}
+
+		if len(p.CompiledOn) > 0 {
+			metric(prefix+"compiled-on", p.CompiledOn)
+		}
+
+		if len(p.Registered) > 0 {
+			metric(prefix+"registered", p.Registered)
+		}
+	}
+
+	var err error
+	if len(errs) > 0 {
+		err = fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+
+	return xs, err
+}
+
+// parseTelemetry builds a Telemetry from the output of each command, keyed by the command
+func parseTelemetry(outputs map[string]string) Telemetry {
+	var tel Telemetry
+
+	tel.UptimeSeconds = parseUptime(outputs["UPTIME"])
+
+	if m := percent.FindStringSubmatch(outputs["CPULOAD"]); m != nil {
+		tel.CPULoadPercent, _ = strconv.ParseFloat(m[1], 64)
+	}
+
+	m := freeMemory.FindStringSubmatch(outputs["RAMFREE"])
+	if m == nil {
+		m = anyMemory.FindStringSubmatch(outputs["RAMFREE"])
+	}
+
+	if m != nil {
+		n, _ := strconv.ParseFloat(m[1], 64)
+		unit := strings.ToLower(m[2])
+
+		if unit == "%" {
+			tel.RAMFreePercent = n
+		} else {
+			tel.RAMFreeBytes = int64(n * memoryScales[unit])
+		}
+	}
+
+	tel.Programs = parsePrograms(outputs["PROGCOMMENTS"], outputs["PROGREGISTER"])
+	return tel
+}
+
+// parseUptime understands "12 days, 3 hours, 4 minutes, 5 seconds" and "12 days 03:04:05"
+func parseUptime(output string) int64 {
+	if m := uptimeClock.FindStringSubmatch(output); m != nil {
+		days, _ := strconv.ParseInt(m[1], 10, 64)
+		hours, _ := strconv.ParseInt(m[2], 10, 64)
+		minutes, _ := strconv.ParseInt(m[3], 10, 64)
+		seconds, _ := strconv.ParseInt(m[4], 10, 64)
+
+		return ((days*24+hours)*60+minutes)*60 + seconds
+	}
+
+	var total int64
+	for _, m := range uptimeUnit.FindAllStringSubmatch(output, -1) {
+		n, _ := strconv.ParseInt(m[1], 10, 64)
+
+		switch unit := strings.ToLower(m[2]); {
+		case strings.HasPrefix(unit, "d"):
+			total += n * 24 * 60 * 60
+		case strings.HasPrefix(unit, "h"):
+			total += n * 60 * 60
+		case strings.HasPrefix(unit, "m"):
+			total += n * 60
+		case strings.HasPrefix(unit, "s"):
+			total += n
+		}
+	}
+
+	return total
+}
+
+// parsePrograms pulls each slot's program file and compile date out of PROGCOMMENTS, and its
+// registration status out of PROGREGISTER. Processors with a single slot don't number it, so
+// it is treated as slot 1.
+func parsePrograms(comments, register string) []Program {
+	slots := make(map[int]*Program)
+	var order []int
+
+	slot := func(n int) *Program {
+		if p, ok := slots[n]; ok {
+			return p
+		}
+
+		slots[n] = &Program{Slot: n}
+		order = append(order, n)
+		return slots[n]
+	}
+
+	current := 1
+	for _, line := range strings.Split(comments, "\n") {
+		if m := programSlot.FindStringSubmatch(line); m != nil {
+			current, _ = strconv.Atoi(m[1])
+			continue
+		}
+
+		for _, f := range parseFields(line) {
+			switch f.Key {
+			case "program file", "source file":
+				if p := slot(current); len(p.File) == 0 || f.Key == "program file" {
+					p.File = f.Value
+				}
+			case "compiled on", "compile date", "compiled":
+				slot(current).CompiledOn = f.Value
+			}
+		}
+	}
+
+	for _, line := range strings.Split(register, "\n") {
+		m := programSlot.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		n, _ := strconv.Atoi(m[1])
+		status := strings.ToLower(strings.TrimSpace(m[2]))
+
+		switch {
+		case strings.Contains(status, "unregistered") || strings.Contains(status, "not registered"):
+			slot(n).Registered = "false"
+		case strings.Contains(status, "registered"):
+			slot(n).Registered = "true"
+		}
+	}
+
+	var programs []Program
+	for _, n := range order {
+		programs = append(programs, *slots[n])
+	}
+
+	return programs
+}
@@ -0,0 +1,81 @@
+package collector
+
+import (
+	"reflect"
+	"testing"
+)
+
+var uptimeTests = []struct {
+	output string
+	want   int64
+}{
+	{"The system has been running for 12 days, 3 hours, 4 minutes, 5 seconds.", 12*86400 + 3*3600 + 4*60 + 5},
+	{"Uptime: 2 days 03:04:05", 2*86400 + 3*3600 + 4*60 + 5},
+	{"Uptime: 03:04", 3*3600 + 4*60},
+	{"1 hour, 1 min", 3660},
+	{"", 0},
+}
+
+func TestParseUptime(t *testing.T) {
+	for _, tt := range uptimeTests {
+		if got := parseUptime(tt.output); got != tt.want {
+			t.Errorf("parseUptime(%q) = %v, want %v", tt.output, got, tt.want)
+		}
+	}
+}
+
+var telemetryTests = []struct {
+	name    string
+	outputs map[string]string
+	want    Telemetry
+}{
+	{
+		name: "Bytes",
+		outputs: map[string]string{
+			"CPULOAD": "CPU utilization: 12.5%, Maximum: 45%",
+			"RAMFREE": "Total RAM: 1048576 KB\nFree RAM: 512 MB",
+		},
+		want: Telemetry{
+			CPULoadPercent: 12.5,
+			RAMFreeBytes:   512 << 20,
+		},
+	},
+	{
+		name: "Percent",
+		outputs: map[string]string{
+			"RAMFREE": "45% free",
+		},
+		want: Telemetry{
+			RAMFreePercent: 45,
+		},
+	},
+	{
+		name: "Programs",
+		outputs: map[string]string{
+			"PROGCOMMENTS": "Program Boot Directory : \\SIMPL\\app01\n" +
+				"Source File : C:\\Programs\\ITB-1101.smw\n" +
+				"Program File : ITB-1101.spz\n" +
+				"Compiled On : 04/01/2021 10:00\n" +
+				"Program 2\n" +
+				"Source File : C:\\Programs\\ITB-1101-slot2.smw\n",
+			"PROGREGISTER": "Program 01: Registered\nProgram 02: Unregistered\n",
+		},
+		want: Telemetry{
+			Programs: []Program{
+				{Slot: 1, File: "ITB-1101.spz", CompiledOn: "04/01/2021 10:00", Registered: "true"},
+				{Slot: 2, File: "C:\\Programs\\ITB-1101-slot2.smw", Registered: "false"},
+			},
+		},
+	},
+}
+
+func TestParseTelemetry(t *testing.T) {
+	for _, tt := range telemetryTests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseTelemetry(tt.outputs)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package crestrontelnet
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/byuoitav/common/log"
+	"github.com/byuoitav/crestron-telnet-microservice/inventory"
+)
+
+// CommandResult is the outcome of a single console command run through RunCommands
+type CommandResult struct {
+	Command    string `json:"command"`
+	Output     string `json:"output"`
+	DurationMS int64  `json:"duration-ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+var (
+	allowlistMu      sync.RWMutex
+	commandAllowlist []string
+
+	// only one ad-hoc session per device at a time, consoles only allow a few connections.
+	// Locks are removed by ForgetDevice once the device isn't monitored anymore.
+	commandLocksMu sync.Mutex
+	commandLocks   = make(map[string]*sync.Mutex)
+)
+
+// SetCommandAllowlist sets which commands RunCommands may run. Each entry is a command that is
+// allowed exactly (e.g. IPCONFIG), or ends in a * to also allow it with arguments (e.g. PING *).
+// Commands are matched case insensitively, and nothing is allowed until this is set.
+func SetCommandAllowlist(list []string) {
+	allowlistMu.Lock()
+	defer allowlistMu.Unlock()
+
+	commandAllowlist = nil
+	for _, entry := range list {
+		entry = strings.TrimSpace(entry)
+		if len(entry) > 0 {
+			commandAllowlist = append(commandAllowlist, entry)
+		}
+	}
+}
+
+// CommandAllowed returns nil if cmd is on the allowlist, or an error saying why it isn't
+func CommandAllowed(cmd string) error {
+	if strings.ContainsAny(cmd, "\r\n") {
+		return fmt.Errorf("%q contains a line break", cmd)
+	}
+
+	normalized := strings.ToUpper(strings.Join(strings.Fields(cmd), " "))
+	if len(normalized) == 0 {
+		return fmt.Errorf("command is empty")
+	}
+
+	allowlistMu.RLock()
+	defer allowlistMu.RUnlock()
+
+	for _, entry := range commandAllowlist {
+		entry = strings.ToUpper(entry)
+
+		if prefix := strings.TrimSuffix(entry, "*"); prefix != entry {
+			prefix = strings.TrimSpace(prefix)
+			if normalized == prefix || strings.HasPrefix(normalized, prefix+" ") {
+				return nil
+			}
+
+			continue
+		}
+
+		if normalized == strings.Join(strings.Fields(entry), " ") {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%q is not an allowed command", cmd)
+}
+
+// RunCommands opens a separate session to dev and runs each command in order, returning the
+// output of each. Every command must be allowed by the allowlist. If a command fails, the
+// session can't be trusted anymore, so the commands after it are not run.
+func RunCommands(ctx context.Context, dev inventory.Device, commands []string) ([]CommandResult, error) {
+	for _, cmd := range commands {
+		if err := CommandAllowed(cmd); err != nil {
+			return nil, err
+		}
+	}
+
+	lock := commandLock(dev.Hostname)
+	lock.Lock()
+	defer lock.Unlock()
+
+	session, err := OpenSession(ctx, dev)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open session: %s", err)
+	}
+	defer session.Close()
+
+	results := make([]CommandResult, 0, len(commands))
+	var failed error
+
+	for _, cmd := range commands {
+		result := CommandResult{
+			Command: cmd,
+		}
+
+		if failed != nil {
+			result.Error = fmt.Sprintf("not run: %s", failed)
+			results = append(results, result)
+			continue
+		}
+
+		log.L.Infof("Running %q on %s", cmd, dev.Hostname)
+
+		start := time.Now()
+		result.Output, err = session.Execute(ctx, cmd)
+		result.DurationMS = time.Since(start).Nanoseconds() / int64(time.Millisecond)
+
+		if err != nil {
+			failed = err
+			result.Error = err.Error()
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func commandLock(hostname string) *sync.Mutex {
+	commandLocksMu.Lock()
+	defer commandLocksMu.Unlock()
+
+	lock, ok := commandLocks[hostname]
+	if !ok {
+		lock = &sync.Mutex{}
+		commandLocks[hostname] = lock
+	}
+
+	return lock
+}
+
+func forgetCommandLock(hostname string) {
+	commandLocksMu.Lock()
+	defer commandLocksMu.Unlock()
+
+	delete(commandLocks, hostname)
+}
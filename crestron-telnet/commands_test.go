@@ -0,0 +1,78 @@
+package crestrontelnet
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func setCommandAllowlist(list ...string) func() {
+	allowlistMu.RLock()
+	prev := commandAllowlist
+	allowlistMu.RUnlock()
+
+	SetCommandAllowlist(list)
+
+	return func() { SetCommandAllowlist(prev) }
+}
+
+func TestCommandAllowed(t *testing.T) {
+	defer setCommandAllowlist("IPCONFIG", " ping * ", "ERR LOG", "")()
+
+	tests := []struct {
+		cmd     string
+		allowed bool
+	}{
+		{cmd: "IPCONFIG", allowed: true},
+		{cmd: "ipconfig", allowed: true},
+		{cmd: "  IPCONFIG  ", allowed: true},
+		{cmd: "IPCONFIG /ALL", allowed: false},
+		{cmd: "PING", allowed: true},
+		{cmd: "PING 10.5.34.1", allowed: true},
+		{cmd: "ping   10.5.34.1", allowed: true},
+		{cmd: "PINGALL", allowed: false},
+		{cmd: "ERR   LOG", allowed: true},
+		{cmd: "ERR", allowed: false},
+		{cmd: "REBOOT", allowed: false},
+		{cmd: "", allowed: false},
+		{cmd: "IPCONFIG\r\nREBOOT", allowed: false},
+		{cmd: "PING 10.5.34.1\nREBOOT", allowed: false},
+	}
+
+	for _, tt := range tests {
+		err := CommandAllowed(tt.cmd)
+		if tt.allowed && err != nil {
+			t.Errorf("%q: got %s, expected it to be allowed", tt.cmd, err)
+		}
+
+		if !tt.allowed && err == nil {
+			t.Errorf("%q: allowed, expected it not to be", tt.cmd)
+		}
+	}
+}
+
+func TestNothingAllowedByDefault(t *testing.T) {
+	defer setCommandAllowlist()()
+
+	if err := CommandAllowed("IPCONFIG"); err == nil {
+		t.Errorf("allowed a command without an allowlist")
+	}
+}
+
+func TestRunCommandsRejectsDisallowed(t *testing.T) {
+	defer setCommandAllowlist("IPCONFIG")()
+
+	// nothing is listening here, so it would fail differently if it tried to connect
+	dev := testDevice("")
+	dev.Address = "127.0.0.1"
+	dev.Port = "1"
+
+	results, err := RunCommands(context.Background(), dev, []string{"IPCONFIG", "REBOOT"})
+	if err == nil || !strings.Contains(err.Error(), "not an allowed command") {
+		t.Errorf("got %v and %+v, expected REBOOT to be rejected", err, results)
+	}
+
+	if results != nil {
+		t.Errorf("got %+v, expected nothing to be run", results)
+	}
+}
@@ -0,0 +1,478 @@
+package crestrontelnet
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/byuoitav/common/log"
+	"github.com/byuoitav/common/v2/events"
+	"github.com/byuoitav/crestron-telnet-microservice/inventory"
+)
+
+// connection states
+const (
+	// StateConnecting is a device that hasn't been connected to yet
+	StateConnecting = "connecting"
+
+	// StateOnline is a device that is connected and responding normally
+	StateOnline = "online"
+
+	// StateDegraded is a device that is connected but responding badly, or that was
+	// connected and has been unreachable for less than the offline grace period
+	StateDegraded = "degraded"
+
+	// StateOffline is a device that has been unreachable for longer than the offline grace period
+	StateOffline = "offline"
+)
+
+// Connectivity is the connection state of a single device, and what was last heard from it
+type Connectivity struct {
+	State          string      `json:"state"`
+	Since          time.Time   `json:"since"`
+	Reason         string      `json:"reason,omitempty"`
+	ConnectedSince time.Time   `json:"connected-since,omitempty"`
+	FailingSince   time.Time   `json:"failing-since,omitempty"`
+	Reconnects     int         `json:"reconnects"`
+	Retry          RetryStatus `json:"retry"`
+
+	LastError     string    `json:"last-error,omitempty"`
+	LastErrorTime time.Time `json:"last-error-time,omitempty"`
+
+	// LastLine is the last line read from the device's console
+	LastLine     string    `json:"last-line,omitempty"`
+	LastLineTime time.Time `json:"last-line-time,omitempty"`
+
+	LastEvent *SentEvent `json:"last-event,omitempty"`
+}
+
+// SentEvent is an event that was sent about a device
+type SentEvent struct {
+	Time  time.Time `json:"time"`
+	Key   string    `json:"key"`
+	Value string    `json:"value"`
+}
+
+// RetryStatus is where a device is in its reconnect policy
+type RetryStatus struct {
+	// Attempts is how many times in a row reconnecting has been scheduled since the device
+	// last stayed connected for at least the stable connection time
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next-attempt,omitempty"`
+
+	Policy inventory.RetryPolicy `json:"policy"`
+}
+
+var (
+	offlineGracePeriod = 2 * time.Minute
+
+	// stableConnection is how long a connection has to stay up before the reconnect backoff starts over,
+	// so that a device that accepts connections and then drops them right away still backs off
+	stableConnection = time.Minute
+
+	connectivityMu sync.Mutex
+	connectivities = make(map[string]*connectivity)
+)
+
+// connectivity tracks a device's connection state, sending events when it changes
+type connectivity struct {
+	dev    inventory.Device
+	policy inventory.RetryPolicy
+
+	// dmps is whether the device is a DMPS, whose events go through the event rules
+	dmps bool
+
+	// stream passes on what is received and sent to anyone streaming the device
+	stream *broadcaster
+
+	mu     sync.Mutex
+	status Connectivity
+	timer  *time.Timer
+
+	// sendMu is taken before mu is released to send a transition's events,
+	// so that they are sent in order without holding mu while sending
+	sendMu sync.Mutex
+
+	// sessionErr is why sessions opened to the device alongside its monitor (e.g. by a collector)
+	// aren't working, empty if they are. The device can't be online until they work again.
+	sessionErr string
+
+	// the last online and responsive values that were sent, empty until the first is sent
+	online     string
+	responsive string
+}
+
+// SetOfflineGracePeriod sets how long a device has to be unreachable before it is considered offline
+func SetOfflineGracePeriod(d time.Duration) {
+	offlineGracePeriod = d
+}
+
+// ConnectivityStatus returns the connection state of the device being monitored as hostname
+func ConnectivityStatus(hostname string) (Connectivity, bool) {
+	connectivityMu.Lock()
+	c, ok := connectivities[hostname]
+	connectivityMu.Unlock()
+
+	if !ok {
+		return Connectivity{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.status, true
+}
+
+// ForgetDevice drops everything kept about hostname once it is no longer monitored: its debug logs are
+// turned off, anyone streaming it is disconnected, and its command lock and dropped events are removed.
+func ForgetDevice(hostname string) {
+	StopMonitoringDevice(hostname)
+	forgetBroadcaster(hostname)
+	forgetCommandLock(hostname)
+	forgetDrops(hostname)
+}
+
+// trackConnectivity starts tracking dev's connection state. dmps is whether dev is a DMPS, whose events go
+// through the event rules. stop must be called once dev is no longer monitored.
+func trackConnectivity(dev inventory.Device, dmps bool) *connectivity {
+	policy := retryPolicyFor(dev)
+
+	c := &connectivity{
+		dev:    dev,
+		dmps:   dmps,
+		policy: policy,
+		stream: broadcasterFor(dev.Hostname),
+		status: Connectivity{
+			State: StateConnecting,
+			Since: time.Now(),
+			Retry: RetryStatus{
+				Policy: policy,
+			},
+		},
+	}
+
+	connectivityMu.Lock()
+	connectivities[dev.Hostname] = c
+	connectivityMu.Unlock()
+
+	connectionState.WithLabelValues(dev.Hostname, StateConnecting).Set(1)
+	return c
+}
+
+// stop stops tracking the device
+func (c *connectivity) stop() {
+	c.mu.Lock()
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	c.mu.Unlock()
+
+	connectivityMu.Lock()
+	if connectivities[c.dev.Hostname] == c {
+		delete(connectivities, c.dev.Hostname)
+		forgetDeviceMetrics(c.dev.Hostname)
+	}
+	connectivityMu.Unlock()
+}
+
+// debugging returns whether the device's monitor should elevate its logs
+func (c *connectivity) debugging() bool {
+	return IsMonitoringDevice(c.dev.Hostname)
+}
+
+// connected records that the device is connected and responding normally
+func (c *connectivity) connected() {
+	c.mu.Lock()
+
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+
+	if c.status.ConnectedSince.IsZero() {
+		c.status.ConnectedSince = time.Now()
+	}
+
+	c.status.FailingSince = time.Time{}
+	c.status.Retry.NextAttempt = time.Time{}
+
+	if len(c.sessionErr) > 0 {
+		c.unlockAndSend(c.set(StateDegraded, c.sessionErr))
+		return
+	}
+
+	c.unlockAndSend(c.set(StateOnline, ""))
+}
+
+// unhealthy records that the device is connected, but isn't responding the way it should
+func (c *connectivity) unhealthy(reason string) {
+	c.mu.Lock()
+
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+
+	if c.status.ConnectedSince.IsZero() {
+		c.status.ConnectedSince = time.Now()
+	}
+
+	c.status.FailingSince = time.Time{}
+	c.failed(reason)
+	c.unlockAndSend(c.set(StateDegraded, reason))
+}
+
+// disconnected records that the device couldn't be reached. If it stays unreachable for
+// the offline grace period, it is marked offline. If it had stayed connected for the stable
+// connection time, its reconnect backoff starts over.
+func (c *connectivity) disconnected(reason string) {
+	c.mu.Lock()
+
+	if c.status.FailingSince.IsZero() {
+		c.status.FailingSince = time.Now()
+	}
+
+	if !c.status.ConnectedSince.IsZero() && time.Since(c.status.ConnectedSince) >= stableConnection {
+		c.status.Retry.Attempts = 0
+	}
+
+	c.status.ConnectedSince = time.Time{}
+	c.failed(reason)
+
+	var xs []events.Event
+
+	switch c.status.State {
+	case StateOffline:
+		c.status.Reason = reason
+		c.mu.Unlock()
+		return
+	case StateConnecting:
+		// we don't know that it was ever up, so wait for the grace period to say anything
+		c.status.Reason = reason
+	default:
+		xs = c.set(StateDegraded, reason)
+	}
+
+	if c.timer == nil {
+		var timer *time.Timer
+
+		// timer is only read once c.mu is held, which is after it has been assigned
+		timer = time.AfterFunc(offlineGracePeriod-time.Since(c.status.FailingSince), func() {
+			c.gracePeriodExpired(&timer)
+		})
+
+		c.timer = timer
+	}
+
+	c.unlockAndSend(xs)
+}
+
+// ReportSession records whether a session opened to hostname alongside its monitor (e.g. by a collector)
+// is working, err being why it isn't. A connected device whose sessions fail is degraded until they work again,
+// and rejected logins are reported the same way the monitor reports them.
+func ReportSession(hostname string, err error) {
+	connectivityMu.Lock()
+	c, ok := connectivities[hostname]
+	connectivityMu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	if err == nil {
+		c.sessionWorking()
+		return
+	}
+
+	c.connectionFailed(err)
+	c.sessionFailed(err.Error())
+}
+
+// sessionFailed records that a session opened alongside the monitor isn't working
+func (c *connectivity) sessionFailed(reason string) {
+	c.mu.Lock()
+
+	c.sessionErr = reason
+	c.failed(reason)
+
+	var xs []events.Event
+	if c.status.State == StateOnline {
+		xs = c.set(StateDegraded, reason)
+	}
+
+	c.unlockAndSend(xs)
+}
+
+// sessionWorking records that sessions opened alongside the monitor are working again,
+// bringing the device back online if they were the only reason it was degraded
+func (c *connectivity) sessionWorking() {
+	c.mu.Lock()
+
+	reason := c.sessionErr
+	c.sessionErr = ""
+
+	var xs []events.Event
+	if len(reason) > 0 && c.status.State == StateDegraded && c.status.Reason == reason && c.status.FailingSince.IsZero() {
+		xs = c.set(StateOnline, "")
+	}
+
+	c.unlockAndSend(xs)
+}
+
+// received records a line read from the device, passing it on to anyone streaming the device
+func (c *connectivity) received(line string) {
+	c.stream.line(line)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.status.LastLine = strings.TrimSpace(line)
+	c.status.LastLineTime = time.Now()
+}
+
+// sent records an event that was sent about the device, passing it on to anyone streaming the device
+func (c *connectivity) sent(x events.Event) {
+	c.stream.event(x)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.status.LastEvent = &SentEvent{
+		Time:  time.Now(),
+		Key:   x.Key,
+		Value: x.Value,
+	}
+}
+
+// failed records the device's latest error. c.mu must be held.
+func (c *connectivity) failed(reason string) {
+	c.status.LastError = reason
+	c.status.LastErrorTime = time.Now()
+}
+
+// retry schedules the next attempt to reconnect, returning how long to wait before making it.
+// The wait is never less than min. If the device has used up its retry policy's max attempts,
+// it is marked offline right away.
+func (c *connectivity) retry(min time.Duration) time.Duration {
+	c.mu.Lock()
+
+	c.status.Retry.Attempts++
+	c.status.Reconnects++
+	reconnects.WithLabelValues(c.dev.Hostname).Inc()
+
+	wait := retryDelay(c.policy, c.status.Retry.Attempts)
+	if wait < min {
+		wait = min
+	}
+
+	c.status.Retry.NextAttempt = time.Now().Add(wait)
+
+	var xs []events.Event
+	if max := maxAttempts(c.policy); max > 0 && c.status.Retry.Attempts >= max {
+		xs = c.offline(c.status.Reason)
+	}
+
+	c.unlockAndSend(xs)
+	return wait
+}
+
+// offline marks the device offline without waiting for the grace period,
+// returning the events to send about it. c.mu must be held.
+func (c *connectivity) offline(reason string) []events.Event {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+
+	return c.set(StateOffline, reason)
+}
+
+func (c *connectivity) gracePeriodExpired(timer **time.Timer) {
+	c.mu.Lock()
+
+	// it may have come back (or been stopped) while the timer was firing
+	if c.timer != *timer {
+		c.mu.Unlock()
+		return
+	}
+
+	c.unlockAndSend(c.offline(c.status.Reason))
+}
+
+// set changes the device's state, returning the events to send for whatever changed. c.mu must be held.
+func (c *connectivity) set(state, reason string) []events.Event {
+	prev := c.status
+	c.status.Reason = reason
+
+	if prev.State == state {
+		return nil
+	}
+
+	c.status.State = state
+	c.status.Since = time.Now()
+
+	connectionState.WithLabelValues(c.dev.Hostname, prev.State).Set(0)
+	connectionState.WithLabelValues(c.dev.Hostname, state).Set(1)
+
+	log.L.Infof("%s is now %s (was %s)", c.dev.Hostname, state, prev.State)
+
+	var xs []events.Event
+
+	if x, ok := DeviceEvent(c.dev, "connection-state", state, "health", "auto-generated", "connectivity"); ok {
+		x.Data = map[string]string{
+			"previous": prev.State,
+			"reason":   reason,
+		}
+		xs = append(xs, x)
+	}
+
+	online := strconv.FormatBool(state != StateOffline)
+	if state != StateConnecting && online != c.online {
+		c.online = online
+		if x, ok := DeviceEvent(c.dev, "online", online, "health", "auto-generated", "connectivity"); ok {
+			xs = append(xs, x)
+		}
+	}
+
+	responsive := strconv.FormatBool(state == StateOnline)
+	if state != StateConnecting && responsive != c.responsive {
+		c.responsive = responsive
+		if x, ok := DeviceEvent(c.dev, "responsive", responsive, "health", "auto-generated", "connectivity"); ok {
+			xs = append(xs, x)
+		}
+	}
+
+	return xs
+}
+
+// unlockAndSend releases c.mu and then sends xs, so that sending (which can be slow)
+// doesn't hold up anyone reading the device's status. c.mu must be held.
+func (c *connectivity) unlockAndSend(xs []events.Event) {
+	if len(xs) == 0 {
+		c.mu.Unlock()
+		return
+	}
+
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	c.mu.Unlock()
+
+	for _, x := range xs {
+		c.send(x)
+	}
+}
+
+// send sends x, an event about the device, through the event rules if the device is a DMPS
+func (c *connectivity) send(x events.Event) {
+	if c.dmps {
+		sendDMPSEvent(c.dev.Hostname, x, "")
+		return
+	}
+
+	if nerr := sendEvent(x); nerr != nil {
+		log.L.Warnf("Error sending event %v", nerr.Error())
+	}
+}
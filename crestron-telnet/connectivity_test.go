@@ -0,0 +1,296 @@
+package crestrontelnet
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/byuoitav/crestron-telnet-microservice/inventory"
+	"github.com/byuoitav/crestron-telnet-microservice/queue"
+)
+
+// captureEvents sends every event to a queue that the test can read from, instead of an event processor
+func captureEvents(t *testing.T) (*queue.Queue, func()) {
+	t.Helper()
+
+	q, err := queue.Open("", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev := destinations
+	destinations = []*destination{{url: "test", queue: q}}
+
+	return q, func() { destinations = prev }
+}
+
+// sentEvents returns key=value for every event that has been sent since it was last called
+func sentEvents(q *queue.Queue) []string {
+	sent := []string{}
+	for _, e := range q.Take(1000) {
+		sent = append(sent, e.Event.Key+"="+e.Event.Value)
+	}
+
+	return sent
+}
+
+func setGracePeriod(d time.Duration) func() {
+	prev := offlineGracePeriod
+	offlineGracePeriod = d
+
+	return func() { offlineGracePeriod = prev }
+}
+
+func waitForState(t *testing.T, hostname, state string) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		status, _ := ConnectivityStatus(hostname)
+		if status.State == state {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s to be %s, it is %s", hostname, state, status.State)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConnectivityTransitions(t *testing.T) {
+	q, restore := captureEvents(t)
+	defer restore()
+	defer setGracePeriod(50 * time.Millisecond)()
+
+	dev := testDevice("")
+	c := trackConnectivity(dev, false)
+	defer c.stop()
+
+	steps := []struct {
+		name  string
+		do    func()
+		state string
+		sent  []string
+	}{
+		{
+			name:  "Connected",
+			do:    c.connected,
+			state: StateOnline,
+			sent:  []string{"connection-state=online", "online=true", "responsive=true"},
+		},
+		{
+			name:  "Unhealthy",
+			do:    func() { c.unhealthy("Bad or Incomplete Command") },
+			state: StateDegraded,
+			sent:  []string{"connection-state=degraded", "responsive=false"},
+		},
+		{
+			name:  "Recovered",
+			do:    c.connected,
+			state: StateOnline,
+			sent:  []string{"connection-state=online", "responsive=true"},
+		},
+		{
+			name:  "StillOnline",
+			do:    c.connected,
+			state: StateOnline,
+			sent:  []string{},
+		},
+		{
+			name:  "Disconnected",
+			do:    func() { c.disconnected("connection refused") },
+			state: StateDegraded,
+			sent:  []string{"connection-state=degraded", "responsive=false"},
+		},
+		{
+			name:  "GracePeriodExpired",
+			do:    func() { waitForState(t, dev.Hostname, StateOffline) },
+			state: StateOffline,
+			sent:  []string{"connection-state=offline", "online=false"},
+		},
+		{
+			name:  "StillOffline",
+			do:    func() { c.disconnected("connection refused") },
+			state: StateOffline,
+			sent:  []string{},
+		},
+		{
+			name:  "BackOnline",
+			do:    c.connected,
+			state: StateOnline,
+			sent:  []string{"connection-state=online", "online=true", "responsive=true"},
+		},
+	}
+
+	for _, step := range steps {
+		step.do()
+
+		status, ok := ConnectivityStatus(dev.Hostname)
+		if !ok {
+			t.Fatalf("%s: %s isn't being tracked", step.name, dev.Hostname)
+		}
+
+		if status.State != step.state {
+			t.Errorf("%s: got state %s, expected %s", step.name, status.State, step.state)
+		}
+
+		if got := sentEvents(q); !reflect.DeepEqual(got, step.sent) {
+			t.Errorf("%s: sent %v, expected %v", step.name, got, step.sent)
+		}
+	}
+}
+
+func TestConnectingWaitsForGracePeriod(t *testing.T) {
+	q, restore := captureEvents(t)
+	defer restore()
+	defer setGracePeriod(50 * time.Millisecond)()
+
+	dev := testDevice("")
+	c := trackConnectivity(dev, false)
+	defer c.stop()
+
+	// it has never been up, so nothing is said about it until the grace period is up
+	c.disconnected("connection refused")
+
+	if got := sentEvents(q); len(got) != 0 {
+		t.Errorf("sent %v before the grace period was up, expected nothing", got)
+	}
+
+	if status, _ := ConnectivityStatus(dev.Hostname); status.State != StateConnecting || status.Reason != "connection refused" {
+		t.Errorf("got %s (%s), expected connecting (connection refused)", status.State, status.Reason)
+	}
+
+	waitForState(t, dev.Hostname, StateOffline)
+
+	want := []string{"connection-state=offline", "online=false", "responsive=false"}
+	if got := sentEvents(q); !reflect.DeepEqual(got, want) {
+		t.Errorf("sent %v, expected %v", got, want)
+	}
+}
+
+func TestReconnectingCancelsGracePeriod(t *testing.T) {
+	q, restore := captureEvents(t)
+	defer restore()
+	defer setGracePeriod(50 * time.Millisecond)()
+
+	dev := testDevice("")
+	c := trackConnectivity(dev, false)
+	defer c.stop()
+
+	c.connected()
+	c.disconnected("connection reset")
+	c.connected()
+	sentEvents(q)
+
+	time.Sleep(100 * time.Millisecond)
+
+	if status, _ := ConnectivityStatus(dev.Hostname); status.State != StateOnline {
+		t.Errorf("got %s after reconnecting within the grace period, expected online", status.State)
+	}
+
+	if got := sentEvents(q); len(got) != 0 {
+		t.Errorf("sent %v after reconnecting within the grace period, expected nothing", got)
+	}
+}
+
+func TestMaxAttemptsMarksOffline(t *testing.T) {
+	q, restore := captureEvents(t)
+	defer restore()
+	defer setGracePeriod(time.Hour)()
+
+	dev := testDevice("")
+	dev.Retry = &inventory.RetryPolicy{
+		MaxAttempts: intPtr(2),
+	}
+
+	c := trackConnectivity(dev, false)
+	defer c.stop()
+
+	c.connected()
+	c.disconnected("connection refused")
+	c.retry(0)
+	sentEvents(q)
+
+	if status, _ := ConnectivityStatus(dev.Hostname); status.State != StateDegraded || status.Retry.Attempts != 1 {
+		t.Errorf("got %s after %v attempts, expected degraded after 1", status.State, status.Retry.Attempts)
+	}
+
+	c.disconnected("connection refused")
+	c.retry(0)
+
+	if status, _ := ConnectivityStatus(dev.Hostname); status.State != StateOffline {
+		t.Errorf("got %s after using up the max attempts, expected offline", status.State)
+	}
+
+	want := []string{"connection-state=offline", "online=false"}
+	if got := sentEvents(q); !reflect.DeepEqual(got, want) {
+		t.Errorf("sent %v, expected %v", got, want)
+	}
+}
+
+func TestFailingSessionsDegradeDevice(t *testing.T) {
+	q, restore := captureEvents(t)
+	defer restore()
+
+	dev := testDevice("")
+	c := trackConnectivity(dev, true)
+	defer c.stop()
+
+	c.connected()
+	sentEvents(q)
+
+	authErr := &AuthError{Hostname: dev.Hostname, Reason: authBadLogin, Message: "Bad Password"}
+	ReportSession(dev.Hostname, authErr)
+
+	want := []string{"auth-failed=" + authBadLogin, "connection-state=degraded", "responsive=false"}
+	if got := sentEvents(q); !reflect.DeepEqual(got, want) {
+		t.Errorf("sent %v after a session failed, expected %v", got, want)
+	}
+
+	// the monitor is still connected, but that doesn't make up for the session
+	c.connected()
+
+	if status, _ := ConnectivityStatus(dev.Hostname); status.State != StateDegraded || status.Reason != authErr.Error() {
+		t.Errorf("got %s (%s) while sessions are failing, expected degraded (%s)", status.State, status.Reason, authErr)
+	}
+
+	ReportSession(dev.Hostname, nil)
+
+	want = []string{"connection-state=online", "responsive=true"}
+	if got := sentEvents(q); !reflect.DeepEqual(got, want) {
+		t.Errorf("sent %v once sessions worked again, expected %v", got, want)
+	}
+}
+
+func TestForgetDevice(t *testing.T) {
+	dev := testDevice("")
+
+	StartMonitoringDevice(dev.Hostname, time.Hour)
+	commandLock(dev.Hostname)
+	messages, unsubscribe := Subscribe(dev.Hostname)
+	defer unsubscribe()
+
+	ForgetDevice(dev.Hostname)
+
+	if IsMonitoringDevice(dev.Hostname) {
+		t.Errorf("debug logs are still on after the device was forgotten")
+	}
+
+	if _, ok := <-messages; ok {
+		t.Errorf("the stream is still open after the device was forgotten")
+	}
+
+	if _, ok := broadcasters.Load(dev.Hostname); ok {
+		t.Errorf("the broadcaster is still kept after the device was forgotten")
+	}
+
+	commandLocksMu.Lock()
+	_, ok := commandLocks[dev.Hostname]
+	commandLocksMu.Unlock()
+
+	if ok {
+		t.Errorf("the command lock is still kept after the device was forgotten")
+	}
+}
@@ -0,0 +1,124 @@
+package crestrontelnet
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/byuoitav/crestron-telnet-microservice/inventory"
+)
+
+// DefaultCredentials is the name of the credential set used by devices that don't name one
+const DefaultCredentials = "default"
+
+// Credentials are the username and password used to log in to a device's console
+type Credentials struct {
+	Username string
+	Password string
+}
+
+var (
+	credentialsMu  sync.RWMutex
+	credentialSets = make(map[string]Credentials)
+)
+
+// LoadCredentials builds the credential sets devices can log in with.
+//
+// The default set comes from CRESTRON_USERNAME and either CRESTRON_PASSWORD or
+// CRESTRON_PASSWORD_FILE. If dir is set, each subdirectory of it is another set, named
+// after the subdirectory and holding a username and a password file (the layout of a
+// mounted kubernetes secret), e.g.
+//
+//	/etc/crestron-credentials/secured-processors/username
+//	/etc/crestron-credentials/secured-processors/password
+func LoadCredentials(dir string) (map[string]Credentials, error) {
+	sets := make(map[string]Credentials)
+
+	def := Credentials{
+		Username: os.Getenv("CRESTRON_USERNAME"),
+		Password: os.Getenv("CRESTRON_PASSWORD"),
+	}
+
+	if path := os.Getenv("CRESTRON_PASSWORD_FILE"); len(path) > 0 {
+		password, err := readSecret(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CRESTRON_PASSWORD_FILE: %s", err)
+		}
+
+		def.Password = password
+	}
+
+	if len(def.Username) > 0 || len(def.Password) > 0 {
+		sets[DefaultCredentials] = def
+	}
+
+	if len(dir) == 0 {
+		return sets, nil
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read credentials directory: %s", err)
+	}
+
+	for _, info := range infos {
+		// kubernetes mounts secrets with hidden ..data directories, skip them
+		if !info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+
+		var creds Credentials
+		if creds.Username, err = readSecret(filepath.Join(dir, info.Name(), "username")); err != nil {
+			return nil, fmt.Errorf("unable to read credential set %s: %s", info.Name(), err)
+		}
+
+		if creds.Password, err = readSecret(filepath.Join(dir, info.Name(), "password")); err != nil {
+			return nil, fmt.Errorf("unable to read credential set %s: %s", info.Name(), err)
+		}
+
+		sets[info.Name()] = creds
+	}
+
+	return sets, nil
+}
+
+// SetCredentials replaces the credential sets devices can log in with
+func SetCredentials(sets map[string]Credentials) {
+	credentialsMu.Lock()
+	defer credentialsMu.Unlock()
+
+	credentialSets = make(map[string]Credentials, len(sets))
+	for name, creds := range sets {
+		credentialSets[name] = creds
+	}
+}
+
+// credentialsFor returns the credentials dev should log in with
+func credentialsFor(dev inventory.Device) (Credentials, error) {
+	name := dev.Credentials
+	if len(name) == 0 {
+		name = DefaultCredentials
+	}
+
+	credentialsMu.RLock()
+	defer credentialsMu.RUnlock()
+
+	creds, ok := credentialSets[name]
+	if !ok {
+		return Credentials{}, fmt.Errorf("no credential set named %q has been loaded", name)
+	}
+
+	return creds, nil
+}
+
+func readSecret(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
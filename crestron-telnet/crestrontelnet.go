@@ -2,348 +2,308 @@ package crestrontelnet
 
 import (
 	"bufio"
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io/ioutil"
-	"net"
-	"net/http"
+	"io"
 	"os"
-	"regexp"
-	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/byuoitav/common/log"
-	"github.com/byuoitav/common/nerr"
-	"github.com/byuoitav/common/structs"
 	"github.com/byuoitav/common/v2/events"
+	eventparser "github.com/byuoitav/crestron-telnet-microservice/event-parser"
+	"github.com/byuoitav/crestron-telnet-microservice/inventory"
+	"github.com/byuoitav/crestron-telnet-microservice/naming"
+	"github.com/byuoitav/crestron-telnet-microservice/rules"
 )
 
 var (
-	eventProcessorHost   = os.Getenv("EVENT_PROCESSOR_HOST")
-	mutex                sync.Mutex
-	deviceToSetDebugLogs = make(map[string]bool)
+	eventProcessorHost = os.Getenv("EVENT_PROCESSOR_HOST")
+	resolver, _        = naming.NewResolver()
+	eventRules, _      = rules.NewEngine("")
 )
 
-//StartMonitoringDevice monitor device - these are simply for monitoring
-func StartMonitoringDevice(id string) {
-	mutex.Lock()
-	defer mutex.Unlock()
-	deviceToSetDebugLogs[id] = true
+//SetRuleEngine sets the rules that every DMPS event is run through before it is sent
+func SetRuleEngine(e *rules.Engine) {
+	eventRules = e
 }
 
-//StopMonitoringDevice monitor device
-func StopMonitoringDevice(id string) {
-	mutex.Lock()
-	defer mutex.Unlock()
-	deviceToSetDebugLogs[id] = false
+//SetNameResolver sets the resolver used to get building and room ids from hostnames
+func SetNameResolver(r *naming.Resolver) {
+	resolver = r
 }
 
-//IsMonitoringDevice monitor device
-func IsMonitoringDevice(id string) bool {
-	return false
+//UnresolvedNames returns the hostnames that haven't been able to be resolved into a building and room
+func UnresolvedNames() []naming.Unresolved {
+	return resolver.Unresolved()
+}
 
-	// this was slowing it down too much
-	// mutex.Lock()
-	// defer mutex.Unlock()
-	// val, present := deviceToSetDebugLogs[id]
-	// if present {
-	// 	return val
-	// }
+//MonitorDMPS monitors an individual DMPS until ctx is cancelled, reconnecting whenever the connection is lost
+func MonitorDMPS(ctx context.Context, dmps inventory.Device) {
+	dmps = withTransportDefaults(dmps)
 
-	// return false
-}
+	state := trackConnectivity(dmps, true)
+	defer state.stop()
 
-func init() {
-	if len(eventProcessorHost) == 0 {
-		log.L.Fatalf("EVENT_PROCESSOR_HOST is not set.")
-	}
-}
+	for {
+		monitor := state.debugging()
 
-//MonitorDMPS is the function to call in a go routine to monitor an individual DMPS
-func MonitorDMPS(dmps structs.DMPS, killChannel chan bool, waitG *sync.WaitGroup) {
-	if len(dmps.Port) == 0 || dmps.Port == "0" {
-		dmps.Port = "23"
-	}
+		if monitor {
+			log.L.Warnf("Connecting to %v on %v:%v over %v", dmps.Hostname, dmps.Address, dmps.Port, dmps.Transport)
+		} else {
+			log.L.Debugf("Connecting to %v on %v:%v over %v", dmps.Hostname, dmps.Address, dmps.Port, dmps.Transport)
+		}
+
+		conn, buf, err := StartConnection(ctx, dmps)
+		if err != nil {
+			if ctx.Err() != nil {
+				log.L.Debugf("Kill order received for %s", dmps.Hostname)
+				return
+			}
 
-	monitor := IsMonitoringDevice(dmps.Hostname)
+			log.L.Warnf("unable to start connection with %s: %s", dmps.Hostname, err)
+			state.disconnected(err.Error())
 
-	if monitor {
-		log.L.Warnf("Connecting to %v on %v:%v", dmps.Hostname, dmps.Address, dmps.Port)
-	} else {
-		log.L.Debugf("Connecting to %v on %v:%v", dmps.Hostname, dmps.Address, dmps.Port)
-	}
+			if !waitToRetry(ctx, state.retry(state.connectionFailed(err))) {
+				log.L.Debugf("Kill order received for %s", dmps.Hostname)
+				return
+			}
 
-	conn, buf, err := StartConnection(dmps.Address, dmps.Port)
-	if err != nil {
-		log.L.Warnf("unable to start connection with %s: %s", dmps.Hostname, err)
-		time.Sleep(5 * time.Second)
+			continue
+		}
 
-		select {
-		case <-killChannel:
+		state.connected()
+
+		err = readDMPSEvents(ctx, dmps, conn, buf, state)
+		conn.Close()
+
+		if ctx.Err() != nil {
 			log.L.Debugf("Kill order received for %s", dmps.Hostname)
-			waitG.Done()
-		default:
-			go MonitorDMPS(dmps, killChannel, waitG)
+			return
 		}
 
-		return
-	}
+		state.disconnected(err.Error())
 
-	defer conn.Close()
+		log.L.Warnf("Error for %s: [%s]", dmps.Hostname, err)
+		log.L.Warnf("Killing and restarting connection for %s", dmps.Hostname)
 
-	for {
-		select {
-		case <-killChannel:
+		if !waitToRetry(ctx, state.retry(0)) {
 			log.L.Debugf("Kill order received for %s", dmps.Hostname)
-			waitG.Done()
 			return
-		default:
 		}
+	}
+}
+
+// waitToRetry waits d before reconnecting, returning false if ctx is cancelled first
+func waitToRetry(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// readDMPSEvents reads and forwards events from an open DMPS connection until the connection fails or ctx is cancelled,
+// recording what was read and sent in state
+func readDMPSEvents(ctx context.Context, dmps inventory.Device, conn Conn, buf *bufio.ReadWriter, state *connectivity) error {
+	stop := closeOnDone(ctx, conn)
+	defer stop()
 
-		monitor = IsMonitoringDevice(dmps.Hostname)
+	for {
+		monitor := state.debugging()
 		conn.SetReadDeadline(time.Now().Add(90 * time.Second))
 		response, err := buf.ReadString('\n')
 		if err != nil {
-			log.L.Warnf("Error for %s: [%s]", dmps.Hostname, err)
-			log.L.Warnf("Killing and restarting connection for %s", dmps.Hostname)
-			go MonitorDMPS(dmps, killChannel, waitG)
-			return
+			return err
 		}
 
-		match, _ := regexp.MatchString("^~EVENT~", response)
-		if !match {
-			index := strings.Index(response, "~EVENT~")
-			if index > -1 {
-				response = response[index:]
-				match, _ = regexp.MatchString("^~EVENT~", response)
-			}
-		}
+		state.received(response)
 
-		if match {
+		if !eventparser.Contains(response) {
 			if monitor {
-				log.L.Warnf("Event Received: %s", response)
+				log.L.Warnf("Something else Received: %s", response)
 			} else {
-				log.L.Debugf("Event Received: %s", response)
+				log.L.Debugf("Something else Received: %s", response)
 			}
 
-			// trim off the leading and ending ~
-			response = strings.TrimSpace(response)
-			if strings.HasPrefix(response, "~") {
-				response = response[1:len(response)]
-			}
+			continue
+		}
 
-			if strings.HasSuffix(response, "~") {
-				response = response[0 : len(response)-1]
-			}
+		if monitor {
+			log.L.Warnf("Event Received: %s", response)
+		} else {
+			log.L.Debugf("Event Received: %s", response)
+		}
 
-			eventParts := strings.Split(response, "~")
-			for i := range eventParts {
-				eventParts[i] = strings.TrimSpace(eventParts[i])
-			}
+		event, err := eventparser.Parse(response)
+		if err != nil {
+			log.L.Warnf("Malformed Event Received from %s (%s): %s", dmps.Hostname, err, strings.TrimSpace(response))
+			eventsMalformed.Inc()
+			recordDrop(dmps.Hostname, Drop{
+				Time:   time.Now(),
+				Reason: "malformed: " + err.Error(),
+				Raw:    strings.TrimSpace(response),
+			})
+
+			continue
+		}
 
-			if monitor {
-				log.L.Warnf("Event Parts:%v,  %v", len(eventParts), eventParts)
-			} else {
-				log.L.Debugf("Event Parts:%v,  %v", len(eventParts), eventParts)
-			}
+		if monitor {
+			log.L.Warnf("Parsed Event: %+v", event)
+		} else {
+			log.L.Debugf("Parsed Event: %+v", event)
+		}
 
-			if len(eventParts) == 9 {
-				var x events.Event
+		lastEvent.WithLabelValues(dmps.Hostname).SetToCurrentTime()
+		eventsParsed.WithLabelValues(metricKey(event.Key)).Inc()
 
-				roomParts := strings.Split(eventParts[1], "-")
-				for i := range roomParts {
-					roomParts[i] = strings.TrimSpace(roomParts[i])
-				}
+		if event.TimestampErr != nil {
+			log.L.Warnf("Event from %s has a bad timestamp (%s), using the time it was received: %s", dmps.Hostname, event.TimestampErr, event.Raw)
+			event.Timestamp = time.Now()
+		}
 
-				x.GeneratingSystem = eventParts[1]                       //hostname
-				x.Timestamp, _ = time.Parse(time.RFC3339, eventParts[3]) //timestamp
-				x.EventTags = []string{
-					strings.Replace(strings.ToLower(eventParts[4]), " ", "-", -1),
-					strings.Replace(strings.ToLower(eventParts[5]), " ", "-", -1),
-					strings.Replace(strings.ToLower(eventParts[7]), " ", "-", -1)}
-
-				//TargetDevice
-				x.TargetDevice = events.BasicDeviceInfo{
-					BasicRoomInfo: events.BasicRoomInfo{
-						BuildingID: roomParts[0],
-						RoomID:     roomParts[0] + "-" + roomParts[1],
-					},
-					DeviceID: roomParts[0] + "-" + roomParts[1] + "-" + eventParts[6],
-				}
+		names, err := resolver.Resolve(event.Hostname, dmps.Naming)
+		if err != nil {
+			log.L.Warnf("Unable to resolve names for event from %s: %s", dmps.Hostname, err)
+			recordDrop(dmps.Hostname, Drop{
+				Time:   time.Now(),
+				Reason: "unresolved-name",
+				Key:    event.Key,
+				Value:  event.Value,
+				Raw:    event.Raw,
+			})
+
+			continue
+		}
 
-				//AffectedRoom
-				x.AffectedRoom = events.BasicRoomInfo{
-					BuildingID: roomParts[0],
-					RoomID:     roomParts[0] + "-" + roomParts[1],
-				}
+		var x events.Event
 
-				x.Key = strings.Replace(strings.ToLower(eventParts[7]), " ", "-", -1) //eventKeyInfo
-				x.Value = eventParts[8]                                               //eventKeyValue
-				x.User = ""
-				x.Data = response
+		x.GeneratingSystem = event.Hostname
+		x.Timestamp = event.Timestamp
+		x.EventTags = []string{
+			eventparser.Normalize(event.Tags[0]),
+			eventparser.Normalize(event.Tags[1]),
+			eventparser.Normalize(event.Key)}
 
-				shouldSendEvent := modifyEvent(&x)
-				if !shouldSendEvent {
-					log.L.Debugf("Ignoring event")
-					return
-				}
+		//TargetDevice
+		x.TargetDevice = events.BasicDeviceInfo{
+			BasicRoomInfo: events.BasicRoomInfo{
+				BuildingID: names.BuildingID,
+				RoomID:     names.RoomID,
+			},
+			DeviceID: names.Device(event.DeviceSuffix),
+		}
 
-				if monitor {
-					log.L.Warnf("Sending request to state parser [%v]", x)
-				} else {
-					log.L.Debugf("Sending request to state parser [%v]", x)
-				}
+		//AffectedRoom
+		x.AffectedRoom = events.BasicRoomInfo{
+			BuildingID: names.BuildingID,
+			RoomID:     names.RoomID,
+		}
 
-				nerr := sendEvent(x)
-				if nerr != nil {
-					log.L.Warnf("Error sending event %v", nerr.Error())
-				}
+		x.Key = eventparser.Normalize(event.Key)
+		x.Value = event.Value
+		x.User = ""
+		x.Data = event.Raw
 
-			} else {
-				log.L.Warnf("Malformed Event Received: %s", response)
-			}
-		} else {
-			if monitor {
-				log.L.Warnf("Something else Received: %s", response)
-			} else {
-				log.L.Debugf("Something else Received: %s", response)
-			}
+		for _, sent := range sendDMPSEvent(dmps.Hostname, x, event.Raw) {
+			state.sent(sent)
 		}
 	}
 }
 
-func modifyEvent(event *events.Event) bool {
-
-	//change -CP to -DMPS
-	event.GeneratingSystem = strings.Replace(event.GeneratingSystem, "-CP", "-DMPS", -1)
-	event.TargetDevice.DeviceID = strings.Replace(event.TargetDevice.DeviceID, "-CP", "-DMPS", -1)
-
-	//hack to fix the items destined for static index
-	//that aren't coming in with the right tag
-	if event.Key == "software-version" || event.Key == "hardware-version" || event.Key == "volume" || event.Key == "muted" {
-		event.AddToTags("core-state")
-	}
-
-	if event.Key == "IP Address" {
-		event.Key = "ip-address"
-		event.AddToTags("core-state")
-	}
-
-	if event.Key == "battery-charge-hours-minutes" && event.Value == "Calc" {
-		event.Key = "battery-type"
-		event.Value = ""
-	}
+// SendDMPSEvent runs x, an event about the DMPS hostname, through the event rules like the events read from
+// its console, then queues it and anything the rules derived from it to be sent. Errors are logged.
+// Events about a DMPS that aren't read from its console should be sent with this, so that they get the same ids.
+func SendDMPSEvent(hostname string, x events.Event) {
+	sendDMPSEvent(hostname, x, "")
+}
 
-	if event.Key == "battery-charge-hours-minutes" && event.Value == "AA" {
-		//convert this to a battery-type router
-		event.Key = "battery-type"
-		event.Value = "ALKA"
-	}
+// sendDMPSEvent runs x through the event rules and sends whatever is left, returning the events that were sent.
+// If the rules drop x, the drop is recorded along with raw.
+func sendDMPSEvent(hostname string, x events.Event, raw string) []events.Event {
+	monitor := IsMonitoringDevice(hostname)
 
-	if event.Key == "responsive" && event.TargetDevice.DeviceID == "BRMB-230-D1" {
-		event.Value = "Ok"
-	}
-
-	if event.Key == "battery-charge-hours-minutes" && strings.Contains(event.Value, ":") {
-		//create another event for battery-charge-minutes
-		hm := strings.Split(event.Value, ":")
-		h, _ := strconv.Atoi(hm[0])
-		m, _ := strconv.Atoi(hm[1])
-
-		minutes := h*60 + m
-
-		newEvent := events.Event{
-			GeneratingSystem: event.GeneratingSystem,
-			Timestamp:        event.Timestamp,
-			EventTags:        event.EventTags,
-			TargetDevice:     event.TargetDevice,
-			AffectedRoom:     event.AffectedRoom,
-			Key:              "battery-charge-minutes",
-			Value:            strconv.Itoa(minutes),
-			User:             event.User,
-			Data:             event.Data,
-		}
+	var sent []events.Event
 
-		nerr := sendEvent(newEvent)
+	result := eventRules.Apply(x)
+	for _, derived := range result.Derived {
+		nerr := sendEvent(derived)
 		if nerr != nil {
 			log.L.Warnf("Error sending event %v", nerr.Error())
+		} else {
+			sent = append(sent, derived)
 		}
+	}
 
-		//also send an battery-type
-		newEvent = events.Event{
-			GeneratingSystem: event.GeneratingSystem,
-			Timestamp:        event.Timestamp,
-			EventTags:        event.EventTags,
-			TargetDevice:     event.TargetDevice,
-			AffectedRoom:     event.AffectedRoom,
-			Key:              "battery-type",
-			Value:            "",
-			User:             event.User,
-			Data:             event.Data,
+	if result.Drop {
+		if monitor {
+			log.L.Warnf("Dropping event from %s (%s): %v", hostname, result.DropReason, result.Event)
+		} else {
+			log.L.Debugf("Dropping event from %s (%s): %v", hostname, result.DropReason, result.Event)
 		}
 
-		nerr = sendEvent(newEvent)
-		if nerr != nil {
-			log.L.Warnf("Error sending event %v", nerr.Error())
-		}
+		recordDrop(hostname, Drop{
+			Time:   time.Now(),
+			Reason: result.DropReason,
+			Key:    result.Event.Key,
+			Value:  result.Event.Value,
+			Raw:    raw,
+		})
+
+		return sent
 	}
 
-	return true
-}
+	x = result.Event
 
-func sendEvent(x events.Event) *nerr.E {
-	// marshal request if not already an array of bytes
-	reqBody, err := json.Marshal(x)
-	if err != nil {
-		return nerr.Translate(err)
+	if monitor {
+		log.L.Warnf("Sending request to state parser [%v]", x)
+	} else {
+		log.L.Debugf("Sending request to state parser [%v]", x)
 	}
 
-	eventProcessorHostList := strings.Split(eventProcessorHost, ",")
-	// TODO i see why you weren't returning, i'll just put smee prd first for now
-	for _, hostName := range eventProcessorHostList {
-		// create the request
-		log.L.Debugf("Sending event to address %s", hostName)
-
-		req, err := http.NewRequest("POST", hostName, bytes.NewReader(reqBody))
-		if err != nil {
-			return nerr.Translate(err)
-		}
+	nerr := sendEvent(x)
+	if nerr != nil {
+		log.L.Warnf("Error sending event %v", nerr.Error())
+	} else {
+		sent = append(sent, x)
+	}
 
-		// add headers
-		req.Header.Add("content-type", "application/json")
+	return sent
+}
 
-		client := http.Client{
-			Timeout: 5 * time.Second,
-		}
+// closeOnDone closes conn as soon as ctx is cancelled so that any blocked reads return.
+// The returned func must be called once the connection is no longer being read from.
+func closeOnDone(ctx context.Context, conn io.Closer) func() {
+	done := make(chan struct{})
 
-		resp, err := client.Do(req)
-		if err != nil {
-			return nerr.Translate(err)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
 		}
-		defer resp.Body.Close()
-
-		// read the resp
-		if resp.StatusCode/100 != 2 {
-			respBody, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				return nerr.Translate(err).Addf("non-200 response: %v. unable to read response body", resp.StatusCode)
-			}
+	}()
 
-			return nerr.Createf("error", "non-200 response: %v. response body: %s", resp.StatusCode, respBody)
-		}
+	return func() {
+		close(done)
 	}
+}
 
-	return nil
+//StartConnection opens a connection to the device's console over its transport, performs handshake, logs in if asked to, waits for first prompt
+func StartConnection(ctx context.Context, dev inventory.Device) (Conn, *bufio.ReadWriter, error) {
+	conn, buf, _, err := connect(ctx, withTransportDefaults(dev))
+	return conn, buf, err
 }
 
-//StartConnection opens connection, performs handshake, waits for first prompt
-func StartConnection(address string, port string) (net.Conn, *bufio.ReadWriter, error) {
-	conn, err := net.DialTimeout("tcp", address+":"+port, 10*time.Second)
+// connect does the work of StartConnection, also returning everything read up to and including the first prompt
+func connect(ctx context.Context, dev inventory.Device) (Conn, *bufio.ReadWriter, string, error) {
+	conn, err := dial(ctx, dev)
 	if err != nil {
-		return nil, nil, fmt.Errorf("unable to open connection: %s", err)
+		return nil, nil, "", err
 	}
 
 	log.L.Debugf("Successfully connected.")
@@ -354,177 +314,157 @@ func StartConnection(address string, port string) (net.Conn, *bufio.ReadWriter,
 	switch {
 	case err != nil:
 		conn.Close()
-		return nil, nil, fmt.Errorf("unable to write newline: %s", err)
+		return nil, nil, "", fmt.Errorf("unable to write newline: %s", err)
 	case n != len(newLine):
 		conn.Close()
-		return nil, nil, fmt.Errorf("unable to write newline: only %v/%v bytes written", n, len(newLine))
+		return nil, nil, "", fmt.Errorf("unable to write newline: only %v/%v bytes written", n, len(newLine))
 	}
 
 	err = buf.Flush()
 	if err != nil {
 		conn.Close()
-		return nil, nil, fmt.Errorf("unable to write newline: %s", err)
+		return nil, nil, "", fmt.Errorf("unable to write newline: %s", err)
 	}
 
 	err = conn.SetReadDeadline(time.Now().Add(30 * time.Second))
 	if err != nil {
 		conn.Close()
-		return nil, nil, fmt.Errorf("unable to set deadline: %s", err)
+		return nil, nil, "", fmt.Errorf("unable to set deadline: %s", err)
 	}
 
-	resp, err := buf.ReadString('>')
+	stop := closeOnDone(ctx, conn)
+	resp, err := login(dev, buf)
+	stop()
+
 	if err != nil {
 		conn.Close()
-		return nil, nil, fmt.Errorf("unable to read first line: %s", err)
+		return nil, nil, "", err
 	}
 
 	log.L.Debugf("Initial response %v", resp)
-	return conn, buf, nil
+	return conn, buf, resp, nil
 }
 
-//MonitorOtherCrestron is the function to call in a go routine to monitor another crestron device
-func MonitorOtherCrestron(otherCrestronDevice structs.DMPS, killChannel chan bool, waitG *sync.WaitGroup) {
-	if len(otherCrestronDevice.Port) == 0 || otherCrestronDevice.Port == "0" {
-		otherCrestronDevice.Port = "23"
-	}
-
-	monitor := IsMonitoringDevice(otherCrestronDevice.Hostname)
+//MonitorOtherCrestron monitors another crestron device until ctx is cancelled, reconnecting whenever the connection is lost
+func MonitorOtherCrestron(ctx context.Context, otherCrestronDevice inventory.Device) {
+	otherCrestronDevice = withTransportDefaults(otherCrestronDevice)
 
-	if monitor {
-		log.L.Warnf("Connecting to %v on %v:%v", otherCrestronDevice.Hostname, otherCrestronDevice.Address, otherCrestronDevice.Port)
-	} else {
-		log.L.Debugf("Connecting to %v on %v:%v", otherCrestronDevice.Hostname, otherCrestronDevice.Address, otherCrestronDevice.Port)
-	}
-
-	conn, buf, err := StartConnection(otherCrestronDevice.Address, otherCrestronDevice.Port)
+	check, err := newResponseCheck(otherCrestronDevice)
 	if err != nil {
-		log.L.Warnf("error creating connection for %s. ERROR: %v", otherCrestronDevice.Hostname, err.Error())
-		time.Sleep(5 * time.Second)
-
-		select {
-		case <-killChannel:
-			log.L.Debugf("Kill order received for %s", otherCrestronDevice.Hostname)
-			waitG.Done()
-		default:
-			go MonitorOtherCrestron(otherCrestronDevice, killChannel, waitG)
-		}
-
-		return
+		log.L.Warnf("%s has a bad health check: %s", otherCrestronDevice.Hostname, err)
 	}
-	defer conn.Close()
 
-	for {
-		monitor = IsMonitoringDevice(otherCrestronDevice.Hostname)
+	state := trackConnectivity(otherCrestronDevice, false)
+	defer state.stop()
 
-		select {
-		case <-killChannel:
-			log.L.Debugf("Kill order received for %s", otherCrestronDevice.Hostname)
-			waitG.Done()
-			return
-		default:
-		}
+	for {
+		monitor := state.debugging()
 
-		//before we actually write it, flush out the read buffer
-		toDiscard := buf.Reader.Buffered()
 		if monitor {
-			log.L.Warnf("Clearing out %v that are buffered", toDiscard)
+			log.L.Warnf("Connecting to %v on %v:%v over %v", otherCrestronDevice.Hostname, otherCrestronDevice.Address, otherCrestronDevice.Port, otherCrestronDevice.Transport)
 		} else {
-			log.L.Debugf("Clearing out %v that are buffered", toDiscard)
+			log.L.Debugf("Connecting to %v on %v:%v over %v", otherCrestronDevice.Hostname, otherCrestronDevice.Address, otherCrestronDevice.Port, otherCrestronDevice.Transport)
 		}
 
-		if toDiscard > 0 {
-			b1 := make([]byte, toDiscard)
-			buf.Read(b1)
+		session, err := OpenSession(ctx, otherCrestronDevice)
+		if err != nil {
+			if ctx.Err() != nil {
+				log.L.Debugf("Kill order received for %s", otherCrestronDevice.Hostname)
+				return
+			}
 
-			if monitor {
-				log.L.Warnf("Read and cleared out: %v", b1)
-			} else {
-				log.L.Debugf("Read and cleared out: %v", b1)
+			log.L.Warnf("error creating connection for %s. ERROR: %v", otherCrestronDevice.Hostname, err.Error())
+			state.disconnected(err.Error())
+
+			if !waitToRetry(ctx, state.retry(state.connectionFailed(err))) {
+				log.L.Debugf("Kill order received for %s", otherCrestronDevice.Hostname)
+				return
 			}
+
+			continue
 		}
 
-		if len(otherCrestronDevice.CommandToQuery) > 0 {
-			log.L.Debugf("Writing %s to %s", otherCrestronDevice.CommandToQuery, otherCrestronDevice.Hostname)
-			_, err := buf.WriteString(otherCrestronDevice.CommandToQuery + "\n")
-			if err != nil {
-				log.L.Warnf("Error for %s while writing: [%s]", otherCrestronDevice.Hostname, err)
-			}
-		} else {
-			log.L.Debugf("Writing %s to %s", "VERSION", otherCrestronDevice.Hostname)
-			_, err := buf.WriteString("VERSION\r\n")
-			if err != nil {
-				log.L.Warnf("Error for %s while writing: [%s]", otherCrestronDevice.Hostname, err)
-			}
+		err = pollOtherCrestron(ctx, otherCrestronDevice, session, check, state)
+		session.Close()
+
+		if ctx.Err() != nil {
+			log.L.Debugf("Kill order received for %s", otherCrestronDevice.Hostname)
+			return
 		}
 
-		err := buf.Flush()
-		if err != nil {
-			log.L.Warnf("Error for %s when flushing: [%s]", otherCrestronDevice.Hostname, err)
+		state.disconnected(err.Error())
+
+		log.L.Warnf("Error for %s: [%s]", otherCrestronDevice.Hostname, err)
+		log.L.Warnf("Killing and restarting connection for %s", otherCrestronDevice.Hostname)
+
+		if !waitToRetry(ctx, state.retry(0)) {
+			log.L.Debugf("Kill order received for %s", otherCrestronDevice.Hostname)
+			return
 		}
+	}
+}
 
-		var response string
-		for {
-			//wait up to 30 seconds for response
-			conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+// pollOtherCrestron queries an open session every 30 seconds until the connection fails or ctx is cancelled,
+// sending a health check event with whether the response was what check expects and updating state to match
+func pollOtherCrestron(ctx context.Context, otherCrestronDevice inventory.Device, session *Session, check responseCheck, state *connectivity) error {
+	command := otherCrestronDevice.CommandToQuery
+	if len(command) == 0 {
+		command = "VERSION"
+	}
 
-			response, err = buf.ReadString('\n')
-			if err != nil {
-				log.L.Warnf("Error for %s: [%s]", otherCrestronDevice.Hostname, err)
-				log.L.Warnf("Killing and restarting connection for %s", otherCrestronDevice.Hostname)
-				go MonitorOtherCrestron(otherCrestronDevice, killChannel, waitG)
+	for {
+		monitor := state.debugging()
 
-				return
-			}
+		log.L.Debugf("Writing %s to %s", command, otherCrestronDevice.Hostname)
 
-			//we got a response, send it as an event
-			response = strings.TrimSpace(response)
-			if monitor {
-				log.L.Warnf("Response for %s received: [%s]", otherCrestronDevice.Hostname, response)
-			} else {
-				log.L.Debugf("Response for %s received: [%s]", otherCrestronDevice.Hostname, response)
-			}
+		//wait up to 30 seconds for response
+		response, err := session.Execute(ctx, command)
+		if err != nil {
+			if ctx.Err() == nil {
+				if x, ok := DeviceEvent(otherCrestronDevice, "other-crestron-health-check", healthNoResponse, "health", "auto-generated", "heartbeat", "core-state"); ok {
+					x.Data = response
 
-			if len(response) > 0 && response != "VERSION" {
-				break
+					if nerr := sendEvent(x); nerr != nil {
+						log.L.Warnf("Error sending event %v", nerr.Error())
+					}
+				}
 			}
+
+			return err
 		}
 
-		roomParts := strings.Split(otherCrestronDevice.Hostname, "-")
-		for i := range roomParts {
-			roomParts[i] = strings.TrimSpace(roomParts[i])
+		//we got a response, send it as an event
+		state.received(response)
+		lastEvent.WithLabelValues(otherCrestronDevice.Hostname).SetToCurrentTime()
+
+		if monitor {
+			log.L.Warnf("Response for %s received: [%s]", otherCrestronDevice.Hostname, response)
+		} else {
+			log.L.Debugf("Response for %s received: [%s]", otherCrestronDevice.Hostname, response)
 		}
 
-		x := events.Event{
-			GeneratingSystem: otherCrestronDevice.Hostname,
-			Timestamp:        time.Now(),
-			EventTags:        []string{"health", "auto-generated", "heartbeat", "core-state"},
-			TargetDevice: events.BasicDeviceInfo{
-				BasicRoomInfo: events.BasicRoomInfo{
-					BuildingID: roomParts[0],
-					RoomID:     roomParts[0] + "-" + roomParts[1],
-				},
-				DeviceID: otherCrestronDevice.Hostname,
-			},
-			AffectedRoom: events.BasicRoomInfo{
-				BuildingID: roomParts[0],
-				RoomID:     roomParts[0] + "-" + roomParts[1],
-			},
-			Key:   "other-crestron-health-check",
-			Value: "response received",
-			User:  "",
-			Data:  response,
+		value, reason := check.check(response)
+		if value != healthOK {
+			log.L.Warnf("Unexpected response from %s (%s): [%s]", otherCrestronDevice.Hostname, reason, response)
+			state.unhealthy(reason)
+		} else {
+			state.connected()
 		}
 
-		nerr := sendEvent(x)
-		if nerr != nil {
-			log.L.Warnf("Error sending event %v", nerr.Error())
+		if x, ok := DeviceEvent(otherCrestronDevice, "other-crestron-health-check", value, "health", "auto-generated", "heartbeat", "core-state"); ok {
+			x.Data = response
+
+			nerr := sendEvent(x)
+			if nerr != nil {
+				log.L.Warnf("Error sending event %v", nerr.Error())
+			} else {
+				state.sent(x)
+			}
 		}
 
 		select {
-		case <-killChannel:
-			log.L.Debugf("Kill order received for %s", otherCrestronDevice.Hostname)
-			waitG.Done()
-			return
+		case <-ctx.Done():
+			return ctx.Err()
 		case <-time.After(30 * time.Second):
 			if monitor {
 				log.L.Warnf("30 seconds reached for %s", otherCrestronDevice.Hostname)
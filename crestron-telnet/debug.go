@@ -0,0 +1,114 @@
+package crestrontelnet
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/byuoitav/common/log"
+)
+
+// DefaultDebugDuration is how long debug logs stay on for a device if no duration is given
+const DefaultDebugDuration = time.Hour
+
+var (
+	// debugFlags holds a *debugFlag for every hostname that has debug logs turned on, so that it can be
+	// read without locking on every line. Flags are removed when they expire, when they are turned off,
+	// and by ForgetDevice. debugMu serializes adding and removing them.
+	debugMu    sync.Mutex
+	debugFlags sync.Map
+)
+
+// DebugSession is a device that has debug logs turned on
+type DebugSession struct {
+	Hostname string    `json:"hostname"`
+	Until    time.Time `json:"until"`
+}
+
+// debugFlag is when a single device's debug logs turn off. It isn't changed once it is stored.
+type debugFlag struct {
+	until time.Time
+	timer *time.Timer
+}
+
+// StartMonitoringDevice turns on debug logs for hostname for d (or DefaultDebugDuration if d isn't positive),
+// returning when they will turn off. hostname should be a device that is being monitored.
+func StartMonitoringDevice(hostname string, d time.Duration) time.Time {
+	if d <= 0 {
+		d = DefaultDebugDuration
+	}
+
+	debugMu.Lock()
+	defer debugMu.Unlock()
+
+	removeDebugFlag(hostname)
+
+	f := &debugFlag{
+		until: time.Now().Add(d),
+	}
+
+	f.timer = time.AfterFunc(d, func() {
+		expireDebugFlag(hostname, f)
+	})
+
+	debugFlags.Store(hostname, f)
+	return f.until
+}
+
+// StopMonitoringDevice turns off debug logs for hostname
+func StopMonitoringDevice(hostname string) {
+	debugMu.Lock()
+	defer debugMu.Unlock()
+
+	removeDebugFlag(hostname)
+}
+
+// IsMonitoringDevice returns whether debug logs are on for hostname
+func IsMonitoringDevice(hostname string) bool {
+	f, ok := debugFlags.Load(hostname)
+	return ok && f.(*debugFlag).until.After(time.Now())
+}
+
+// DebugSessions returns every device that has debug logs on, sorted by hostname
+func DebugSessions() []DebugSession {
+	sessions := []DebugSession{}
+
+	debugFlags.Range(func(key, value interface{}) bool {
+		f := value.(*debugFlag)
+		if f.until.After(time.Now()) {
+			sessions = append(sessions, DebugSession{
+				Hostname: key.(string),
+				Until:    f.until,
+			})
+		}
+
+		return true
+	})
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Hostname < sessions[j].Hostname
+	})
+
+	return sessions
+}
+
+func expireDebugFlag(hostname string, f *debugFlag) {
+	debugMu.Lock()
+	defer debugMu.Unlock()
+
+	// it may have been extended (or turned off) while the timer was firing
+	if current, ok := debugFlags.Load(hostname); !ok || current != f {
+		return
+	}
+
+	debugFlags.Delete(hostname)
+	log.L.Infof("Debug logs for %v have expired", hostname)
+}
+
+// removeDebugFlag removes hostname's flag if it has one. debugMu must be held.
+func removeDebugFlag(hostname string) {
+	if f, ok := debugFlags.Load(hostname); ok {
+		f.(*debugFlag).timer.Stop()
+		debugFlags.Delete(hostname)
+	}
+}
@@ -0,0 +1,52 @@
+package crestrontelnet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDebugFlagExpires(t *testing.T) {
+	const hostname = "ITB-1101-CP1"
+	defer StopMonitoringDevice(hostname)
+
+	until := StartMonitoringDevice(hostname, 50*time.Millisecond)
+
+	if !IsMonitoringDevice(hostname) {
+		t.Fatalf("debug logs aren't on right after turning them on")
+	}
+
+	if sessions := DebugSessions(); len(sessions) != 1 || sessions[0].Hostname != hostname || !sessions[0].Until.Equal(until) {
+		t.Errorf("got %+v, expected %s until %v", sessions, hostname, until)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if IsMonitoringDevice(hostname) {
+		t.Errorf("debug logs are still on after they expired")
+	}
+
+	if _, ok := debugFlags.Load(hostname); ok {
+		t.Errorf("the flag is still kept after it expired")
+	}
+}
+
+func TestExtendingDebugLogs(t *testing.T) {
+	const hostname = "ITB-1101-CP1"
+	defer StopMonitoringDevice(hostname)
+
+	StartMonitoringDevice(hostname, 50*time.Millisecond)
+	StartMonitoringDevice(hostname, time.Hour)
+
+	// the first timer shouldn't remove the flag that replaced it
+	time.Sleep(100 * time.Millisecond)
+
+	if !IsMonitoringDevice(hostname) {
+		t.Errorf("debug logs turned off when the duration they were replaced with hadn't passed")
+	}
+
+	StopMonitoringDevice(hostname)
+
+	if _, ok := debugFlags.Load(hostname); ok {
+		t.Errorf("the flag is still kept after debug logs were turned off")
+	}
+}
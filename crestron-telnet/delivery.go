@@ -0,0 +1,456 @@
+package crestrontelnet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/byuoitav/common/log"
+	"github.com/byuoitav/common/nerr"
+	"github.com/byuoitav/common/v2/events"
+	"github.com/byuoitav/crestron-telnet-microservice/queue"
+)
+
+const (
+	defaultRetryMin  = 1 * time.Second
+	defaultRetryMax  = 1 * time.Minute
+	defaultBatchSize = 100
+
+	// batch modes
+	batchNone   = ""
+	batchArray  = "array"
+	batchNDJSON = "ndjson"
+)
+
+var (
+	destinations []*destination
+
+	// every destination shares one client so that connections are kept alive and reused between events
+	deliveryClient = &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			MaxIdleConns:        100,
+			MaxIdleConnsPerHost: 16,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+
+	unsafeFilenameChars = regexp.MustCompile(`[^a-zA-Z0-9.-]+`)
+)
+
+// DeliveryConfig controls how events are queued and delivered to the event processors
+type DeliveryConfig struct {
+	// QueueDir is where each destination's queue is kept. If it is empty, queues are only kept in memory.
+	QueueDir string
+
+	// QueueMax is the max number of events each destination will hold before dropping the oldest
+	QueueMax int
+
+	// Workers is the default number of concurrent senders per destination. With more than one,
+	// events can be delivered out of the order they were queued in, even for a single device.
+	Workers int
+}
+
+// QueueError is returned by StartEventDelivery when the queues can't be kept in QueueDir.
+// Delivery can still be started with the queues only kept in memory.
+type QueueError struct {
+	Err error
+}
+
+func (e *QueueError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error from opening the queue
+func (e *QueueError) Unwrap() error {
+	return e.Err
+}
+
+// DestinationStatus is the delivery health of a single event processor
+type DestinationStatus struct {
+	URL                 string      `json:"url"`
+	Healthy             bool        `json:"healthy"`
+	Sent                uint64      `json:"sent"`
+	Failed              uint64      `json:"failed"`
+	ConsecutiveFailures int         `json:"consecutive-failures"`
+	LastSuccess         time.Time   `json:"last-success,omitempty"`
+	LastError           string      `json:"last-error,omitempty"`
+	LastErrorTime       time.Time   `json:"last-error-time,omitempty"`
+	NextRetry           time.Time   `json:"next-retry,omitempty"`
+	Queue               queue.Stats `json:"queue"`
+}
+
+// destination delivers events to a single event processor from its own queue,
+// so a slow or dead destination never holds up any of the others
+type destination struct {
+	url       string
+	queue     *queue.Queue
+	retryMin  time.Duration
+	retryMax  time.Duration
+	workers   int
+	batch     string
+	batchSize int
+
+	mu     sync.Mutex
+	status DestinationStatus
+}
+
+// StartEventDelivery starts delivering events to each event processor in EVENT_PROCESSOR_HOST.
+// Each destination gets its own queue and its own pool of workers that run until ctx is cancelled.
+// A destination with a single worker delivers events in the order they were queued; raising workers
+// trades that ordering for throughput, since workers send whatever they take off the queue concurrently.
+//
+// Each entry in EVENT_PROCESSOR_HOST is a url, optionally followed by semicolon separated options:
+//
+//	http://event-processor/event;workers=4;batch=ndjson;batch-size=50;retry-min=1s;retry-max=1m
+//
+// batch can be "array" (POST a json array of events) or "ndjson" (POST newline delimited json),
+// and should only be set if the destination accepts that format. By default events are sent one per request.
+//
+// Every destination's queue is opened before any workers are started, so if an error is returned,
+// nothing has been started and any queues that were opened have been closed again. If the only
+// problem was keeping the queues in QueueDir, the error is a *QueueError.
+func StartEventDelivery(ctx context.Context, config DeliveryConfig) (err error) {
+	if len(destinations) > 0 {
+		return fmt.Errorf("event delivery has already been started")
+	}
+
+	var dests []*destination
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		for _, d := range dests {
+			if cerr := d.queue.Close(); cerr != nil {
+				log.L.Warnf("unable to close queue for %s: %s", d.url, cerr)
+			}
+		}
+	}()
+
+	if config.Workers <= 0 {
+		config.Workers = 1
+	}
+
+	if len(config.QueueDir) > 0 {
+		if err := os.MkdirAll(config.QueueDir, 0755); err != nil {
+			return &QueueError{Err: fmt.Errorf("unable to create queue directory: %s", err)}
+		}
+	}
+
+	for _, spec := range strings.Split(eventProcessorHost, ",") {
+		spec = strings.TrimSpace(spec)
+		if len(spec) == 0 {
+			continue
+		}
+
+		d, err := newDestination(spec, config.Workers)
+		if err != nil {
+			return err
+		}
+
+		path := ""
+		if len(config.QueueDir) > 0 {
+			path = filepath.Join(config.QueueDir, unsafeFilenameChars.ReplaceAllString(d.url, "_")+".queue")
+		}
+
+		d.queue, err = queue.Open(path, config.QueueMax)
+		switch {
+		case err != nil && len(path) > 0:
+			return &QueueError{Err: fmt.Errorf("unable to open queue for %s: %s", d.url, err)}
+		case err != nil:
+			return fmt.Errorf("unable to open queue for %s: %s", d.url, err)
+		}
+
+		dests = append(dests, d)
+	}
+
+	if len(dests) == 0 {
+		return fmt.Errorf("no event processors are configured")
+	}
+
+	destinations = dests
+	for _, d := range destinations {
+		log.L.Infof("Delivering events to %s with %v workers (batch mode %q)", d.url, d.workers, d.batch)
+
+		for i := 0; i < d.workers; i++ {
+			go d.deliver(ctx)
+		}
+	}
+
+	return nil
+}
+
+// DestinationStatuses returns the delivery health of every event processor
+func DestinationStatuses() []DestinationStatus {
+	statuses := make([]DestinationStatus, 0, len(destinations))
+	for _, d := range destinations {
+		statuses = append(statuses, d.Status())
+	}
+
+	return statuses
+}
+
+// SendEvent queues an event to be sent to every event processor in EVENT_PROCESSOR_HOST
+func SendEvent(x events.Event) *nerr.E {
+	return sendEvent(x)
+}
+
+func sendEvent(x events.Event) *nerr.E {
+	if len(destinations) == 0 {
+		return nerr.Create("event delivery has not been started", "error")
+	}
+
+	var errs []string
+	for _, d := range destinations {
+		if err := d.queue.Push(x); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return nerr.Createf("error", "unable to queue event: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+func newDestination(spec string, workers int) (*destination, error) {
+	parts := strings.Split(spec, ";")
+
+	d := &destination{
+		url:       strings.TrimSpace(parts[0]),
+		retryMin:  defaultRetryMin,
+		retryMax:  defaultRetryMax,
+		workers:   workers,
+		batchSize: defaultBatchSize,
+	}
+
+	for _, opt := range parts[1:] {
+		kv := strings.SplitN(strings.TrimSpace(opt), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid option %q for %s", opt, d.url)
+		}
+
+		var err error
+		switch kv[0] {
+		case "retry-min":
+			d.retryMin, err = time.ParseDuration(kv[1])
+		case "retry-max":
+			d.retryMax, err = time.ParseDuration(kv[1])
+		case "workers":
+			d.workers, err = strconv.Atoi(kv[1])
+		case "batch":
+			d.batch = kv[1]
+		case "batch-size":
+			d.batchSize, err = strconv.Atoi(kv[1])
+		default:
+			err = fmt.Errorf("unknown option")
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid option %q for %s: %s", opt, d.url, err)
+		}
+	}
+
+	if d.retryMin <= 0 || d.retryMax < d.retryMin {
+		return nil, fmt.Errorf("invalid retry policy for %s: retry-min must be positive and no larger than retry-max", d.url)
+	}
+
+	if d.workers <= 0 {
+		return nil, fmt.Errorf("invalid worker count for %s: must be positive", d.url)
+	}
+
+	switch d.batch {
+	case batchNone:
+		d.batchSize = 1
+	case batchArray, batchNDJSON:
+		if d.batchSize <= 0 {
+			return nil, fmt.Errorf("invalid batch size for %s: must be positive", d.url)
+		}
+	default:
+		return nil, fmt.Errorf("invalid batch mode %q for %s: must be array or ndjson", d.batch, d.url)
+	}
+
+	d.status = DestinationStatus{
+		URL:     d.url,
+		Healthy: true,
+	}
+
+	return d, nil
+}
+
+// Status returns the destination's delivery stats
+func (d *destination) Status() DestinationStatus {
+	d.mu.Lock()
+	status := d.status
+	d.mu.Unlock()
+
+	status.Queue = d.queue.Stats()
+	return status
+}
+
+// deliver sends batches of events from the destination's queue. If a batch fails to send,
+// it is put back at the front of the queue and retried with backoff until it goes through.
+func (d *destination) deliver(ctx context.Context) {
+	retry := d.retryMin
+
+	for {
+		entries := d.queue.Take(d.batchSize)
+		if len(entries) == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-d.queue.Ready():
+			}
+
+			continue
+		}
+
+		if nerr := d.post(ctx, entries); nerr != nil {
+			d.queue.Nack(entries)
+			d.failed(nerr, retry)
+			log.L.Warnf("Error sending %v events to %s (%v queued, retrying in %v): %v", len(entries), d.url, d.queue.Len(), retry, nerr.Error())
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retry):
+			}
+
+			retry *= 2
+			if retry > d.retryMax {
+				retry = d.retryMax
+			}
+
+			continue
+		}
+
+		if retry > d.retryMin {
+			log.L.Infof("%s is reachable again, %v events left to replay", d.url, d.queue.Len()-len(entries))
+		}
+
+		retry = d.retryMin
+		d.succeeded(len(entries))
+
+		if err := d.queue.Ack(entries); err != nil {
+			log.L.Warnf("unable to remove sent events from the queue for %s: %s", d.url, err)
+		}
+	}
+}
+
+func (d *destination) succeeded(n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.status.Healthy = true
+	d.status.Sent += uint64(n)
+	eventsSent.WithLabelValues(d.url).Add(float64(n))
+	d.status.ConsecutiveFailures = 0
+	d.status.LastSuccess = time.Now()
+	d.status.NextRetry = time.Time{}
+}
+
+func (d *destination) failed(err *nerr.E, retry time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.status.Healthy = false
+	d.status.Failed++
+	sendFailures.WithLabelValues(d.url).Inc()
+	d.status.ConsecutiveFailures++
+	d.status.LastError = err.Error()
+	d.status.LastErrorTime = time.Now()
+	d.status.NextRetry = d.status.LastErrorTime.Add(retry)
+}
+
+// post sends entries in a single request, formatted according to the destination's batch mode
+func (d *destination) post(ctx context.Context, entries []queue.Entry) *nerr.E {
+	var reqBody []byte
+	contentType := "application/json"
+
+	switch d.batch {
+	case batchArray:
+		batch := make([]events.Event, len(entries))
+		for i := range entries {
+			batch[i] = entries[i].Event
+		}
+
+		b, err := json.Marshal(batch)
+		if err != nil {
+			return nerr.Translate(err)
+		}
+
+		reqBody = b
+	case batchNDJSON:
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+
+		for i := range entries {
+			if err := enc.Encode(entries[i].Event); err != nil {
+				return nerr.Translate(err)
+			}
+		}
+
+		reqBody = buf.Bytes()
+		contentType = "application/x-ndjson"
+	default:
+		b, err := json.Marshal(entries[0].Event)
+		if err != nil {
+			return nerr.Translate(err)
+		}
+
+		reqBody = b
+	}
+
+	// create the request
+	log.L.Debugf("Sending %v events to address %s", len(entries), d.url)
+
+	req, err := http.NewRequest("POST", d.url, bytes.NewReader(reqBody))
+	if err != nil {
+		return nerr.Translate(err)
+	}
+
+	req = req.WithContext(ctx)
+
+	// add headers
+	req.Header.Add("content-type", contentType)
+
+	start := time.Now()
+	defer func() {
+		sendDuration.WithLabelValues(d.url).Observe(time.Since(start).Seconds())
+	}()
+
+	resp, err := deliveryClient.Do(req)
+	if err != nil {
+		return nerr.Translate(err)
+	}
+	defer resp.Body.Close()
+
+	// read the resp
+	if resp.StatusCode/100 != 2 {
+		respBody, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nerr.Translate(err).Addf("non-200 response: %v. unable to read response body", resp.StatusCode)
+		}
+
+		return nerr.Createf("error", "non-200 response: %v. response body: %s", resp.StatusCode, respBody)
+	}
+
+	// drain the body so the connection can be reused
+	io.Copy(ioutil.Discard, resp.Body)
+
+	return nil
+}
@@ -0,0 +1,338 @@
+package crestrontelnet
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/byuoitav/common/v2/events"
+	"github.com/byuoitav/crestron-telnet-microservice/queue"
+)
+
+// options is everything newDestination parses out of a spec
+type options struct {
+	url       string
+	retryMin  time.Duration
+	retryMax  time.Duration
+	workers   int
+	batch     string
+	batchSize int
+}
+
+func TestNewDestination(t *testing.T) {
+	tests := []struct {
+		spec string
+		want options
+		err  bool
+	}{
+		{
+			spec: "http://event-processor/event",
+			want: options{url: "http://event-processor/event", retryMin: defaultRetryMin, retryMax: defaultRetryMax, workers: 2, batchSize: 1},
+		},
+		{
+			spec: "http://event-processor/event; workers=4;batch=ndjson;batch-size=50;retry-min=2s;retry-max=30s",
+			want: options{url: "http://event-processor/event", retryMin: 2 * time.Second, retryMax: 30 * time.Second, workers: 4, batch: batchNDJSON, batchSize: 50},
+		},
+		{
+			spec: "http://event-processor/event;batch=array",
+			want: options{url: "http://event-processor/event", retryMin: defaultRetryMin, retryMax: defaultRetryMax, workers: 2, batch: batchArray, batchSize: defaultBatchSize},
+		},
+		{spec: "http://event-processor/event;workers", err: true},
+		{spec: "http://event-processor/event;compress=gzip", err: true},
+		{spec: "http://event-processor/event;workers=many", err: true},
+		{spec: "http://event-processor/event;workers=0", err: true},
+		{spec: "http://event-processor/event;retry-min=1m;retry-max=1s", err: true},
+		{spec: "http://event-processor/event;retry-min=0s", err: true},
+		{spec: "http://event-processor/event;batch=xml", err: true},
+		{spec: "http://event-processor/event;batch=array;batch-size=0", err: true},
+	}
+
+	for _, tt := range tests {
+		d, err := newDestination(tt.spec, 2)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: got %+v, expected an error", tt.spec, d)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %s", tt.spec, err)
+			continue
+		}
+
+		got := options{url: d.url, retryMin: d.retryMin, retryMax: d.retryMax, workers: d.workers, batch: d.batch, batchSize: d.batchSize}
+		if got != tt.want {
+			t.Errorf("%s: got %+v, expected %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+// request is a request received by a fakeProcessor
+type request struct {
+	time        time.Time
+	contentType string
+	body        string
+}
+
+// fakeProcessor is an event processor that fails the first failures requests, and records every request it gets
+type fakeProcessor struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	failures int
+	requests []request
+
+	// received gets a value for every request, if it isn't full
+	received chan struct{}
+}
+
+func newFakeProcessor(failures int) *fakeProcessor {
+	p := &fakeProcessor{
+		failures: failures,
+		received: make(chan struct{}, 100),
+	}
+
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		p.mu.Lock()
+		p.requests = append(p.requests, request{time: time.Now(), contentType: r.Header.Get("content-type"), body: string(body)})
+		fail := len(p.requests) <= p.failures
+		p.mu.Unlock()
+
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		select {
+		case p.received <- struct{}{}:
+		default:
+		}
+	}))
+
+	return p
+}
+
+func (p *fakeProcessor) waitForRequests(t *testing.T, n int) []request {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		select {
+		case <-p.received:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for request %v of %v", i+1, n)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]request(nil), p.requests...)
+}
+
+// testDestination returns a destination for spec with an in memory queue, and events 0 through n-1 in it
+func testDestination(t *testing.T, spec string, n int) *destination {
+	t.Helper()
+
+	d, err := newDestination(spec, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d.queue, err = queue.Open("", 1000); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < n; i++ {
+		d.queue.Push(events.Event{Key: "event", Value: strconv.Itoa(i)})
+	}
+
+	return d
+}
+
+func TestPostBatchModes(t *testing.T) {
+	p := newFakeProcessor(0)
+	defer p.Close()
+
+	tests := []struct {
+		batch       string
+		contentType string
+		body        string
+	}{
+		{
+			batch:       "",
+			contentType: "application/json",
+			body:        `"value":"0"`,
+		},
+		{
+			batch:       ";batch=array",
+			contentType: "application/json",
+			body:        `^\[\{.*"value":"0".*\},\{.*"value":"1".*\}\]$`,
+		},
+		{
+			batch:       ";batch=ndjson",
+			contentType: "application/x-ndjson",
+			body:        `^\{.*"value":"0".*\}\n\{.*"value":"1".*\}\n$`,
+		},
+	}
+
+	for _, tt := range tests {
+		d := testDestination(t, p.URL+tt.batch, 2)
+
+		entries := d.queue.Take(d.batchSize)
+		if nerr := d.post(context.Background(), entries); nerr != nil {
+			t.Fatalf("%q: %s", tt.batch, nerr.Error())
+		}
+
+		reqs := p.waitForRequests(t, 1)
+		req := reqs[len(reqs)-1]
+
+		if req.contentType != tt.contentType {
+			t.Errorf("%q: got content type %s, expected %s", tt.batch, req.contentType, tt.contentType)
+		}
+
+		if !regexp.MustCompile(tt.body).MatchString(req.body) {
+			t.Errorf("%q: got body %s, expected it to match %s", tt.batch, req.body, tt.body)
+		}
+	}
+}
+
+func TestDeliveryBacksOff(t *testing.T) {
+	p := newFakeProcessor(3)
+	defer p.Close()
+
+	d := testDestination(t, p.URL+";retry-min=20ms;retry-max=50ms", 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go d.deliver(ctx)
+
+	reqs := p.waitForRequests(t, 4)
+
+	// each retry waits twice as long as the last, up to retry-max
+	for i, min := range []time.Duration{20 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond} {
+		if gap := reqs[i+1].time.Sub(reqs[i].time); gap < min {
+			t.Errorf("retry %v came after %v, expected at least %v", i+1, gap, min)
+		}
+	}
+
+	// the event isn't lost while the destination is down
+	for _, req := range reqs {
+		if !strings.Contains(req.body, `"value":"0"`) {
+			t.Errorf("got body %s, expected event 0 every time", req.body)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for d.Status().Sent != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("got status %+v, expected the event to be sent", d.Status())
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	if status := d.Status(); !status.Healthy || status.Failed != 3 || status.ConsecutiveFailures != 0 || status.Queue.Depth+status.Queue.InFlight != 0 {
+		t.Errorf("got status %+v, expected healthy with 3 failures and an empty queue", status)
+	}
+}
+
+func TestSlowDestinationDoesntBlockOthers(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	fast := newFakeProcessor(0)
+	defer fast.Close()
+
+	prev := destinations
+	defer func() { destinations = prev }()
+
+	destinations = []*destination{
+		testDestination(t, slow.URL, 0),
+		testDestination(t, fast.URL, 0),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, d := range destinations {
+		go d.deliver(ctx)
+	}
+
+	for i := 0; i < 3; i++ {
+		if nerr := sendEvent(events.Event{Key: "event", Value: strconv.Itoa(i)}); nerr != nil {
+			t.Fatal(nerr.Error())
+		}
+	}
+
+	// every event gets to the fast destination while the slow one is stuck on the first
+	fast.waitForRequests(t, 3)
+
+	if n := destinations[0].queue.Len(); n != 3 {
+		t.Errorf("got %v events queued for the slow destination, expected 3", n)
+	}
+}
+
+func TestStartEventDeliveryErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "delivery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a file where the queue directory should be
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	prevHost, prevDestinations := eventProcessorHost, destinations
+	defer func() { eventProcessorHost, destinations = prevHost, prevDestinations }()
+	destinations = nil
+
+	tests := []struct {
+		name     string
+		host     string
+		queueDir string
+		queueErr bool
+	}{
+		{name: "UnusableQueueDir", host: "http://event-processor/event", queueDir: filepath.Join(file, "queues"), queueErr: true},
+		{name: "BadDestination", host: "http://event-processor/event;workers=many", queueDir: filepath.Join(dir, "queues")},
+		{name: "NoDestinations", host: " , "},
+	}
+
+	for _, tt := range tests {
+		eventProcessorHost = tt.host
+
+		err := StartEventDelivery(context.Background(), DeliveryConfig{QueueDir: tt.queueDir, QueueMax: 10})
+		if err == nil {
+			t.Fatalf("%s: got no error", tt.name)
+		}
+
+		var queueErr *QueueError
+		if errors.As(err, &queueErr) != tt.queueErr {
+			t.Errorf("%s: got %T (%s), want a queue error: %v", tt.name, err, err, tt.queueErr)
+		}
+
+		if len(destinations) != 0 {
+			t.Errorf("%s: got %v destinations started, want none", tt.name, len(destinations))
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package crestrontelnet
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// recentDropCount is how many dropped events are remembered per device
+const recentDropCount = 50
+
+var (
+	dropsMu sync.Mutex
+	drops   = make(map[string]*DeviceDrops)
+)
+
+// Drop is a single event that was not sent
+type Drop struct {
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason"`
+	Key    string    `json:"key,omitempty"`
+	Value  string    `json:"value,omitempty"`
+	Raw    string    `json:"raw,omitempty"`
+}
+
+// DeviceDrops counts the events dropped for a single device
+type DeviceDrops struct {
+	Hostname string `json:"hostname"`
+	Total    uint64 `json:"total"`
+
+	// ByReason is keyed on the reason without its details (e.g. "malformed"), so it stays small.
+	// The full reason is kept in Recent.
+	ByReason map[string]uint64 `json:"by-reason"`
+
+	// Recent is the most recent drops, newest first
+	Recent []Drop `json:"recent"`
+}
+
+// recordDrop notes that an event from hostname was not sent
+func recordDrop(hostname string, drop Drop) {
+	dropsMu.Lock()
+	defer dropsMu.Unlock()
+
+	d, ok := drops[hostname]
+	if !ok {
+		d = &DeviceDrops{
+			Hostname: hostname,
+			ByReason: make(map[string]uint64),
+		}
+
+		drops[hostname] = d
+	}
+
+	reason := dropReason(drop.Reason)
+
+	d.Total++
+	d.ByReason[reason]++
+	eventsDropped.WithLabelValues(metricKey(drop.Key), reason).Inc()
+
+	d.Recent = append([]Drop{drop}, d.Recent...)
+	if len(d.Recent) > recentDropCount {
+		d.Recent = d.Recent[:recentDropCount]
+	}
+}
+
+// DroppedEvents returns the drops recorded for hostname
+func DroppedEvents(hostname string) DeviceDrops {
+	dropsMu.Lock()
+	defer dropsMu.Unlock()
+
+	d, ok := drops[hostname]
+	if !ok {
+		return DeviceDrops{
+			Hostname: hostname,
+			ByReason: map[string]uint64{},
+			Recent:   []Drop{},
+		}
+	}
+
+	return copyDrops(d)
+}
+
+// AllDroppedEvents returns the drops recorded for every device, without the recent drops
+func AllDroppedEvents() []DeviceDrops {
+	dropsMu.Lock()
+	defer dropsMu.Unlock()
+
+	list := make([]DeviceDrops, 0, len(drops))
+	for _, d := range drops {
+		cp := copyDrops(d)
+		cp.Recent = nil
+		list = append(list, cp)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Hostname < list[j].Hostname
+	})
+
+	return list
+}
+
+// forgetDrops removes the drops recorded for hostname
+func forgetDrops(hostname string) {
+	dropsMu.Lock()
+	defer dropsMu.Unlock()
+
+	delete(drops, hostname)
+}
+
+func copyDrops(d *DeviceDrops) DeviceDrops {
+	cp := DeviceDrops{
+		Hostname: d.Hostname,
+		Total:    d.Total,
+		ByReason: make(map[string]uint64, len(d.ByReason)),
+		Recent:   append([]Drop(nil), d.Recent...),
+	}
+
+	for k, v := range d.ByReason {
+		cp.ByReason[k] = v
+	}
+
+	return cp
+}
@@ -0,0 +1,73 @@
+package crestrontelnet
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRecordDrop(t *testing.T) {
+	const hostname = "ITB-1101-DMPS"
+	defer forgetDrops(hostname)
+
+	recordDrop(hostname, Drop{Time: time.Now(), Reason: "malformed: missing value", Raw: "~EVENT~bad~"})
+	recordDrop(hostname, Drop{Time: time.Now(), Reason: "malformed: missing key", Raw: "~EVENT~~"})
+
+	for i := 0; i < recentDropCount; i++ {
+		recordDrop(hostname, Drop{Time: time.Now(), Reason: "ignored-key", Key: "volume", Value: strconv.Itoa(i)})
+	}
+
+	got := DroppedEvents(hostname)
+
+	if got.Total != recentDropCount+2 {
+		t.Errorf("got %v total, expected %v", got.Total, recentDropCount+2)
+	}
+
+	// the details of why each was malformed aren't part of the reason they are counted under
+	if got.ByReason["malformed"] != 2 || got.ByReason["ignored-key"] != recentDropCount || len(got.ByReason) != 2 {
+		t.Errorf("got by reason %v, expected 2 malformed and %v ignored-key", got.ByReason, recentDropCount)
+	}
+
+	if len(got.Recent) != recentDropCount {
+		t.Fatalf("got %v recent drops, expected only the last %v", len(got.Recent), recentDropCount)
+	}
+
+	if newest, oldest := got.Recent[0].Value, got.Recent[recentDropCount-1].Value; newest != strconv.Itoa(recentDropCount-1) || oldest != "0" {
+		t.Errorf("got recent drops from %s to %s, expected newest first", newest, oldest)
+	}
+
+	// what is returned is a copy
+	got.ByReason["malformed"] = 100
+	got.Recent[0].Value = "changed"
+
+	if again := DroppedEvents(hostname); again.ByReason["malformed"] != 2 || again.Recent[0].Value == "changed" {
+		t.Errorf("changing the returned drops changed what was recorded")
+	}
+}
+
+func TestDroppedEvents(t *testing.T) {
+	defer forgetDrops("ITB-1101-DMPS")
+	defer forgetDrops("ITB-1102-DMPS")
+
+	if got := DroppedEvents("ITB-1101-DMPS"); got.Total != 0 || got.ByReason == nil || got.Recent == nil {
+		t.Errorf("got %+v for a device with no drops, expected an empty record", got)
+	}
+
+	recordDrop("ITB-1102-DMPS", Drop{Time: time.Now(), Reason: "unresolved-name"})
+	recordDrop("ITB-1101-DMPS", Drop{Time: time.Now(), Reason: "unresolved-name"})
+
+	var all []DeviceDrops
+	for _, d := range AllDroppedEvents() {
+		if d.Hostname == "ITB-1101-DMPS" || d.Hostname == "ITB-1102-DMPS" {
+			all = append(all, d)
+		}
+	}
+
+	if len(all) != 2 || all[0].Hostname != "ITB-1101-DMPS" || all[1].Hostname != "ITB-1102-DMPS" {
+		t.Fatalf("got %+v, expected both devices sorted by hostname", all)
+	}
+
+	if all[0].Total != 1 || all[0].Recent != nil {
+		t.Errorf("got %+v, expected the count without the recent drops", all[0])
+	}
+}
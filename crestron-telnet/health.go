@@ -0,0 +1,69 @@
+package crestrontelnet
+
+import (
+	"errors"
+	"time"
+
+	"github.com/byuoitav/common/log"
+	"github.com/byuoitav/common/v2/events"
+	"github.com/byuoitav/crestron-telnet-microservice/inventory"
+)
+
+const (
+	// authRetryInterval is how long to wait before reconnecting after a login is rejected,
+	// long enough that bad credentials don't get the account locked out
+	authRetryInterval = 5 * time.Minute
+)
+
+// DeviceEvent builds an event about dev itself, tagged with tags. If dev's names can't be
+// resolved, the event is dropped (and recorded as an unresolved-name drop, just like the
+// events parsed from a DMPS) and false is returned.
+// Events about a DMPS should be sent with SendDMPSEvent.
+func DeviceEvent(dev inventory.Device, key, value string, tags ...string) (events.Event, bool) {
+	names, err := resolver.Resolve(dev.Hostname, dev.Naming)
+	if err != nil {
+		log.L.Warnf("Unable to resolve names for %s, dropping its %s event: %s", dev.Hostname, key, err)
+		recordDrop(dev.Hostname, Drop{
+			Time:   time.Now(),
+			Reason: "unresolved-name",
+			Key:    key,
+			Value:  value,
+		})
+
+		return events.Event{}, false
+	}
+
+	return events.Event{
+		GeneratingSystem: dev.Hostname,
+		Timestamp:        time.Now(),
+		EventTags:        tags,
+		TargetDevice: events.BasicDeviceInfo{
+			BasicRoomInfo: events.BasicRoomInfo{
+				BuildingID: names.BuildingID,
+				RoomID:     names.RoomID,
+			},
+			DeviceID: names.DeviceID,
+		},
+		AffectedRoom: events.BasicRoomInfo{
+			BuildingID: names.BuildingID,
+			RoomID:     names.RoomID,
+		},
+		Key:   key,
+		Value: value,
+	}, true
+}
+
+// connectionFailed reports a failed attempt to connect to the device and returns the least amount of time to wait before trying again
+func (c *connectivity) connectionFailed(err error) time.Duration {
+	var authErr *AuthError
+	if !errors.As(err, &authErr) {
+		return 0
+	}
+
+	if x, ok := DeviceEvent(c.dev, "auth-failed", authErr.Reason, "health", "auto-generated", "auth-failed"); ok {
+		x.Data = authErr.Message
+		c.send(x)
+	}
+
+	return authRetryInterval
+}
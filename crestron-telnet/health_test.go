@@ -0,0 +1,55 @@
+package crestrontelnet
+
+import (
+	"testing"
+
+	"github.com/byuoitav/common/structs"
+	"github.com/byuoitav/crestron-telnet-microservice/inventory"
+)
+
+func TestDeviceEvent(t *testing.T) {
+	dev := inventory.Device{DMPS: structs.DMPS{Hostname: "ITB-1101-CP1"}}
+
+	x, ok := DeviceEvent(dev, "online", "true", "health")
+	if !ok {
+		t.Fatalf("got no event for %s, expected its names to resolve", dev.Hostname)
+	}
+
+	if x.TargetDevice.BuildingID != "ITB" || x.TargetDevice.RoomID != "ITB-1101" || x.TargetDevice.DeviceID != "ITB-1101-CP1" {
+		t.Errorf("got target device %+v, expected ITB-1101-CP1 in ITB-1101", x.TargetDevice)
+	}
+
+	if x.AffectedRoom != x.TargetDevice.BasicRoomInfo || x.Key != "online" || x.Value != "true" {
+		t.Errorf("got event %+v, expected online=true about ITB-1101", x)
+	}
+}
+
+func TestDeviceEventUnresolved(t *testing.T) {
+	const hostname = "printer"
+	defer forgetDrops(hostname)
+
+	dev := inventory.Device{DMPS: structs.DMPS{Hostname: hostname}}
+
+	// dropped just like an event parsed from a dmps whose names can't be resolved
+	if x, ok := DeviceEvent(dev, "online", "true", "health"); ok {
+		t.Fatalf("got event %+v, expected it to be dropped", x)
+	}
+
+	got := DroppedEvents(hostname)
+	if got.ByReason["unresolved-name"] != 1 || len(got.Recent) != 1 {
+		t.Fatalf("got drops %+v, expected one unresolved-name drop", got)
+	}
+
+	if drop := got.Recent[0]; drop.Key != "online" || drop.Value != "true" {
+		t.Errorf("got drop %+v, expected online=true", drop)
+	}
+
+	var unresolved bool
+	for _, u := range UnresolvedNames() {
+		unresolved = unresolved || u.Hostname == hostname
+	}
+
+	if !unresolved {
+		t.Errorf("%s isn't in the unresolved names", hostname)
+	}
+}
@@ -0,0 +1,73 @@
+package crestrontelnet
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/byuoitav/crestron-telnet-microservice/inventory"
+)
+
+// values of the other-crestron-health-check event
+const (
+	healthOK                 = "ok"
+	healthUnexpectedResponse = "unexpected-response"
+	healthNoResponse         = "no-response"
+)
+
+// defaultForbiddenResponses are replies that mean the console didn't understand the command, no matter what the device expects
+var defaultForbiddenResponses = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)bad or incomplete command`),
+	regexp.MustCompile(`(?i)unknown command`),
+}
+
+// responseCheck decides whether a device's response to its CommandToQuery means it is healthy
+type responseCheck struct {
+	expected  *regexp.Regexp
+	forbidden []*regexp.Regexp
+}
+
+// newResponseCheck compiles dev's expected and forbidden response patterns. A pattern that doesn't
+// compile is skipped, and the error is returned along with a check that uses the rest.
+func newResponseCheck(dev inventory.Device) (responseCheck, error) {
+	var err error
+	c := responseCheck{
+		forbidden: defaultForbiddenResponses,
+	}
+
+	if len(dev.ExpectedResponse) > 0 {
+		c.expected, err = regexp.Compile(dev.ExpectedResponse)
+		if err != nil {
+			err = fmt.Errorf("invalid expected response: %s", err)
+		}
+	}
+
+	for _, pattern := range dev.ForbiddenResponses {
+		re, cerr := regexp.Compile(pattern)
+		if cerr != nil {
+			err = fmt.Errorf("invalid forbidden response: %s", cerr)
+			continue
+		}
+
+		c.forbidden = append(c.forbidden, re)
+	}
+
+	return c, err
+}
+
+// check returns the health check value for response, and why if it isn't ok
+func (c responseCheck) check(response string) (string, string) {
+	for _, re := range c.forbidden {
+		if re.MatchString(response) {
+			return healthUnexpectedResponse, fmt.Sprintf("matched forbidden pattern %q", re.String())
+		}
+	}
+
+	switch {
+	case c.expected != nil && !c.expected.MatchString(response):
+		return healthUnexpectedResponse, fmt.Sprintf("didn't match expected pattern %q", c.expected.String())
+	case c.expected == nil && len(response) == 0:
+		return healthUnexpectedResponse, "response was empty"
+	}
+
+	return healthOK, ""
+}
@@ -0,0 +1,185 @@
+package crestrontelnet
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestResponseCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		expected  string
+		forbidden []string
+		response  string
+		want      string
+	}{
+		{
+			name:     "Expected",
+			expected: `Cntrl Eng \[v`,
+			response: "CP3 Cntrl Eng [v1.503.3568.25373 (Sep 14 2017), #7C7A2C35]",
+			want:     healthOK,
+		},
+		{
+			name:     "AnyResponse",
+			response: "CP3 Cntrl Eng [v1.503.3568.25373 (Sep 14 2017), #7C7A2C35]",
+			want:     healthOK,
+		},
+		{
+			name:     "NotExpected",
+			expected: `Cntrl Eng \[v`,
+			response: "Loading program...",
+			want:     healthUnexpectedResponse,
+		},
+		{
+			name:     "Empty",
+			response: "",
+			want:     healthUnexpectedResponse,
+		},
+		{
+			name:     "DefaultForbidden",
+			response: "Bad or Incomplete Command",
+			want:     healthUnexpectedResponse,
+		},
+		{
+			name:      "Forbidden",
+			expected:  `Cntrl Eng`,
+			forbidden: []string{`(?i)program stopped`},
+			response:  "CP3 Cntrl Eng [v1.503]\r\nProgram Stopped",
+			want:      healthUnexpectedResponse,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dev := testDevice("")
+			dev.ExpectedResponse = tt.expected
+			dev.ForbiddenResponses = tt.forbidden
+
+			check, err := newResponseCheck(dev)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, reason := check.check(tt.response)
+			if got != tt.want {
+				t.Errorf("got %s (%s), expected %s", got, reason, tt.want)
+			}
+
+			if got != healthOK && len(reason) == 0 {
+				t.Errorf("got %s without a reason", got)
+			}
+		})
+	}
+}
+
+func TestResponseCheckInvalidPattern(t *testing.T) {
+	dev := testDevice("")
+	dev.ExpectedResponse = `Cntrl Eng`
+	dev.ForbiddenResponses = []string{`[unclosed`, `(?i)program stopped`}
+
+	check, err := newResponseCheck(dev)
+	if err == nil {
+		t.Errorf("got no error for an invalid forbidden pattern")
+	}
+
+	// the patterns that do compile are still checked
+	if got, _ := check.check("CP3 Cntrl Eng\r\nProgram Stopped"); got != healthUnexpectedResponse {
+		t.Errorf("got %s, expected the valid forbidden pattern to still be checked", got)
+	}
+}
+
+func TestPollOtherCrestron(t *testing.T) {
+	tests := []struct {
+		name    string
+		replies []string
+		want    string
+		state   string
+	}{
+		{
+			name:    "OK",
+			replies: []string{"VERSION\r\nCP3 Cntrl Eng [v1.503]\r\nCP3>"},
+			want:    healthOK,
+			state:   StateOnline,
+		},
+		{
+			name:    "UnexpectedResponse",
+			replies: []string{"VERSION\r\nBad or Incomplete Command\r\nCP3>"},
+			want:    healthUnexpectedResponse,
+			state:   StateDegraded,
+		},
+		{
+			name: "NoResponse",
+			want: healthNoResponse,
+
+			// it was never connected, so it isn't degraded until the monitor reports the error
+			state: StateConnecting,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, restore := captureEvents(t)
+			defer restore()
+
+			conn, closeConsole := fakeConsole(t, "", tt.replies...)
+			defer closeConsole()
+
+			session := testSession(conn, "CP3>")
+			session.Timeout = 50 * time.Millisecond
+
+			dev := session.Device()
+			check, _ := newResponseCheck(dev)
+
+			state := trackConnectivity(dev, false)
+			defer state.stop()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			polled := make(chan error, 1)
+			go func() {
+				polled <- pollOtherCrestron(ctx, dev, session, check, state)
+			}()
+
+			var got []string
+			deadline := time.Now().Add(2 * time.Second)
+
+			for len(got) == 0 {
+				if time.Now().After(deadline) {
+					t.Fatalf("timed out waiting for a health check event")
+				}
+
+				for _, e := range q.Take(100) {
+					if e.Event.Key == "other-crestron-health-check" {
+						got = append(got, e.Event.Value)
+					}
+				}
+
+				time.Sleep(time.Millisecond)
+			}
+
+			if got[0] != tt.want {
+				t.Errorf("got %s, expected %s", got[0], tt.want)
+			}
+
+			if status, _ := ConnectivityStatus(dev.Hostname); status.State != tt.state {
+				t.Errorf("got state %s, expected %s", status.State, tt.state)
+			}
+
+			// it only stops polling on its own once the session fails
+			if tt.want != healthNoResponse {
+				cancel()
+			}
+
+			select {
+			case err := <-polled:
+				if err == nil {
+					t.Errorf("stopped polling without an error")
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("timed out waiting for polling to stop")
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package crestrontelnet
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/byuoitav/crestron-telnet-microservice/inventory"
+)
+
+// reasons a login can fail, sent as the value of the auth-failed event
+const (
+	authNoCredentials = "no-credentials"
+	authBadLogin      = "bad-login"
+	authLockedOut     = "locked-out"
+)
+
+var (
+	usernamePrompt = regexp.MustCompile(`(?i)(user ?name|login)\s*:$`)
+	passwordPrompt = regexp.MustCompile(`(?i)password\s*:$`)
+	badLogin       = regexp.MustCompile(`(?i)(bad|invalid|incorrect|wrong)\s+(user ?name|login|password|credentials)|(login|authentication)\s+(failed|incorrect|denied)|access denied`)
+	lockedOut      = regexp.MustCompile(`(?i)\blocked\b|\blockout\b|too many (failed |invalid |bad )?(login |authentication )?attempts`)
+)
+
+// AuthError is returned when a device's console refuses to let us log in
+type AuthError struct {
+	Hostname string
+
+	// Reason is one of no-credentials, bad-login, or locked-out
+	Reason string
+
+	// Message is what the device said, if anything
+	Message string
+}
+
+func (e *AuthError) Error() string {
+	if len(e.Message) > 0 {
+		return fmt.Sprintf("unable to log in to %s (%s): %s", e.Hostname, e.Reason, e.Message)
+	}
+
+	return fmt.Sprintf("unable to log in to %s (%s)", e.Hostname, e.Reason)
+}
+
+// login reads from a freshly opened console until the first prompt, answering any username and
+// password prompts along the way with dev's credentials. Consoles without authentication go
+// straight to the prompt. It returns everything that was read.
+func login(dev inventory.Device, buf *bufio.ReadWriter) (string, error) {
+	var (
+		transcript strings.Builder
+		line       strings.Builder
+		sentCreds  bool
+		sentPass   bool
+	)
+
+	send := func(s string) error {
+		if _, err := buf.WriteString(s + "\r\n"); err != nil {
+			return err
+		}
+
+		return buf.Flush()
+	}
+
+	for {
+		b, err := buf.ReadByte()
+		if err != nil {
+			// some firmware hangs up right after saying why
+			if err == io.EOF {
+				if authErr := checkLoginLine(dev, line.String(), sentCreds); authErr != nil {
+					return transcript.String(), authErr
+				}
+			}
+
+			return transcript.String(), fmt.Errorf("unable to read first prompt: %s", err)
+		}
+
+		transcript.WriteByte(b)
+
+		switch b {
+		case '>':
+			return transcript.String(), nil
+		case '\n':
+			if authErr := checkLoginLine(dev, line.String(), sentCreds); authErr != nil {
+				return transcript.String(), authErr
+			}
+
+			line.Reset()
+			continue
+		}
+
+		line.WriteByte(b)
+		if b != ':' {
+			continue
+		}
+
+		text := strings.TrimSpace(line.String())
+		switch {
+		case usernamePrompt.MatchString(text):
+			// being asked for the username again means the last attempt didn't work
+			if sentPass {
+				return transcript.String(), &AuthError{Hostname: dev.Hostname, Reason: authBadLogin, Message: text}
+			}
+
+			creds, err := credentialsFor(dev)
+			if err != nil {
+				return transcript.String(), &AuthError{Hostname: dev.Hostname, Reason: authNoCredentials, Message: err.Error()}
+			}
+
+			if err := send(creds.Username); err != nil {
+				return transcript.String(), fmt.Errorf("unable to send username: %s", err)
+			}
+
+			sentCreds = true
+			line.Reset()
+		case passwordPrompt.MatchString(text):
+			if sentPass {
+				return transcript.String(), &AuthError{Hostname: dev.Hostname, Reason: authBadLogin, Message: text}
+			}
+
+			creds, err := credentialsFor(dev)
+			if err != nil {
+				return transcript.String(), &AuthError{Hostname: dev.Hostname, Reason: authNoCredentials, Message: err.Error()}
+			}
+
+			if err := send(creds.Password); err != nil {
+				return transcript.String(), fmt.Errorf("unable to send password: %s", err)
+			}
+
+			sentCreds = true
+			sentPass = true
+			line.Reset()
+		}
+	}
+}
+
+// checkLoginLine returns an AuthError if line says the login was rejected. Lines are only
+// checked once credentials have been sent, so that banners can't trip it.
+func checkLoginLine(dev inventory.Device, line string, sentCreds bool) *AuthError {
+	line = strings.TrimSpace(line)
+
+	switch {
+	case len(line) == 0 || !sentCreds:
+		return nil
+	case lockedOut.MatchString(line):
+		return &AuthError{Hostname: dev.Hostname, Reason: authLockedOut, Message: line}
+	case badLogin.MatchString(line):
+		return &AuthError{Hostname: dev.Hostname, Reason: authBadLogin, Message: line}
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package crestrontelnet
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/byuoitav/common/structs"
+	"github.com/byuoitav/crestron-telnet-microservice/inventory"
+)
+
+// fakeConsole is a device's console. It prints greeting, then prints the next reply every time a
+// line is sent to it. Once it runs out of replies it keeps reading without answering. The returned
+// func closes the connection and returns every line that was sent to the console.
+func fakeConsole(t *testing.T, greeting string, replies ...string) (Conn, func() []string) {
+	t.Helper()
+
+	client, device := net.Pipe()
+	done := make(chan []string)
+
+	go func() {
+		defer device.Close()
+
+		var sent []string
+		defer func() { done <- sent }()
+
+		r := bufio.NewReader(device)
+
+		// writes to a pipe block until they are read, even empty ones
+		if len(greeting) > 0 {
+			if _, err := device.Write([]byte(greeting)); err != nil {
+				return
+			}
+		}
+
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			sent = append(sent, strings.TrimRight(line, "\r\n"))
+			if len(replies) == 0 {
+				continue
+			}
+
+			if _, err := device.Write([]byte(replies[0])); err != nil {
+				return
+			}
+
+			replies = replies[1:]
+		}
+	}()
+
+	return client, func() []string {
+		client.Close()
+		return <-done
+	}
+}
+
+func testDevice(credentials string) inventory.Device {
+	return inventory.Device{
+		DMPS: structs.DMPS{
+			Hostname: "ITB-1101-CP1",
+			Address:  "10.5.34.10",
+		},
+		Credentials: credentials,
+	}
+}
+
+func TestLogin(t *testing.T) {
+	SetCredentials(map[string]Credentials{
+		DefaultCredentials: {Username: "admin", Password: "hunter2"},
+	})
+	defer SetCredentials(nil)
+
+	tests := []struct {
+		name        string
+		credentials string
+		greeting    string
+		replies     []string
+
+		// reason is the AuthError's reason, or empty if the login should work
+		reason string
+		sent   []string
+	}{
+		{
+			name:     "NoAuth",
+			greeting: "DMPS3-4K-150-C Control Console\r\n\r\nDMPS3-4K-150-C>",
+		},
+		{
+			// banners can say anything before any credentials have been sent
+			name:     "NoAuthBanner",
+			greeting: "Unauthorized access is prohibited. Accounts are locked after too many failed attempts.\r\nDMPS3-4K-150-C>",
+		},
+		{
+			name:     "UsernamePassword",
+			greeting: "DMPS3-4K-150-C Control Console\r\nLogin: ",
+			replies:  []string{"Password: ", "\r\nWelcome admin\r\nDMPS3-4K-150-C>"},
+			sent:     []string{"admin", "hunter2"},
+		},
+		{
+			name:     "PasswordOnly",
+			greeting: "Password: ",
+			replies:  []string{"\r\nDMPS3-4K-150-C>"},
+			sent:     []string{"hunter2"},
+		},
+		{
+			name:     "BadLogin",
+			greeting: "Login: ",
+			replies:  []string{"Password: ", "\r\nBad user name or password\r\n"},
+			reason:   authBadLogin,
+			sent:     []string{"admin", "hunter2"},
+		},
+		{
+			name:     "UsernameReprompt",
+			greeting: "Login: ",
+			replies:  []string{"Password: ", "\r\nLogin: "},
+			reason:   authBadLogin,
+			sent:     []string{"admin", "hunter2"},
+		},
+		{
+			name:     "PasswordReprompt",
+			greeting: "Password: ",
+			replies:  []string{"\r\nPassword: "},
+			reason:   authBadLogin,
+			sent:     []string{"hunter2"},
+		},
+		{
+			name:     "LockedOut",
+			greeting: "Login: ",
+			replies:  []string{"Password: ", "\r\nAccount locked: too many failed login attempts\r\n"},
+			reason:   authLockedOut,
+			sent:     []string{"admin", "hunter2"},
+		},
+		{
+			name:        "NoCredentials",
+			credentials: "missing",
+			greeting:    "Login: ",
+			reason:      authNoCredentials,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, closeConsole := fakeConsole(t, tt.greeting, tt.replies...)
+			buf := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+			transcript, err := login(testDevice(tt.credentials), buf)
+			sent := closeConsole()
+
+			var authErr *AuthError
+			switch {
+			case len(tt.reason) == 0 && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case len(tt.reason) == 0 && !strings.HasSuffix(transcript, "DMPS3-4K-150-C>"):
+				t.Errorf("got transcript %q, expected it to end at the prompt", transcript)
+			case len(tt.reason) > 0 && !errors.As(err, &authErr):
+				t.Fatalf("got error %v, expected an AuthError", err)
+			case len(tt.reason) > 0 && authErr.Reason != tt.reason:
+				t.Errorf("got reason %q, expected %q (%s)", authErr.Reason, tt.reason, authErr)
+			}
+
+			if len(sent) != 0 || len(tt.sent) != 0 {
+				if !reflect.DeepEqual(sent, tt.sent) {
+					t.Errorf("sent %q, expected %q", sent, tt.sent)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package crestrontelnet
+
+import (
+	"strings"
+	"sync"
+
+	eventparser "github.com/byuoitav/crestron-telnet-microservice/event-parser"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// metricsNamespace prefixes every metric the service exports
+	metricsNamespace = "crestron_telnet"
+
+	// otherMetricKey is the key label for every event key that isn't in metricKeys
+	otherMetricKey = "other"
+)
+
+var (
+	// metricKeys are the event keys that get their own key label. Keys are whatever the consoles print,
+	// so everything else is counted under otherMetricKey to keep the number of series bounded.
+	metricKeysMu sync.RWMutex
+	metricKeys   = map[string]bool{
+		"software-version":             true,
+		"hardware-version":             true,
+		"volume":                       true,
+		"muted":                        true,
+		"ip-address":                   true,
+		"responsive":                   true,
+		"battery-charge-hours-minutes": true,
+		"battery-charge-minutes":       true,
+		"battery-type":                 true,
+		"connection-state":             true,
+		"online":                       true,
+		"auth-failed":                  true,
+		"error-log":                    true,
+		"reboot-detected":              true,
+	}
+)
+
+var (
+	connectionState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "device_connection_state",
+		Help:      "1 for the connection state each device is in, 0 for the others.",
+	}, []string{"hostname", "state"})
+
+	reconnects = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "device_reconnects_total",
+		Help:      "Attempts to reconnect to each device after a failed or lost connection.",
+	}, []string{"hostname"})
+
+	lastEvent = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "device_last_event_timestamp_seconds",
+		Help:      "Unix time of the last event parsed from each dmps, or the last health check response from each other crestron device.",
+	}, []string{"hostname"})
+
+	eventsParsed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_parsed_total",
+		Help:      "Events parsed from dmps consoles, by key. Keys that aren't tracked are counted as other.",
+	}, []string{"key"})
+
+	eventsMalformed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_malformed_total",
+		Help:      "Lines that looked like events but couldn't be parsed.",
+	})
+
+	eventsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_dropped_total",
+		Help:      "Events that were not sent, by key and reason. Keys that aren't tracked are counted as other.",
+	}, []string{"key", "reason"})
+
+	eventsSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_sent_total",
+		Help:      "Events delivered to each event processor.",
+	}, []string{"destination"})
+
+	sendFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "event_send_failures_total",
+		Help:      "Failed requests to each event processor.",
+	}, []string{"destination"})
+
+	sendDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "event_send_duration_seconds",
+		Help:      "How long requests to each event processor take, whether or not they succeed.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"destination"})
+
+	queueDepth = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "event_queue_depth"),
+		"Events waiting to be delivered to each event processor.",
+		[]string{"destination"}, nil)
+
+	queueDropped = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "event_queue_dropped_total"),
+		"Events dropped because an event processor's queue was full.",
+		[]string{"destination"}, nil)
+)
+
+func init() {
+	prometheus.MustRegister(queueCollector{})
+}
+
+// queueCollector reports each destination's queue stats when metrics are scraped
+type queueCollector struct{}
+
+func (queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDepth
+	ch <- queueDropped
+}
+
+func (queueCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, d := range destinations {
+		stats := d.queue.Stats()
+
+		ch <- prometheus.MustNewConstMetric(queueDepth, prometheus.GaugeValue, float64(stats.Depth+stats.InFlight), d.url)
+		ch <- prometheus.MustNewConstMetric(queueDropped, prometheus.CounterValue, float64(stats.Dropped), d.url)
+	}
+}
+
+// AddMetricKeys gives each of keys its own key label on the event metrics, on top of the keys that already have one
+func AddMetricKeys(keys []string) {
+	metricKeysMu.Lock()
+	defer metricKeysMu.Unlock()
+
+	for _, key := range keys {
+		key = eventparser.Normalize(strings.TrimSpace(key))
+		if len(key) > 0 {
+			metricKeys[key] = true
+		}
+	}
+}
+
+// metricKey returns the key label to use for an event's key
+func metricKey(key string) string {
+	key = eventparser.Normalize(key)
+
+	metricKeysMu.RLock()
+	defer metricKeysMu.RUnlock()
+
+	if metricKeys[key] {
+		return key
+	}
+
+	return otherMetricKey
+}
+
+// dropReason trims the details off of a drop's reason (e.g. why it was malformed) so it can be used as a label
+func dropReason(reason string) string {
+	return strings.TrimSpace(strings.SplitN(reason, ":", 2)[0])
+}
+
+// forgetDeviceMetrics removes hostname's per-device metrics once it is no longer monitored
+func forgetDeviceMetrics(hostname string) {
+	for _, state := range []string{StateConnecting, StateOnline, StateDegraded, StateOffline} {
+		connectionState.DeleteLabelValues(hostname, state)
+	}
+
+	reconnects.DeleteLabelValues(hostname)
+	lastEvent.DeleteLabelValues(hostname)
+}
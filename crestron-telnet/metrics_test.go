@@ -0,0 +1,26 @@
+package crestrontelnet
+
+import (
+	"testing"
+)
+
+func TestMetricKey(t *testing.T) {
+	AddMetricKeys([]string{" Input Source ", ""})
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "software-version", want: "software-version"},
+		{key: "IP Address", want: "ip-address"},
+		{key: "input source", want: "input-source"},
+		{key: "Some Key Only One Device Prints", want: otherMetricKey},
+		{key: "", want: otherMetricKey},
+	}
+
+	for _, tt := range tests {
+		if got := metricKey(tt.key); got != tt.want {
+			t.Errorf("got %q for %q, expected %q", got, tt.key, tt.want)
+		}
+	}
+}
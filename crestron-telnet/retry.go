@@ -0,0 +1,128 @@
+package crestrontelnet
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/byuoitav/crestron-telnet-microservice/inventory"
+)
+
+// defaultRetryPolicy is used for any part of a device's retry policy it doesn't set
+var defaultRetryPolicy = inventory.RetryPolicy{
+	InitialDelay: 5 * time.Second,
+	MaxDelay:     5 * time.Minute,
+	Multiplier:   2,
+	Jitter:       float64Ptr(0.2),
+	MaxAttempts:  intPtr(0),
+}
+
+// SetRetryPolicy sets the reconnect policy for devices that don't have their own.
+// Delays and the multiplier keep their current value if left as zero, and jitter and
+// max attempts keep theirs if left nil.
+func SetRetryPolicy(policy inventory.RetryPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return fmt.Errorf("invalid retry policy: %s", err)
+	}
+
+	policy = mergeRetryPolicy(defaultRetryPolicy, &policy)
+
+	if policy.MaxDelay < policy.InitialDelay {
+		return fmt.Errorf("invalid retry policy: max delay (%v) is less than initial delay (%v)", policy.MaxDelay, policy.InitialDelay)
+	}
+
+	defaultRetryPolicy = policy
+	return nil
+}
+
+// retryPolicyFor returns dev's retry policy, filled in with the defaults
+func retryPolicyFor(dev inventory.Device) inventory.RetryPolicy {
+	policy := mergeRetryPolicy(defaultRetryPolicy, dev.Retry)
+	if policy.MaxDelay < policy.InitialDelay {
+		policy.MaxDelay = policy.InitialDelay
+	}
+
+	return policy
+}
+
+// SessionRetryDelay returns how long to wait before trying to open another session to dev, after
+// attempt failures in a row (starting at 1), the last of which failed with err. It follows dev's
+// retry policy, and waits at least as long as the monitor does after a rejected login so that
+// retrying sessions can't get the account locked out.
+func SessionRetryDelay(dev inventory.Device, err error, attempt int) time.Duration {
+	delay := retryDelay(retryPolicyFor(dev), attempt)
+
+	var authErr *AuthError
+	if errors.As(err, &authErr) && delay < authRetryInterval {
+		delay = authRetryInterval
+	}
+
+	return delay
+}
+
+// mergeRetryPolicy returns base with every field that is set in override replaced
+func mergeRetryPolicy(base inventory.RetryPolicy, override *inventory.RetryPolicy) inventory.RetryPolicy {
+	if override == nil {
+		return base
+	}
+
+	if override.InitialDelay > 0 {
+		base.InitialDelay = override.InitialDelay
+	}
+
+	if override.MaxDelay > 0 {
+		base.MaxDelay = override.MaxDelay
+	}
+
+	if override.Multiplier > 0 {
+		base.Multiplier = override.Multiplier
+	}
+
+	if override.Jitter != nil {
+		base.Jitter = float64Ptr(*override.Jitter)
+	}
+
+	if override.MaxAttempts != nil {
+		base.MaxAttempts = intPtr(*override.MaxAttempts)
+	}
+
+	return base
+}
+
+// retryDelay returns how long to wait before the given retry (starting at 1), growing by the
+// policy's multiplier each time until it reaches the max delay, then randomized by its jitter
+// so that devices that went down together don't all reconnect at the same instant.
+func retryDelay(policy inventory.RetryPolicy, attempt int) time.Duration {
+	delay := float64(policy.InitialDelay)
+	for i := 1; i < attempt && delay < float64(policy.MaxDelay); i++ {
+		delay *= policy.Multiplier
+	}
+
+	if delay > float64(policy.MaxDelay) {
+		delay = float64(policy.MaxDelay)
+	}
+
+	if policy.Jitter != nil {
+		delay += delay * *policy.Jitter * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(delay)
+}
+
+// maxAttempts returns how many retries in a row can fail before a device using policy is marked offline, 0 if there is no limit
+func maxAttempts(policy inventory.RetryPolicy) int {
+	if policy.MaxAttempts == nil {
+		return 0
+	}
+
+	return *policy.MaxAttempts
+}
+
+func float64Ptr(f float64) *float64 {
+	return &f
+}
+
+func intPtr(i int) *int {
+	return &i
+}
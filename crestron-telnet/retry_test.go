@@ -0,0 +1,147 @@
+package crestrontelnet
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/byuoitav/crestron-telnet-microservice/inventory"
+)
+
+func TestSessionRetryDelay(t *testing.T) {
+	dev := testDevice("")
+	dev.Retry = &inventory.RetryPolicy{
+		InitialDelay: time.Second,
+		MaxDelay:     time.Minute,
+		Multiplier:   2,
+		Jitter:       float64Ptr(0.1),
+	}
+
+	within := func(got, want time.Duration) bool {
+		return got >= want-want/10 && got <= want+want/10
+	}
+
+	refused := errors.New("connection refused")
+
+	if d := SessionRetryDelay(dev, refused, 1); !within(d, time.Second) {
+		t.Errorf("got %v for the first retry, expected about 1s", d)
+	}
+
+	if d := SessionRetryDelay(dev, refused, 4); !within(d, 8*time.Second) {
+		t.Errorf("got %v for the fourth retry, expected about 8s", d)
+	}
+
+	if d := SessionRetryDelay(dev, refused, 20); !within(d, time.Minute) {
+		t.Errorf("got %v for the twentieth retry, expected about the max of 1m", d)
+	}
+
+	// rejected logins wait as long as the monitor does, however early the retry is
+	authErr := fmt.Errorf("unable to log in: %w", &AuthError{Hostname: dev.Hostname, Reason: authBadLogin})
+	if d := SessionRetryDelay(dev, authErr, 1); d < authRetryInterval {
+		t.Errorf("got %v after a bad login, expected at least %v", d, authRetryInterval)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	policy := inventory.RetryPolicy{
+		InitialDelay: time.Second,
+		MaxDelay:     10 * time.Second,
+		Multiplier:   2,
+		Jitter:       float64Ptr(0),
+	}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, w := range want {
+		if got := retryDelay(policy, i+1); got != w {
+			t.Errorf("got %v for attempt %v, expected %v", got, i+1, w)
+		}
+	}
+
+	// jitter stays within its fraction of the delay
+	policy.Jitter = float64Ptr(0.5)
+	for i := 0; i < 100; i++ {
+		if got := retryDelay(policy, 4); got < 4*time.Second || got > 12*time.Second {
+			t.Fatalf("got %v for attempt 4 with 50%% jitter, expected between 4s and 12s", got)
+		}
+	}
+
+	// a huge attempt count doesn't overflow past the max
+	if got := retryDelay(policy, 1000000); got > 15*time.Second {
+		t.Errorf("got %v for a huge attempt count, expected no more than the max plus jitter", got)
+	}
+}
+
+func TestMergeRetryPolicyZeroOverrides(t *testing.T) {
+	base := inventory.RetryPolicy{
+		InitialDelay: 5 * time.Second,
+		MaxDelay:     5 * time.Minute,
+		Multiplier:   2,
+		Jitter:       float64Ptr(0.2),
+		MaxAttempts:  intPtr(5),
+	}
+
+	// zero delays and multipliers aren't valid, so they keep the default.
+	// Zero jitter and max attempts are, so they override it.
+	got := mergeRetryPolicy(base, &inventory.RetryPolicy{
+		Jitter:      float64Ptr(0),
+		MaxAttempts: intPtr(0),
+	})
+
+	if got.InitialDelay != base.InitialDelay || got.MaxDelay != base.MaxDelay || got.Multiplier != base.Multiplier {
+		t.Errorf("got %+v, expected the delays and multiplier to be left alone", got)
+	}
+
+	if got.Jitter == nil || *got.Jitter != 0 {
+		t.Errorf("got jitter %v, expected it to be overridden to 0", got.Jitter)
+	}
+
+	if maxAttempts(got) != 0 {
+		t.Errorf("got max attempts %v, expected it to be overridden to 0", maxAttempts(got))
+	}
+
+	// leaving them nil keeps the default
+	if got := mergeRetryPolicy(base, &inventory.RetryPolicy{}); *got.Jitter != 0.2 || maxAttempts(got) != 5 {
+		t.Errorf("got jitter %v and max attempts %v, expected the defaults", *got.Jitter, maxAttempts(got))
+	}
+}
+
+func TestBackoffOnlyResetsAfterStableConnection(t *testing.T) {
+	_, restore := captureEvents(t)
+	defer restore()
+	defer setGracePeriod(time.Hour)()
+
+	prev := stableConnection
+	stableConnection = 50 * time.Millisecond
+	defer func() { stableConnection = prev }()
+
+	c := trackConnectivity(testDevice(""), false)
+	defer c.stop()
+
+	attempts := func() int {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		return c.status.Retry.Attempts
+	}
+
+	// the device accepts the connection and drops it right away, over and over
+	for i := 1; i <= 3; i++ {
+		c.connected()
+		c.disconnected("connection reset")
+		c.retry(0)
+
+		if got := attempts(); got != i {
+			t.Fatalf("got %v attempts after %v dropped connections, expected %v", got, i, i)
+		}
+	}
+
+	c.connected()
+	time.Sleep(2 * stableConnection)
+	c.disconnected("connection reset")
+	c.retry(0)
+
+	if got := attempts(); got != 1 {
+		t.Errorf("got %v attempts after a stable connection dropped, expected the backoff to start over at 1", got)
+	}
+}
@@ -0,0 +1,212 @@
+package crestrontelnet
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/byuoitav/crestron-telnet-microservice/inventory"
+)
+
+// DefaultCommandTimeout is how long Execute waits for the prompt to come back if a Session's Timeout isn't set
+const DefaultCommandTimeout = 30 * time.Second
+
+// Session runs commands on a device's console one at a time, using the prompt the
+// console printed when the session was opened to tell where each response ends.
+type Session struct {
+	// Timeout is how long each command has to finish
+	Timeout time.Duration
+
+	device inventory.Device
+	conn   Conn
+	buf    *bufio.ReadWriter
+	prompt string
+
+	mu     sync.Mutex
+	broken error
+}
+
+// OpenSession connects and logs in to dev's console, and learns its prompt
+func OpenSession(ctx context.Context, dev inventory.Device) (*Session, error) {
+	dev = withTransportDefaults(dev)
+
+	conn, buf, banner, err := connect(ctx, dev)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Session{
+		Timeout: DefaultCommandTimeout,
+		device:  dev,
+		conn:    conn,
+		buf:     buf,
+		prompt:  learnPrompt(banner),
+	}, nil
+}
+
+// Prompt returns the prompt that marks the end of each response, e.g. DMPS3-4K-150-C>
+func (s *Session) Prompt() string {
+	return s.prompt
+}
+
+// Device returns the device the session is connected to
+func (s *Session) Device() inventory.Device {
+	return s.device
+}
+
+// Err returns the error that made the session unusable, or nil if it can still run commands
+func (s *Session) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.broken
+}
+
+// Close closes the connection to the console
+func (s *Session) Close() error {
+	return s.conn.Close()
+}
+
+// Execute runs cmd and returns everything it printed before the next prompt, without the echoed command.
+//
+// If the prompt doesn't come back before the session's Timeout (or ctx is done), whatever was read
+// so far is returned along with an error. Since the console could still finish responding at any
+// point, the session can't tell which output belongs to which command anymore, so every later
+// call to Execute fails and the session should be closed and reopened.
+func (s *Session) Execute(ctx context.Context, cmd string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.broken != nil {
+		return "", fmt.Errorf("session is unusable after an earlier error: %s", s.broken)
+	}
+
+	// throw away anything the console printed on its own since the last command
+	if n := s.buf.Reader.Buffered(); n > 0 {
+		s.buf.Reader.Discard(n)
+	}
+
+	if _, err := s.buf.WriteString(cmd + "\r\n"); err != nil {
+		s.broken = err
+		return "", fmt.Errorf("unable to write command: %s", err)
+	}
+
+	if err := s.buf.Flush(); err != nil {
+		s.broken = err
+		return "", fmt.Errorf("unable to write command: %s", err)
+	}
+
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = DefaultCommandTimeout
+	}
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	if err := s.conn.SetReadDeadline(deadline); err != nil {
+		s.broken = err
+		return "", fmt.Errorf("unable to set deadline: %s", err)
+	}
+
+	stop := interruptOnDone(ctx, s.conn)
+	raw, err := s.readToPrompt()
+	stop()
+
+	output := cleanOutput(cmd, raw, s.prompt)
+
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		} else if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+			err = fmt.Errorf("timed out after %v waiting for %q", timeout, s.prompt)
+		}
+
+		s.broken = err
+		return output, fmt.Errorf("unable to run %q: %s", cmd, err)
+	}
+
+	return output, nil
+}
+
+// readToPrompt reads until the session's prompt
+func (s *Session) readToPrompt() (string, error) {
+	var out strings.Builder
+
+	for {
+		b, err := s.buf.ReadByte()
+		if err != nil {
+			return out.String(), err
+		}
+
+		out.WriteByte(b)
+		if b == '>' && strings.HasSuffix(out.String(), s.prompt) {
+			return out.String(), nil
+		}
+	}
+}
+
+// interruptOnDone unblocks any reads on conn as soon as ctx is cancelled, without closing it.
+// The returned func must be called once conn is no longer being read from, and doesn't return
+// until the deadline is guaranteed not to be touched anymore.
+func interruptOnDone(ctx context.Context, conn Conn) func() {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+
+	go func() {
+		defer close(exited)
+
+		select {
+		case <-ctx.Done():
+			conn.SetReadDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-exited
+	}
+}
+
+// learnPrompt returns the last line of the banner, which is the console's prompt
+func learnPrompt(banner string) string {
+	banner = strings.TrimRight(banner, " \r\n")
+	if i := strings.LastIndexAny(banner, "\r\n"); i >= 0 {
+		banner = banner[i+1:]
+	}
+
+	banner = strings.TrimSpace(banner)
+	if len(banner) == 0 || !strings.HasSuffix(banner, ">") {
+		return ">"
+	}
+
+	return banner
+}
+
+// cleanOutput strips the echoed command, trailing prompt, and carriage returns from a response
+func cleanOutput(cmd, raw, prompt string) string {
+	raw = strings.TrimSuffix(raw, prompt)
+	raw = strings.Replace(raw, "\r", "", -1)
+
+	lines := strings.Split(raw, "\n")
+	for len(lines) > 0 && len(strings.TrimSpace(lines[0])) == 0 {
+		lines = lines[1:]
+	}
+
+	if len(lines) > 0 && strings.TrimSpace(lines[0]) == strings.TrimSpace(cmd) {
+		lines = lines[1:]
+	}
+
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], " \t")
+	}
+
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
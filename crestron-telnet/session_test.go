@@ -0,0 +1,182 @@
+package crestrontelnet
+
+import (
+	"bufio"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSession(conn Conn, prompt string) *Session {
+	return &Session{
+		Timeout: time.Second,
+		device:  testDevice(""),
+		conn:    conn,
+		buf:     bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)),
+		prompt:  prompt,
+	}
+}
+
+func TestLearnPrompt(t *testing.T) {
+	tests := map[string]string{
+		"DMPS3-4K-150-C Control Console\r\n\r\nDMPS3-4K-150-C>": "DMPS3-4K-150-C>",
+		"Login: admin\r\nPassword: \r\nCP3>":                    "CP3>",
+		"DMPS3-4K-150-C>  \r\n":                                 "DMPS3-4K-150-C>",
+		">":                                                     ">",
+		"\r\n>":                                                 ">",
+		"":                                                      ">",
+		"no prompt here\r\n":                                    ">",
+	}
+
+	for banner, want := range tests {
+		if got := learnPrompt(banner); got != want {
+			t.Errorf("learnPrompt(%q) = %q, expected %q", banner, got, want)
+		}
+	}
+}
+
+func TestCleanOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		cmd    string
+		raw    string
+		prompt string
+		want   string
+	}{
+		{
+			name:   "Echo",
+			cmd:    "ver",
+			raw:    "ver\r\nDMPS3-4K-150-C Cntrl Eng [v1.502.0004]\r\nDMPS3-4K-150-C>",
+			prompt: "DMPS3-4K-150-C>",
+			want:   "DMPS3-4K-150-C Cntrl Eng [v1.502.0004]",
+		},
+		{
+			name:   "NoEcho",
+			cmd:    "ver",
+			raw:    "\r\nDMPS3-4K-150-C Cntrl Eng [v1.502.0004]\r\nDMPS3-4K-150-C>",
+			prompt: "DMPS3-4K-150-C>",
+			want:   "DMPS3-4K-150-C Cntrl Eng [v1.502.0004]",
+		},
+		{
+			name:   "MultiLine",
+			cmd:    "ipconfig",
+			raw:    "ipconfig \r\n\r\nEthernet Adapter [LAN]:\r\n   IP Address ... : 10.5.34.10   \r\n   Subnet Mask .. : 255.255.255.0\r\n\r\nCP3>",
+			prompt: "CP3>",
+			want:   "Ethernet Adapter [LAN]:\n   IP Address ... : 10.5.34.10\n   Subnet Mask .. : 255.255.255.0",
+		},
+		{
+			// the echo is only stripped from the start
+			name:   "CommandInOutput",
+			cmd:    "err",
+			raw:    "err\r\nerr\r\n>",
+			prompt: ">",
+			want:   "err",
+		},
+		{
+			name:   "Empty",
+			cmd:    "progreset",
+			raw:    "progreset\r\nCP3>",
+			prompt: "CP3>",
+			want:   "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cleanOutput(tt.cmd, tt.raw, tt.prompt); got != tt.want {
+				t.Errorf("got %q, expected %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecute(t *testing.T) {
+	conn, closeConsole := fakeConsole(t, "",
+		"ver\r\nDMPS3-4K-150-C Cntrl Eng [v1.502.0004]\r\nDMPS3-4K-150-C>",
+		"ipconfig\r\n   IP Address ... : 10.5.34.10\r\n   Default Gateway -> 10.5.34.1\r\n\r\nDMPS3-4K-150-C>",
+	)
+
+	s := testSession(conn, "DMPS3-4K-150-C>")
+
+	out, err := s.Execute(context.Background(), "ver")
+	if err != nil {
+		t.Fatalf("unable to run ver: %s", err)
+	}
+
+	if want := "DMPS3-4K-150-C Cntrl Eng [v1.502.0004]"; out != want {
+		t.Errorf("got %q, expected %q", out, want)
+	}
+
+	// a > that isn't part of the prompt doesn't end the response
+	out, err = s.Execute(context.Background(), "ipconfig")
+	if err != nil {
+		t.Fatalf("unable to run ipconfig: %s", err)
+	}
+
+	if want := "   IP Address ... : 10.5.34.10\n   Default Gateway -> 10.5.34.1"; out != want {
+		t.Errorf("got %q, expected %q", out, want)
+	}
+
+	if sent := closeConsole(); !reflect.DeepEqual(sent, []string{"ver", "ipconfig"}) {
+		t.Errorf("sent %q, expected [ver ipconfig]", sent)
+	}
+}
+
+func TestExecuteBarePrompt(t *testing.T) {
+	conn, closeConsole := fakeConsole(t, "", "hostname\r\nHost Name: ITB-1101-CP1\r\n>")
+	defer closeConsole()
+
+	s := testSession(conn, ">")
+
+	out, err := s.Execute(context.Background(), "hostname")
+	if err != nil {
+		t.Fatalf("unable to run hostname: %s", err)
+	}
+
+	if want := "Host Name: ITB-1101-CP1"; out != want {
+		t.Errorf("got %q, expected %q", out, want)
+	}
+}
+
+func TestExecuteTimeoutBreaksSession(t *testing.T) {
+	// the prompt never comes back
+	conn, closeConsole := fakeConsole(t, "", "ver\r\nDMPS3-4K-150-C Cntrl")
+	defer closeConsole()
+
+	s := testSession(conn, "DMPS3-4K-150-C>")
+	s.Timeout = 50 * time.Millisecond
+
+	out, err := s.Execute(context.Background(), "ver")
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("got error %v, expected a timeout", err)
+	}
+
+	if out != "DMPS3-4K-150-C Cntrl" {
+		t.Errorf("got %q, expected what was read before the timeout", out)
+	}
+
+	if _, err := s.Execute(context.Background(), "ver"); err == nil || !strings.Contains(err.Error(), "unusable") {
+		t.Errorf("got error %v, expected the session to be unusable", err)
+	}
+}
+
+func TestExecuteCancelled(t *testing.T) {
+	conn, closeConsole := fakeConsole(t, "", "ver\r\n")
+	defer closeConsole()
+
+	s := testSession(conn, "DMPS3-4K-150-C>")
+	s.Timeout = time.Minute
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	if _, err := s.Execute(ctx, "ver"); err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Fatalf("got error %v, expected it to be cancelled", err)
+	}
+
+	if _, err := s.Execute(context.Background(), "ver"); err == nil {
+		t.Errorf("ran a command on a session that was cancelled mid-command")
+	}
+}
@@ -0,0 +1,38 @@
+package crestrontelnet
+
+import (
+	"github.com/byuoitav/crestron-telnet-microservice/inventory"
+)
+
+// DeviceStatus is what the service knows about its connection to a monitored device
+type DeviceStatus struct {
+	Hostname     string           `json:"hostname"`
+	Address      string           `json:"address"`
+	Port         string           `json:"port"`
+	Transport    string           `json:"transport"`
+	Connectivity Connectivity     `json:"connectivity"`
+	Config       inventory.Device `json:"config"`
+}
+
+// Status returns dev's status. Address, Port, and Transport are what is actually used to connect,
+// after the defaults are applied, while Config is dev as it came from the device list.
+func Status(dev inventory.Device) DeviceStatus {
+	conn := withTransportDefaults(dev)
+
+	status := DeviceStatus{
+		Hostname:  dev.Hostname,
+		Address:   conn.Address,
+		Port:      conn.Port,
+		Transport: conn.Transport,
+		Config:    dev,
+	}
+
+	if c, ok := ConnectivityStatus(dev.Hostname); ok {
+		status.Connectivity = c
+	} else {
+		// its monitor hasn't started tracking it yet
+		status.Connectivity.State = StateConnecting
+	}
+
+	return status
+}
@@ -0,0 +1,41 @@
+package crestrontelnet
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/byuoitav/crestron-telnet-microservice/inventory"
+)
+
+func TestStatus(t *testing.T) {
+	dev := testDevice("")
+
+	// its monitor hasn't started tracking it yet
+	status := Status(dev)
+
+	if status.Hostname != dev.Hostname || status.Address != dev.Address || status.Connectivity.State != StateConnecting {
+		t.Errorf("got %+v, expected %s connecting", status, dev.Hostname)
+	}
+
+	// the defaults are filled in, but the config is left as it came from the list
+	if status.Transport != defaultTransport || status.Port != "23" || !reflect.DeepEqual(status.Config, dev) {
+		t.Errorf("got transport %s, port %s, and config %+v, expected the defaults and the config as is", status.Transport, status.Port, status.Config)
+	}
+
+	dev.Transport = inventory.TransportSSH
+	if status := Status(dev); status.Transport != inventory.TransportSSH || status.Port != "22" {
+		t.Errorf("got transport %s and port %s, expected ssh on 22", status.Transport, status.Port)
+	}
+
+	_, restore := captureEvents(t)
+	defer restore()
+
+	c := trackConnectivity(dev, false)
+	defer c.stop()
+
+	c.connected()
+
+	if status := Status(dev); status.Connectivity.State != StateOnline || status.Connectivity.ConnectedSince.IsZero() {
+		t.Errorf("got %+v, expected it to be online", status.Connectivity)
+	}
+}
@@ -0,0 +1,149 @@
+package crestrontelnet
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/byuoitav/common/v2/events"
+)
+
+// streamBuffer is how many messages a subscriber can fall behind by before messages are dropped
+const streamBuffer = 256
+
+// stream message types
+const (
+	// StreamLine is a raw line read from the device's console
+	StreamLine = "line"
+
+	// StreamEvent is an event that was sent about the device
+	StreamEvent = "event"
+)
+
+// broadcasters holds a *broadcaster for every hostname that is monitored or streamed, so that subscribers
+// and the monitor share one, even across restarts of the monitor. They are removed by ForgetDevice.
+var broadcasters sync.Map
+
+// StreamMessage is a single line or event from a device's connection
+type StreamMessage struct {
+	Time  time.Time     `json:"time"`
+	Type  string        `json:"type"`
+	Line  string        `json:"line,omitempty"`
+	Event *events.Event `json:"event,omitempty"`
+}
+
+// broadcaster passes what is read from a single device on to everyone streaming it
+type broadcaster struct {
+	// subscribers is read on every line, so that nothing is locked when nobody is listening
+	subscribers int32
+
+	mu   sync.Mutex
+	subs map[chan StreamMessage]struct{}
+
+	// forgotten is whether the broadcaster has been removed from broadcasters
+	forgotten bool
+}
+
+func broadcasterFor(hostname string) *broadcaster {
+	if b, ok := broadcasters.Load(hostname); ok {
+		return b.(*broadcaster)
+	}
+
+	b, _ := broadcasters.LoadOrStore(hostname, &broadcaster{
+		subs: make(map[chan StreamMessage]struct{}),
+	})
+
+	return b.(*broadcaster)
+}
+
+// Subscribe streams every line read from hostname's connection, and every event sent about it,
+// until the returned func is called. If the channel isn't read from fast enough, messages are dropped.
+// The channel is closed if hostname stops being monitored.
+func Subscribe(hostname string) (<-chan StreamMessage, func()) {
+	b := broadcasterFor(hostname)
+	ch := make(chan StreamMessage, streamBuffer)
+
+	b.mu.Lock()
+	if b.forgotten {
+		b.mu.Unlock()
+		close(ch)
+		return ch, func() {}
+	}
+
+	b.subs[ch] = struct{}{}
+	atomic.StoreInt32(&b.subscribers, int32(len(b.subs)))
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			atomic.StoreInt32(&b.subscribers, int32(len(b.subs)))
+			b.mu.Unlock()
+		})
+	}
+}
+
+// forgetBroadcaster removes hostname's broadcaster, closing the channel of everyone streaming it
+func forgetBroadcaster(hostname string) {
+	v, ok := broadcasters.Load(hostname)
+	if !ok {
+		return
+	}
+
+	broadcasters.Delete(hostname)
+
+	b := v.(*broadcaster)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.forgotten = true
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
+
+	atomic.StoreInt32(&b.subscribers, 0)
+}
+
+func (b *broadcaster) publish(msg StreamMessage) {
+	if atomic.LoadInt32(&b.subscribers) == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+func (b *broadcaster) line(line string) {
+	if atomic.LoadInt32(&b.subscribers) == 0 {
+		return
+	}
+
+	b.publish(StreamMessage{
+		Time: time.Now(),
+		Type: StreamLine,
+		Line: strings.TrimRight(line, "\r\n"),
+	})
+}
+
+func (b *broadcaster) event(x events.Event) {
+	if atomic.LoadInt32(&b.subscribers) == 0 {
+		return
+	}
+
+	b.publish(StreamMessage{
+		Time:  time.Now(),
+		Type:  StreamEvent,
+		Event: &x,
+	})
+}
@@ -0,0 +1,82 @@
+package crestrontelnet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/byuoitav/common/v2/events"
+)
+
+func receive(t *testing.T, messages <-chan StreamMessage) StreamMessage {
+	t.Helper()
+
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for a message")
+	}
+
+	return StreamMessage{}
+}
+
+func TestBroadcaster(t *testing.T) {
+	const hostname = "ITB-1101-DMPS"
+	defer forgetBroadcaster(hostname)
+
+	b := broadcasterFor(hostname)
+
+	// nothing is published while nobody is listening
+	b.line("before anyone subscribed\r\n")
+
+	first, unsubscribeFirst := Subscribe(hostname)
+	second, unsubscribeSecond := Subscribe(hostname)
+	defer unsubscribeSecond()
+
+	b.line("~EVENT~ITB-1101-DMPS~volume~50~\r\n")
+	b.event(events.Event{Key: "volume", Value: "50"})
+
+	for _, messages := range []<-chan StreamMessage{first, second} {
+		if msg := receive(t, messages); msg.Type != StreamLine || msg.Line != "~EVENT~ITB-1101-DMPS~volume~50~" {
+			t.Errorf("got %+v, expected the line without its line ending", msg)
+		}
+
+		if msg := receive(t, messages); msg.Type != StreamEvent || msg.Event == nil || msg.Event.Value != "50" {
+			t.Errorf("got %+v, expected the event", msg)
+		}
+	}
+
+	unsubscribeFirst()
+	unsubscribeFirst()
+
+	b.line("after the first unsubscribed\r\n")
+
+	if msg := receive(t, second); msg.Line != "after the first unsubscribed" {
+		t.Errorf("got %+v, expected the line sent after the first unsubscribed", msg)
+	}
+
+	select {
+	case msg := <-first:
+		t.Errorf("got %+v after unsubscribing", msg)
+	default:
+	}
+}
+
+func TestSlowSubscriberDropsMessages(t *testing.T) {
+	const hostname = "ITB-1101-DMPS"
+	defer forgetBroadcaster(hostname)
+
+	messages, unsubscribe := Subscribe(hostname)
+	defer unsubscribe()
+
+	b := broadcasterFor(hostname)
+
+	// nobody is reading, so publishing has to drop messages instead of blocking the monitor
+	for i := 0; i < streamBuffer+10; i++ {
+		b.line("line\r\n")
+	}
+
+	if n := len(messages); n != streamBuffer {
+		t.Errorf("got %v buffered messages, expected %v", n, streamBuffer)
+	}
+}
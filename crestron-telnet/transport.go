@@ -0,0 +1,330 @@
+package crestrontelnet
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/byuoitav/common/log"
+	"github.com/byuoitav/crestron-telnet-microservice/inventory"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Conn is an open connection to a device's console, over whichever transport the device uses
+type Conn interface {
+	io.ReadWriteCloser
+
+	// SetReadDeadline sets the deadline for future Read calls, like net.Conn
+	SetReadDeadline(t time.Time) error
+}
+
+var (
+	defaultTransport = inventory.TransportTelnet
+	hostKeyCallback  = noKnownHosts
+)
+
+// noKnownHosts rejects every host key, until either known hosts or insecure mode are set
+func noKnownHosts(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	return fmt.Errorf("unable to verify ssh host key for %s: no known_hosts file has been set (CRESTRON_SSH_KNOWN_HOSTS)", hostname)
+}
+
+// SetDefaultTransport sets the transport used for devices that don't specify one
+func SetDefaultTransport(transport string) error {
+	switch transport {
+	case inventory.TransportTelnet, inventory.TransportSSH:
+		defaultTransport = transport
+		return nil
+	default:
+		return fmt.Errorf("invalid transport %q (expected telnet or ssh)", transport)
+	}
+}
+
+// SetSSHKnownHosts verifies ssh host keys against the known_hosts file at path.
+// If neither it nor SetSSHInsecureIgnoreHostKey is called, every ssh connection is refused.
+func SetSSHKnownHosts(path string) error {
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return fmt.Errorf("unable to load ssh known hosts: %s", err)
+	}
+
+	hostKeyCallback = callback
+	return nil
+}
+
+// SetSSHInsecureIgnoreHostKey accepts any ssh host key, which leaves ssh connections open to being intercepted
+func SetSSHInsecureIgnoreHostKey() {
+	hostKeyCallback = ssh.InsecureIgnoreHostKey()
+}
+
+// withTransportDefaults fills in the transport and port for dev if they aren't set
+func withTransportDefaults(dev inventory.Device) inventory.Device {
+	if len(dev.Transport) == 0 {
+		dev.Transport = defaultTransport
+	}
+
+	if len(dev.Port) == 0 || dev.Port == "0" {
+		switch dev.Transport {
+		case inventory.TransportSSH:
+			dev.Port = "22"
+		default:
+			dev.Port = "23"
+		}
+	}
+
+	return dev
+}
+
+// dial opens a connection to dev's console using its transport
+func dial(ctx context.Context, dev inventory.Device) (Conn, error) {
+	dialer := net.Dialer{
+		Timeout: 10 * time.Second,
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(dev.Address, dev.Port))
+	if err != nil {
+		return nil, fmt.Errorf("unable to open connection: %s", err)
+	}
+
+	switch dev.Transport {
+	case inventory.TransportTelnet:
+		return conn, nil
+	case inventory.TransportSSH:
+		sconn, err := dialSSH(ctx, dev, conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return sconn, nil
+	default:
+		conn.Close()
+		return nil, fmt.Errorf("unknown transport %q", dev.Transport)
+	}
+}
+
+// dialSSH logs in over conn and opens an interactive shell on the device's console
+func dialSSH(ctx context.Context, dev inventory.Device, conn net.Conn) (*sshConn, error) {
+	creds, err := credentialsFor(dev)
+	if err != nil {
+		return nil, &AuthError{Hostname: dev.Hostname, Reason: authNoCredentials, Message: err.Error()}
+	}
+
+	config := &ssh.ClientConfig{
+		User: creds.Username,
+		Auth: []ssh.AuthMethod{
+			ssh.Password(creds.Password),
+			ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+				answers := make([]string, len(questions))
+				for i := range questions {
+					if echos[i] {
+						answers[i] = creds.Username
+					} else {
+						answers[i] = creds.Password
+					}
+				}
+
+				return answers, nil
+			}),
+		},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         10 * time.Second,
+	}
+
+	// the handshake doesn't take a context, so bound it with a deadline instead
+	deadline := time.Now().Add(30 * time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	conn.SetDeadline(deadline)
+	stop := closeOnDone(ctx, conn)
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, conn.RemoteAddr().String(), config)
+
+	stop()
+	conn.SetDeadline(time.Time{})
+
+	switch {
+	case err != nil && strings.Contains(err.Error(), "unable to authenticate"):
+		return nil, &AuthError{Hostname: dev.Hostname, Reason: authBadLogin, Message: err.Error()}
+	case err != nil:
+		return nil, fmt.Errorf("unable to start ssh session: %s", err)
+	}
+
+	client := ssh.NewClient(c, chans, reqs)
+
+	session, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("unable to open ssh session: %s", err)
+	}
+
+	// the console behaves the same as it does over telnet when it has a terminal,
+	// but some firmware won't give one out so it isn't required
+	if err := session.RequestPty("vt100", 24, 200, ssh.TerminalModes{}); err != nil {
+		log.L.Debugf("unable to get a pty from %s: %s", dev.Hostname, err)
+	}
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("unable to open ssh stdin: %s", err)
+	}
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("unable to open ssh stdout: %s", err)
+	}
+
+	if err := session.Shell(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("unable to start ssh shell: %s", err)
+	}
+
+	s := &sshConn{
+		client:          client,
+		session:         session,
+		stdin:           stdin,
+		reads:           make(chan []byte),
+		deadlineChanged: make(chan struct{}, 1),
+		closed:          make(chan struct{}),
+	}
+
+	go s.pump(stdout)
+	return s, nil
+}
+
+// sshConn is a shell session on a device's console.
+//
+// The ssh client is always reading from the underlying connection, so a deadline on it would
+// kill the session even while nothing is waiting on a response. Instead, output is pumped
+// through a channel and the read deadline only applies while Read is waiting on it.
+type sshConn struct {
+	client  *ssh.Client
+	session *ssh.Session
+	stdin   io.Writer
+
+	reads   chan []byte
+	pending []byte
+	readErr error
+
+	mu              sync.Mutex
+	deadline        time.Time
+	deadlineChanged chan struct{}
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *sshConn) pump(stdout io.Reader) {
+	defer close(s.reads)
+
+	for {
+		b := make([]byte, 4096)
+		n, err := stdout.Read(b)
+		if n > 0 {
+			select {
+			case s.reads <- b[:n]:
+			case <-s.closed:
+				return
+			}
+		}
+
+		if err != nil {
+			s.readErr = err
+			return
+		}
+	}
+}
+
+func (s *sshConn) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		s.mu.Lock()
+		deadline := s.deadline
+		s.mu.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				return 0, timeoutError{}
+			}
+
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+
+		var (
+			b  []byte
+			ok = true
+		)
+
+		select {
+		case b, ok = <-s.reads:
+		case <-timeout:
+			return 0, timeoutError{}
+		case <-s.deadlineChanged:
+			// check the new deadline
+		case <-s.closed:
+			return 0, io.ErrClosedPipe
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+
+		if !ok {
+			// readErr is set before reads is closed
+			return 0, s.readErr
+		}
+
+		s.pending = b
+	}
+
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+func (s *sshConn) Write(p []byte) (int, error) {
+	return s.stdin.Write(p)
+}
+
+func (s *sshConn) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	s.deadline = t
+	s.mu.Unlock()
+
+	// wake up a blocked Read, like net.Conn does
+	select {
+	case s.deadlineChanged <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+func (s *sshConn) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		s.session.Close()
+		err = s.client.Close()
+	})
+
+	return err
+}
+
+// timeoutError is returned when a read deadline passes, and looks like a net.Conn timeout
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
@@ -0,0 +1,139 @@
+package eventparser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Marker is what every event line reported by a DMPS starts with
+const Marker = "~EVENT~"
+
+// fieldCount is the number of ~ separated fields in a well formed event, including the leading EVENT
+const fieldCount = 9
+
+var (
+	// ErrNotEvent is returned when a line doesn't contain an event at all
+	ErrNotEvent = errors.New("line does not contain " + Marker)
+
+	// ErrEmptyHostname is returned when the hostname field is blank
+	ErrEmptyHostname = errors.New("event has an empty hostname")
+
+	// ErrEmptyKey is returned when the key field is blank
+	ErrEmptyKey = errors.New("event has an empty key")
+)
+
+// FieldCountError is returned when an event doesn't have exactly nine fields
+type FieldCountError struct {
+	Got int
+}
+
+func (e *FieldCountError) Error() string {
+	return fmt.Sprintf("event has %v fields, expected %v", e.Got, fieldCount)
+}
+
+// TimestampError is set on an event when its timestamp field isn't a valid RFC3339 timestamp
+type TimestampError struct {
+	Timestamp string
+	Err       error
+}
+
+func (e *TimestampError) Error() string {
+	return fmt.Sprintf("invalid timestamp %q: %s", e.Timestamp, e.Err)
+}
+
+// Unwrap returns the underlying time parsing error
+func (e *TimestampError) Unwrap() error {
+	return e.Err
+}
+
+// DMPSEvent is a single event reported by a DMPS, in the form
+//
+//	~EVENT~HOSTNAME~RESERVED~TIMESTAMP~TAG~TAG~DEVICE~KEY~VALUE~
+type DMPSEvent struct {
+	// Hostname is the hostname of the processor that reported the event, e.g. ITB-1101-CP1
+	Hostname string
+
+	// Reserved is the third field, which isn't used for anything
+	Reserved string
+
+	// Timestamp is when the processor says the event happened. It is zero if the timestamp couldn't be parsed.
+	Timestamp time.Time
+
+	// TimestampErr is why the timestamp couldn't be parsed, if it couldn't be.
+	// The rest of the event is still good, so it isn't returned as an error from Parse.
+	TimestampErr error
+
+	// Tags are the two category tags, exactly as reported
+	Tags []string
+
+	// DeviceSuffix is the device within the room the event is about, e.g. D1
+	DeviceSuffix string
+
+	// Key and Value are exactly as reported, e.g. "Software Version" and "1.502.0004"
+	Key   string
+	Value string
+
+	// Raw is the event with surrounding whitespace and the leading and trailing ~ removed
+	Raw string
+}
+
+// Contains returns true if line has an event somewhere in it
+func Contains(line string) bool {
+	return strings.Contains(line, Marker)
+}
+
+// Parse parses the event in line. Anything before the first ~EVENT~ (such as a console prompt) is ignored.
+func Parse(line string) (DMPSEvent, error) {
+	var event DMPSEvent
+
+	index := strings.Index(line, Marker)
+	if index < 0 {
+		return event, ErrNotEvent
+	}
+
+	// trim off the leading and ending ~
+	raw := strings.TrimSpace(line[index:])
+	raw = strings.TrimPrefix(raw, "~")
+	raw = strings.TrimSuffix(raw, "~")
+
+	parts := strings.Split(raw, "~")
+	if len(parts) != fieldCount {
+		return event, &FieldCountError{Got: len(parts)}
+	}
+
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+
+	event.Raw = raw
+	event.Hostname = parts[1]
+	event.Reserved = parts[2]
+	event.Tags = []string{parts[4], parts[5]}
+	event.DeviceSuffix = parts[6]
+	event.Key = parts[7]
+	event.Value = parts[8]
+
+	if len(event.Hostname) == 0 {
+		return event, ErrEmptyHostname
+	}
+
+	if len(event.Key) == 0 {
+		return event, ErrEmptyKey
+	}
+
+	ts, err := time.Parse(time.RFC3339, parts[3])
+	if err != nil {
+		event.TimestampErr = &TimestampError{Timestamp: parts[3], Err: err}
+		return event, nil
+	}
+
+	event.Timestamp = ts
+	return event, nil
+}
+
+// Normalize converts a tag or key the way the event processor expects them, e.g. "Software Version" becomes "software-version"
+func Normalize(s string) string {
+	return strings.Replace(strings.ToLower(s), " ", "-", -1)
+}
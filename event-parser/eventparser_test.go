@@ -0,0 +1,174 @@
+package eventparser
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var parseTests = []struct {
+	name  string
+	line  string
+	event DMPSEvent
+	err   error
+}{
+	{
+		name: "SoftwareVersion",
+		line: "~EVENT~ITB-1101-CP1~0~2020-02-21T09:15:03-07:00~Auto Generated~Hardware Info~D1~Software Version~1.502.0004~\r\n",
+		event: DMPSEvent{
+			Hostname:     "ITB-1101-CP1",
+			Reserved:     "0",
+			Timestamp:    time.Date(2020, 2, 21, 9, 15, 3, 0, time.FixedZone("", -7*60*60)),
+			Tags:         []string{"Auto Generated", "Hardware Info"},
+			DeviceSuffix: "D1",
+			Key:          "Software Version",
+			Value:        "1.502.0004",
+			Raw:          "EVENT~ITB-1101-CP1~0~2020-02-21T09:15:03-07:00~Auto Generated~Hardware Info~D1~Software Version~1.502.0004",
+		},
+	},
+	{
+		name: "PromptBeforeEvent",
+		line: "DMPS3-4K-150-C>~EVENT~JFSB-B190-CP1~0~2020-02-21T16:00:00Z~Auto Generated~Detail State~MIC1~battery-charge-hours-minutes~3:45~\n",
+		event: DMPSEvent{
+			Hostname:     "JFSB-B190-CP1",
+			Reserved:     "0",
+			Timestamp:    time.Date(2020, 2, 21, 16, 0, 0, 0, time.UTC),
+			Tags:         []string{"Auto Generated", "Detail State"},
+			DeviceSuffix: "MIC1",
+			Key:          "battery-charge-hours-minutes",
+			Value:        "3:45",
+			Raw:          "EVENT~JFSB-B190-CP1~0~2020-02-21T16:00:00Z~Auto Generated~Detail State~MIC1~battery-charge-hours-minutes~3:45",
+		},
+	},
+	{
+		name: "PaddedFields",
+		line: "  ~EVENT~ BRMB-230-CP1 ~0~ 2020-02-21T16:00:00Z ~Auto Generated~Core State~ D1 ~ responsive ~ Ok ~  ",
+		event: DMPSEvent{
+			Hostname:     "BRMB-230-CP1",
+			Reserved:     "0",
+			Timestamp:    time.Date(2020, 2, 21, 16, 0, 0, 0, time.UTC),
+			Tags:         []string{"Auto Generated", "Core State"},
+			DeviceSuffix: "D1",
+			Key:          "responsive",
+			Value:        "Ok",
+			Raw:          "EVENT~ BRMB-230-CP1 ~0~ 2020-02-21T16:00:00Z ~Auto Generated~Core State~ D1 ~ responsive ~ Ok ",
+		},
+	},
+	{
+		name: "EmptyValue",
+		line: "~EVENT~ITB-1101-CP1~0~2020-02-21T16:00:00Z~Auto Generated~Core State~D1~IP Address~~",
+		event: DMPSEvent{
+			Hostname:     "ITB-1101-CP1",
+			Reserved:     "0",
+			Timestamp:    time.Date(2020, 2, 21, 16, 0, 0, 0, time.UTC),
+			Tags:         []string{"Auto Generated", "Core State"},
+			DeviceSuffix: "D1",
+			Key:          "IP Address",
+			Value:        "",
+			Raw:          "EVENT~ITB-1101-CP1~0~2020-02-21T16:00:00Z~Auto Generated~Core State~D1~IP Address~",
+		},
+	},
+	{
+		name: "NotAnEvent",
+		line: "DMPS3-4K-150-C>\r\n",
+		err:  ErrNotEvent,
+	},
+	{
+		name: "TooFewFields",
+		line: "~EVENT~ITB-1101-CP1~0~2020-02-21T16:00:00Z~Auto Generated~D1~responsive~Ok~",
+		err:  &FieldCountError{Got: 8},
+	},
+	{
+		name: "TooManyFields",
+		line: "~EVENT~ITB-1101-CP1~0~2020-02-21T16:00:00Z~Auto Generated~Core State~D1~responsive~Ok~extra~",
+		err:  &FieldCountError{Got: 10},
+	},
+	{
+		name: "Truncated",
+		line: "~EVENT~ITB-1101-CP1~0~2020-02-",
+		err:  &FieldCountError{Got: 4},
+	},
+	{
+		name: "EmptyHostname",
+		line: "~EVENT~~0~2020-02-21T16:00:00Z~Auto Generated~Core State~D1~responsive~Ok~",
+		err:  ErrEmptyHostname,
+	},
+	{
+		name: "EmptyKey",
+		line: "~EVENT~ITB-1101-CP1~0~2020-02-21T16:00:00Z~Auto Generated~Core State~D1~~Ok~",
+		err:  ErrEmptyKey,
+	},
+	{
+		name: "BadTimestamp",
+		line: "~EVENT~ITB-1101-CP1~0~02/21/2020 16:00:00~Auto Generated~Core State~D1~responsive~Ok~",
+		event: DMPSEvent{
+			Hostname:     "ITB-1101-CP1",
+			Reserved:     "0",
+			TimestampErr: &TimestampError{},
+			Tags:         []string{"Auto Generated", "Core State"},
+			DeviceSuffix: "D1",
+			Key:          "responsive",
+			Value:        "Ok",
+			Raw:          "EVENT~ITB-1101-CP1~0~02/21/2020 16:00:00~Auto Generated~Core State~D1~responsive~Ok",
+		},
+	},
+}
+
+func TestParse(t *testing.T) {
+	for _, tt := range parseTests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := Parse(tt.line)
+
+			switch want := tt.err.(type) {
+			case nil:
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+
+				if !event.Timestamp.Equal(tt.event.Timestamp) {
+					t.Fatalf("got timestamp %s, expected %s", event.Timestamp, tt.event.Timestamp)
+				}
+
+				// a bad timestamp still gives the rest of the event
+				if tt.event.TimestampErr != nil {
+					var got *TimestampError
+					if !errors.As(event.TimestampErr, &got) {
+						t.Fatalf("got timestamp error %v, expected a *TimestampError", event.TimestampErr)
+					}
+				}
+
+				event.Timestamp = tt.event.Timestamp
+				event.TimestampErr = tt.event.TimestampErr
+				if !reflect.DeepEqual(event, tt.event) {
+					t.Fatalf("got %+v, expected %+v", event, tt.event)
+				}
+			case *FieldCountError:
+				var got *FieldCountError
+				if !errors.As(err, &got) || got.Got != want.Got {
+					t.Fatalf("got error %v, expected %v", err, want)
+				}
+			default:
+				if err != tt.err {
+					t.Fatalf("got error %v, expected %v", err, tt.err)
+				}
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := map[string]string{
+		"Software Version": "software-version",
+		"Auto Generated":   "auto-generated",
+		"responsive":       "responsive",
+		"IP Address":       "ip-address",
+		"":                 "",
+	}
+
+	for in, want := range tests {
+		if got := Normalize(in); got != want {
+			t.Errorf("Normalize(%q) = %q, expected %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+//go:build go1.18
+// +build go1.18
+
+package eventparser
+
+import (
+	"strings"
+	"testing"
+)
+
+func FuzzParse(f *testing.F) {
+	for _, tt := range parseTests {
+		f.Add(tt.line)
+	}
+
+	f.Fuzz(func(t *testing.T, line string) {
+		event, err := Parse(line)
+		if err != nil {
+			return
+		}
+
+		if !Contains(line) {
+			t.Fatalf("parsed an event from %q, which doesn't contain %s", line, Marker)
+		}
+
+		if len(event.Hostname) == 0 || len(event.Key) == 0 {
+			t.Fatalf("parsed an event with an empty hostname or key from %q", line)
+		}
+
+		if len(event.Tags) != 2 {
+			t.Fatalf("parsed %v tags from %q", len(event.Tags), line)
+		}
+
+		if !strings.Contains(line, event.Raw) {
+			t.Fatalf("raw event %q isn't part of %q", event.Raw, line)
+		}
+
+		// parsing the raw event again should give the same result
+		again, err := Parse("~" + event.Raw + "~")
+		if err != nil {
+			t.Fatalf("unable to reparse %q: %s", event.Raw, err)
+		}
+
+		if again.Raw != event.Raw || again.Hostname != event.Hostname || again.Key != event.Key || again.Value != event.Value {
+			t.Fatalf("reparsing %q gave %+v, expected %+v", event.Raw, again, event)
+		}
+	})
+}
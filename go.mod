@@ -8,8 +8,10 @@ require (
 	github.com/fatih/color v1.9.0 // indirect
 	github.com/labstack/echo v3.3.10+incompatible
 	github.com/labstack/gommon v0.3.0 // indirect
+	github.com/prometheus/client_golang v1.4.1
 	github.com/sevenNt/echo-pprof v0.1.0 // indirect
 	github.com/valyala/fasttemplate v1.1.0 // indirect
 	go.uber.org/zap v1.13.0 // indirect
-	golang.org/x/crypto v0.0.0-20200210222208-86ce3cb69678 // indirect
+	golang.org/x/crypto v0.0.0-20200210222208-86ce3cb69678
+	gopkg.in/yaml.v2 v2.2.8
 )
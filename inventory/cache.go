@@ -0,0 +1,262 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/byuoitav/common/log"
+)
+
+const (
+	// DMPSList is the name of the dmps list in a Status
+	DMPSList = "dmps"
+
+	// OtherCrestronList is the name of the other crestron list in a Status
+	OtherCrestronList = "other-crestron"
+)
+
+// StaleError is returned by CachedSource when the underlying source failed and the
+// last-known-good list is being returned instead
+type StaleError struct {
+	Err   error
+	Since time.Time
+}
+
+func (e *StaleError) Error() string {
+	return fmt.Sprintf("%s (using cached list from %s)", e.Err, e.Since.Format(time.RFC3339))
+}
+
+// Unwrap returns the error from the underlying source
+func (e *StaleError) Unwrap() error {
+	return e.Err
+}
+
+// ListStatus is the health of a single device list
+type ListStatus struct {
+	Degraded    bool      `json:"degraded"`
+	LastSuccess time.Time `json:"last-success,omitempty"`
+	LastError   string    `json:"last-error,omitempty"`
+	ErrorSince  time.Time `json:"error-since,omitempty"`
+	Devices     int       `json:"devices"`
+
+	// Rejected are the devices the source left out of the list because their settings are invalid
+	Rejected []RejectedDevice `json:"rejected,omitempty"`
+}
+
+// CachedSource wraps a DeviceSource, remembering the last list it successfully returned.
+// Successful lists are persisted to disk so that the service can start even if the
+// underlying source is down at boot. When the source fails, the last-known-good list is
+// returned along with a *StaleError.
+type CachedSource struct {
+	Source DeviceSource
+	Path   string
+
+	// OnChange, if set, is called whenever a list becomes degraded or recovers
+	OnChange func(list string, status ListStatus)
+
+	mu     sync.Mutex
+	cache  deviceCache
+	status map[string]ListStatus
+}
+
+type deviceCache struct {
+	// Updated is when either list was last updated. Caches written before each list
+	// had its own time only have this, so it is used for lists that don't.
+	Updated time.Time `json:"updated"`
+
+	DMPS        []Device  `json:"dmps"`
+	DMPSUpdated time.Time `json:"dmpsUpdated"`
+
+	OtherCrestron        []Device  `json:"otherCrestron"`
+	OtherCrestronUpdated time.Time `json:"otherCrestronUpdated"`
+}
+
+// NewCachedSource wraps src, loading any previously cached lists from path
+func NewCachedSource(src DeviceSource, path string) *CachedSource {
+	c := &CachedSource{
+		Source: src,
+		Path:   path,
+		status: make(map[string]ListStatus),
+	}
+
+	b, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		log.L.Infof("No device cache found at %s", path)
+	case err != nil:
+		log.L.Warnf("unable to read device cache: %s", err)
+	default:
+		if err := json.Unmarshal(b, &c.cache); err != nil {
+			log.L.Warnf("unable to parse device cache %s: %s", path, err)
+			c.cache = deviceCache{}
+			break
+		}
+
+		log.L.Infof("Loaded device cache from %s (%v dmps, %v other crestron, updated %s)", path, len(c.cache.DMPS), len(c.cache.OtherCrestron), c.cache.Updated.Format(time.RFC3339))
+	}
+
+	return c
+}
+
+// GetDMPSList returns the dmps list from the underlying source, or the cached list if it fails
+func (c *CachedSource) GetDMPSList() ([]Device, error) {
+	list, err := c.Source.GetDMPSList()
+	return c.update(DMPSList, list, err)
+}
+
+// GetOtherCrestronList returns the other crestron list from the underlying source, or the cached list if it fails
+func (c *CachedSource) GetOtherCrestronList() ([]Device, error) {
+	list, err := c.Source.GetOtherCrestronList()
+	return c.update(OtherCrestronList, list, err)
+}
+
+// Watch passes through to the underlying source's Watch, so that caching a source doesn't stop
+// its changes from being picked up right away. If the source can't be watched, the returned
+// channel is nil and never fires.
+func (c *CachedSource) Watch(ctx context.Context) <-chan struct{} {
+	if w, ok := c.Source.(Watcher); ok {
+		return w.Watch(ctx)
+	}
+
+	return nil
+}
+
+// Status returns the health of each list that has been requested
+func (c *CachedSource) Status() map[string]ListStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	status := make(map[string]ListStatus, len(c.status))
+	for k, v := range c.status {
+		status[k] = v
+	}
+
+	return status
+}
+
+// Degraded returns true if any list is currently being served from the cache
+func (c *CachedSource) Degraded() bool {
+	for _, status := range c.Status() {
+		if status.Degraded {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *CachedSource) update(name string, list []Device, err error) ([]Device, error) {
+	c.mu.Lock()
+
+	prev := c.status[name]
+	status := prev
+
+	if err == nil {
+		status.Degraded = false
+		status.LastSuccess = time.Now()
+		status.LastError = ""
+		status.ErrorSince = time.Time{}
+		status.Devices = len(list)
+		status.Rejected = nil
+
+		if r, ok := c.Source.(Rejecter); ok {
+			status.Rejected = r.Rejected(name)
+		}
+
+		c.setCached(name, list, status.LastSuccess)
+		c.cache.Updated = status.LastSuccess
+
+		if err := c.persist(); err != nil {
+			log.L.Warnf("unable to write device cache: %s", err)
+		}
+	} else {
+		if !prev.Degraded {
+			status.ErrorSince = time.Now()
+		}
+
+		status.Degraded = true
+		status.LastError = err.Error()
+
+		cached, updated := c.cached(name)
+		if cached != nil {
+			list = copyDevices(cached)
+			status.Devices = len(list)
+			err = &StaleError{Err: err, Since: updated}
+		}
+	}
+
+	c.status[name] = status
+	c.mu.Unlock()
+
+	if prev.Degraded != status.Degraded && c.OnChange != nil {
+		c.OnChange(name, status)
+	}
+
+	return list, err
+}
+
+// cached returns the cached list for name, and when it was last updated
+func (c *CachedSource) cached(name string) ([]Device, time.Time) {
+	var list []Device
+	var updated time.Time
+
+	switch name {
+	case DMPSList:
+		list, updated = c.cache.DMPS, c.cache.DMPSUpdated
+	case OtherCrestronList:
+		list, updated = c.cache.OtherCrestron, c.cache.OtherCrestronUpdated
+	}
+
+	if updated.IsZero() {
+		updated = c.cache.Updated
+	}
+
+	return list, updated
+}
+
+func (c *CachedSource) setCached(name string, list []Device, updated time.Time) {
+	if list == nil {
+		list = []Device{}
+	}
+
+	switch name {
+	case DMPSList:
+		c.cache.DMPS = copyDevices(list)
+		c.cache.DMPSUpdated = updated
+	case OtherCrestronList:
+		c.cache.OtherCrestron = copyDevices(list)
+		c.cache.OtherCrestronUpdated = updated
+	}
+}
+
+// persist writes the cache to a temp file and renames it into place so a crash never leaves a partial cache
+func (c *CachedSource) persist() error {
+	b, err := json.MarshalIndent(c.cache, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(c.Path), filepath.Base(c.Path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), c.Path)
+}
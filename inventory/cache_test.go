@@ -0,0 +1,172 @@
+package inventory
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/byuoitav/common/structs"
+)
+
+// fakeSource returns its lists, or err if it is set
+type fakeSource struct {
+	dmps          []Device
+	otherCrestron []Device
+	err           error
+}
+
+func (f *fakeSource) GetDMPSList() ([]Device, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	return f.dmps, nil
+}
+
+func (f *fakeSource) GetOtherCrestronList() ([]Device, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	return f.otherCrestron, nil
+}
+
+func tempCachePath(t *testing.T) (string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return filepath.Join(dir, "cache.json"), func() { os.RemoveAll(dir) }
+}
+
+func device(hostname string) Device {
+	return Device{DMPS: structs.DMPS{Hostname: hostname, Address: "10.5.34.10"}}
+}
+
+func TestCachedSourceFallsBack(t *testing.T) {
+	path, cleanup := tempCachePath(t)
+	defer cleanup()
+
+	src := &fakeSource{
+		dmps: []Device{device("ITB-1101-CP1")},
+	}
+
+	var changes []ListStatus
+	cache := NewCachedSource(src, path)
+	cache.OnChange = func(list string, status ListStatus) {
+		changes = append(changes, status)
+	}
+
+	if _, err := cache.GetDMPSList(); err != nil {
+		t.Fatal(err)
+	}
+
+	src.err = errors.New("couch is down")
+
+	list, err := cache.GetDMPSList()
+
+	var stale *StaleError
+	if !errors.As(err, &stale) || !errors.Is(err, src.err) {
+		t.Fatalf("got error %v, expected a StaleError wrapping %v", err, src.err)
+	}
+
+	if len(list) != 1 || list[0].Hostname != "ITB-1101-CP1" {
+		t.Errorf("got %+v, expected the cached list", list)
+	}
+
+	if status := cache.Status()[DMPSList]; !status.Degraded || status.LastError != "couch is down" || !cache.Degraded() {
+		t.Errorf("got status %+v, expected degraded", status)
+	}
+
+	// a list that has never been fetched has nothing to fall back on
+	if list, err := cache.GetOtherCrestronList(); list != nil || errors.As(err, &stale) {
+		t.Errorf("got %+v and %v for a list that was never cached, expected no list and a plain error", list, err)
+	}
+
+	src.err = nil
+
+	if _, err := cache.GetDMPSList(); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := cache.Status()[DMPSList]; status.Degraded {
+		t.Errorf("got status %+v after the source recovered, expected not degraded", status)
+	}
+
+	if len(changes) != 3 || !changes[0].Degraded || changes[2].Degraded {
+		t.Errorf("got changes %+v, expected dmps degraded, other crestron degraded, then dmps recovered", changes)
+	}
+}
+
+func TestCachedSourcePersists(t *testing.T) {
+	path, cleanup := tempCachePath(t)
+	defer cleanup()
+
+	src := &fakeSource{
+		dmps:          []Device{device("ITB-1101-CP1")},
+		otherCrestron: []Device{device("ITB-1108-CP1")},
+	}
+
+	cache := NewCachedSource(src, path)
+	cache.GetDMPSList()
+	cache.GetOtherCrestronList()
+
+	// the service restarts while the source is down
+	restarted := NewCachedSource(&fakeSource{err: errors.New("couch is down")}, path)
+
+	dmps, err := restarted.GetDMPSList()
+	if len(dmps) != 1 || dmps[0].Hostname != "ITB-1101-CP1" {
+		t.Errorf("got dmps %+v (%v) after restarting, expected the cached list", dmps, err)
+	}
+
+	other, err := restarted.GetOtherCrestronList()
+	if len(other) != 1 || other[0].Hostname != "ITB-1108-CP1" {
+		t.Errorf("got other crestron %+v (%v) after restarting, expected the cached list", other, err)
+	}
+
+	// a corrupt cache is ignored instead of stopping the service from starting
+	if err := ioutil.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if list, _ := NewCachedSource(&fakeSource{err: errors.New("couch is down")}, path).GetDMPSList(); list != nil {
+		t.Errorf("got %+v from a corrupt cache, expected nothing", list)
+	}
+}
+
+func TestStaleErrorSinceIsPerList(t *testing.T) {
+	path, cleanup := tempCachePath(t)
+	defer cleanup()
+
+	src := &fakeSource{
+		dmps:          []Device{device("ITB-1101-CP1")},
+		otherCrestron: []Device{device("ITB-1108-CP1")},
+	}
+
+	cache := NewCachedSource(src, path)
+	cache.GetDMPSList()
+	dmpsUpdated := cache.Status()[DMPSList].LastSuccess
+
+	time.Sleep(10 * time.Millisecond)
+	cache.GetOtherCrestronList()
+
+	src.err = errors.New("couch is down")
+
+	// updating the other crestron list doesn't make the dmps list any fresher
+	var stale *StaleError
+	if _, err := cache.GetDMPSList(); !errors.As(err, &stale) || !stale.Since.Equal(dmpsUpdated) {
+		t.Errorf("got %v, expected a StaleError since %v", err, dmpsUpdated.Format(time.RFC3339Nano))
+	}
+
+	// and it is still right after restarting
+	restarted := NewCachedSource(src, path)
+	if _, err := restarted.GetDMPSList(); !errors.As(err, &stale) || !stale.Since.Equal(dmpsUpdated) {
+		t.Errorf("got %v after restarting, expected a StaleError since %v", err, dmpsUpdated.Format(time.RFC3339Nano))
+	}
+}
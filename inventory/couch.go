@@ -0,0 +1,91 @@
+package inventory
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/byuoitav/common/db/couch"
+	"github.com/byuoitav/common/log"
+)
+
+// CouchSource pulls device lists from the DMPSList database in couch. Besides the usual hostname,
+// address, port, and commandToQuery, each device in a list can have the same settings as in a device
+// file (naming, transport, credentials, expectedResponse, forbiddenResponses, and retry), e.g.
+//
+//	{
+//	  "_id": "CrstCustom",
+//	  "list": [
+//	    {
+//	      "hostname": "ITB-1110-CP1",
+//	      "address": "10.5.34.14",
+//	      "transport": "ssh",
+//	      "credentials": "secured-processors",
+//	      "retry": {"initialDelay": "30s", "maxAttempts": 5}
+//	    }
+//	  ]
+//	}
+//
+// A device with invalid settings is left out of its list (and logged) rather than failing the whole list.
+type CouchSource struct {
+	Address  string
+	Username string
+	Password string
+
+	mu       sync.Mutex
+	rejected map[string][]RejectedDevice
+}
+
+// couchDeviceList is a device list document in the DMPSList database
+type couchDeviceList struct {
+	List []fileDevice `json:"list"`
+}
+
+// GetDMPSList gets the dmps_list document from couch
+func (c *CouchSource) GetDMPSList() ([]Device, error) {
+	return c.getList(DMPSList, "dmps_list")
+}
+
+// GetOtherCrestronList gets the CrstCustom document from couch
+func (c *CouchSource) GetOtherCrestronList() ([]Device, error) {
+	return c.getList(OtherCrestronList, "CrstCustom")
+}
+
+// Rejected returns the devices that were left out of list the last time it was fetched
+func (c *CouchSource) Rejected(list string) []RejectedDevice {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]RejectedDevice(nil), c.rejected[list]...)
+}
+
+func (c *CouchSource) getList(list, id string) ([]Device, error) {
+	var doc couchDeviceList
+
+	err := couch.NewDB(c.Address, c.Username, c.Password).MakeRequest("GET", fmt.Sprintf("%v/%v", couch.DMPSLIST, id), "", nil, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %s", id, err)
+	}
+
+	valid := make([]fileDevice, 0, len(doc.List))
+	var rejected []RejectedDevice
+
+	for _, dev := range doc.List {
+		if err := validateDevice(dev); err != nil {
+			log.L.Warnf("leaving %s out of %s: %s", dev.Hostname, id, err)
+			rejected = append(rejected, RejectedDevice{Hostname: dev.Hostname, Reason: err.Error()})
+			continue
+		}
+
+		valid = append(valid, dev)
+	}
+
+	c.mu.Lock()
+	if c.rejected == nil {
+		c.rejected = make(map[string][]RejectedDevice)
+	}
+
+	c.rejected[list] = rejected
+	c.mu.Unlock()
+
+	return toDevices(valid), nil
+}
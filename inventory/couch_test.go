@@ -0,0 +1,135 @@
+package inventory
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fakeCouch(t *testing.T, docs map[string]string) (*CouchSource, func()) {
+	t.Helper()
+
+	// don't wait for replication to finish before making requests
+	os.Setenv("STOP_REPLICATION", "true")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		doc, ok := docs[r.URL.Path]
+		if !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": "not_found", "reason": "missing"}`))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(doc))
+	}))
+
+	src := &CouchSource{
+		Address:  server.URL,
+		Username: "user",
+		Password: "pass",
+	}
+
+	return src, server.Close
+}
+
+func TestCouchSource(t *testing.T) {
+	src, cleanup := fakeCouch(t, map[string]string{
+		"/dmps/dmps_list": `{"_id": "dmps_list", "list": [{"hostname": "ITB-1101-CP1", "address": "10.5.34.10"}]}`,
+		"/dmps/CrstCustom": `{"_id": "CrstCustom", "list": [{
+			"hostname": "ITB-1110-CP1",
+			"address": "10.5.34.14",
+			"commandToQuery": "VERSION",
+			"transport": "ssh",
+			"credentials": "secured-processors",
+			"expectedResponse": "Cntrl Eng",
+			"forbiddenResponses": ["(?i)error"],
+			"naming": {"roomID": "ITB-1110A"},
+			"retry": {"initialDelay": "30s", "maxAttempts": 0}
+		}]}`,
+	})
+	defer cleanup()
+
+	dmps, err := src.GetDMPSList()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dmps) != 1 || dmps[0].Hostname != "ITB-1101-CP1" || dmps[0].Address != "10.5.34.10" {
+		t.Errorf("got dmps %+v, expected ITB-1101-CP1", dmps)
+	}
+
+	other, err := src.GetOtherCrestronList()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(other) != 1 {
+		t.Fatalf("got other crestron %+v, expected ITB-1110-CP1", other)
+	}
+
+	dev := other[0]
+	switch {
+	case dev.CommandToQuery != "VERSION":
+		t.Errorf("got command %q, expected VERSION", dev.CommandToQuery)
+	case dev.Transport != TransportSSH || dev.Credentials != "secured-processors":
+		t.Errorf("got transport %q and credentials %q, expected ssh and secured-processors", dev.Transport, dev.Credentials)
+	case dev.ExpectedResponse != "Cntrl Eng" || len(dev.ForbiddenResponses) != 1:
+		t.Errorf("got expected response %q and forbidden responses %q", dev.ExpectedResponse, dev.ForbiddenResponses)
+	case dev.Naming == nil || dev.Naming.RoomID != "ITB-1110A":
+		t.Errorf("got naming %+v, expected room ITB-1110A", dev.Naming)
+	case dev.Retry == nil || dev.Retry.InitialDelay != 30*time.Second || dev.Retry.MaxAttempts == nil || *dev.Retry.MaxAttempts != 0:
+		t.Errorf("got retry policy %+v, expected a 30s initial delay and 0 max attempts", dev.Retry)
+	}
+}
+
+func TestCouchSourceInvalidDevice(t *testing.T) {
+	src, cleanup := fakeCouch(t, map[string]string{
+		"/dmps/dmps_list": `{"_id": "dmps_list", "list": [
+			{"hostname": "ITB-1101-CP1", "address": "10.5.34.10"},
+			{"hostname": "ITB-1102-CP1", "address": "10.5.34.11", "transport": "rlogin"},
+			{"hostname": "ITB-1103-CP1", "address": "10.5.34.12", "forbiddenResponses": ["(error"]},
+			{"hostname": "ITB-1104-CP1", "address": "10.5.34.13", "transport": "ssh"}
+		]}`,
+	})
+	defer cleanup()
+
+	path, remove := tempCachePath(t)
+	defer remove()
+
+	cache := NewCachedSource(src, path)
+
+	dmps, err := cache.GetDMPSList()
+	if err != nil {
+		t.Fatalf("got error %v, expected the valid devices", err)
+	}
+
+	if len(dmps) != 2 || dmps[0].Hostname != "ITB-1101-CP1" || dmps[1].Hostname != "ITB-1104-CP1" {
+		t.Errorf("got devices %+v, expected ITB-1101-CP1 and ITB-1104-CP1", dmps)
+	}
+
+	status := cache.Status()[DMPSList]
+	if status.Degraded || status.Devices != 2 {
+		t.Errorf("got status %+v, expected 2 healthy devices", status)
+	}
+
+	if len(status.Rejected) != 2 {
+		t.Fatalf("got rejected %+v, expected ITB-1102-CP1 and ITB-1103-CP1", status.Rejected)
+	}
+
+	if r := status.Rejected[0]; r.Hostname != "ITB-1102-CP1" || !strings.Contains(r.Reason, `invalid transport "rlogin"`) {
+		t.Errorf("got %+v, expected ITB-1102-CP1 to be rejected for its transport", r)
+	}
+
+	if r := status.Rejected[1]; r.Hostname != "ITB-1103-CP1" || !strings.Contains(r.Reason, "invalid response pattern") {
+		t.Errorf("got %+v, expected ITB-1103-CP1 to be rejected for its response pattern", r)
+	}
+
+	if _, err := src.GetOtherCrestronList(); err == nil {
+		t.Errorf("got no error for a missing document")
+	}
+}
@@ -0,0 +1,280 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/byuoitav/common/log"
+	"github.com/byuoitav/common/structs"
+	"github.com/byuoitav/crestron-telnet-microservice/naming"
+	"gopkg.in/yaml.v2"
+)
+
+// FileSource reads device lists from a local YAML or JSON file. The file is re-read on every call,
+// so edits are picked up on the next refresh, and Watch can be used to pick them up immediately.
+//
+// The file looks like:
+//
+//	dmps:
+//	  - hostname: ITB-1101-CP1
+//	    address: 10.5.34.10
+//	otherCrestron:
+//	  - hostname: ITB-1108-CP1
+//	    address: 10.5.34.12
+//	    port: "41795"
+//	    commandToQuery: VERSION
+//	    expectedResponse: 'Cntrl Eng \[v'
+//	    forbiddenResponses:
+//	      - '(?i)error'
+//	    naming:
+//	      roomID: ITB-1108A
+//	  - hostname: ITB-1110-CP1
+//	    address: 10.5.34.14
+//	    transport: ssh
+//	    credentials: secured-processors
+//	    retry:
+//	      initialDelay: 30s
+//	      maxDelay: 10m
+//	      maxAttempts: 5
+type FileSource struct {
+	Path string
+
+	// PollInterval is how often Watch checks the file for changes
+	PollInterval time.Duration
+}
+
+// fileDevice is a device as it is written in a device file, or in a couch device list
+type fileDevice struct {
+	Hostname       string `json:"hostname" yaml:"hostname"`
+	Address        string `json:"address" yaml:"address"`
+	CommandToQuery string `json:"commandToQuery,omitempty" yaml:"commandToQuery,omitempty"`
+	Port           string `json:"port,omitempty" yaml:"port,omitempty"`
+
+	Naming      *naming.Override `json:"naming,omitempty" yaml:"naming,omitempty"`
+	Transport   string           `json:"transport,omitempty" yaml:"transport,omitempty"`
+	Credentials string           `json:"credentials,omitempty" yaml:"credentials,omitempty"`
+
+	ExpectedResponse   string   `json:"expectedResponse,omitempty" yaml:"expectedResponse,omitempty"`
+	ForbiddenResponses []string `json:"forbiddenResponses,omitempty" yaml:"forbiddenResponses,omitempty"`
+
+	Retry *fileRetryPolicy `json:"retry,omitempty" yaml:"retry,omitempty"`
+}
+
+// fileRetryPolicy is a RetryPolicy with its delays written as durations, e.g. 30s or 5m
+type fileRetryPolicy struct {
+	InitialDelay string   `json:"initialDelay,omitempty" yaml:"initialDelay,omitempty"`
+	MaxDelay     string   `json:"maxDelay,omitempty" yaml:"maxDelay,omitempty"`
+	Multiplier   float64  `json:"multiplier,omitempty" yaml:"multiplier,omitempty"`
+	Jitter       *float64 `json:"jitter,omitempty" yaml:"jitter,omitempty"`
+	MaxAttempts  *int     `json:"maxAttempts,omitempty" yaml:"maxAttempts,omitempty"`
+}
+
+type fileInventory struct {
+	DMPS          []fileDevice `json:"dmps" yaml:"dmps"`
+	OtherCrestron []fileDevice `json:"otherCrestron" yaml:"otherCrestron"`
+}
+
+// NewFileSource returns a FileSource for path, making sure the file can be read and parsed
+func NewFileSource(path string) (*FileSource, error) {
+	f := &FileSource{
+		Path:         path,
+		PollInterval: 10 * time.Second,
+	}
+
+	if _, err := f.read(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// GetDMPSList returns the dmps section of the file
+func (f *FileSource) GetDMPSList() ([]Device, error) {
+	inv, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return toDevices(inv.DMPS), nil
+}
+
+// GetOtherCrestronList returns the otherCrestron section of the file
+func (f *FileSource) GetOtherCrestronList() ([]Device, error) {
+	inv, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return toDevices(inv.OtherCrestron), nil
+}
+
+// Watch polls the file's modification time and signals whenever it changes
+func (f *FileSource) Watch(ctx context.Context) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+
+	go func() {
+		defer close(changes)
+
+		var last time.Time
+		if info, err := os.Stat(f.Path); err == nil {
+			last = info.ModTime()
+		}
+
+		ticker := time.NewTicker(f.PollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			info, err := os.Stat(f.Path)
+			if err != nil {
+				log.L.Warnf("unable to stat device file %s: %s", f.Path, err)
+				continue
+			}
+
+			if info.ModTime().Equal(last) {
+				continue
+			}
+
+			last = info.ModTime()
+			log.L.Infof("Device file %s changed", f.Path)
+
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return changes
+}
+
+func (f *FileSource) read() (fileInventory, error) {
+	var inv fileInventory
+
+	b, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		return inv, fmt.Errorf("unable to read device file: %s", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(f.Path)) {
+	case ".json":
+		err = json.Unmarshal(b, &inv)
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(b, &inv)
+	default:
+		return inv, fmt.Errorf("unable to read device file: unknown extension %q (expected .json, .yaml, or .yml)", filepath.Ext(f.Path))
+	}
+
+	if err != nil {
+		return inv, fmt.Errorf("unable to parse device file %s: %s", f.Path, err)
+	}
+
+	for _, list := range [][]fileDevice{inv.DMPS, inv.OtherCrestron} {
+		if err := validateDevices(list); err != nil {
+			return inv, fmt.Errorf("invalid device file %s: %s", f.Path, err)
+		}
+	}
+
+	return inv, nil
+}
+
+// validateDevices makes sure every device's transport, response patterns, and retry policy are valid
+func validateDevices(list []fileDevice) error {
+	for _, dev := range list {
+		if err := validateDevice(dev); err != nil {
+			return fmt.Errorf("%s: %s", dev.Hostname, err)
+		}
+	}
+
+	return nil
+}
+
+// validateDevice makes sure dev's transport, response patterns, and retry policy are valid
+func validateDevice(dev fileDevice) error {
+	switch dev.Transport {
+	case "", TransportTelnet, TransportSSH:
+	default:
+		return fmt.Errorf("invalid transport %q (expected telnet or ssh)", dev.Transport)
+	}
+
+	for _, pattern := range append([]string{dev.ExpectedResponse}, dev.ForbiddenResponses...) {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid response pattern: %s", err)
+		}
+	}
+
+	if _, err := dev.Retry.policy(); err != nil {
+		return fmt.Errorf("invalid retry policy: %s", err)
+	}
+
+	return nil
+}
+
+func toDevices(devices []fileDevice) []Device {
+	list := make([]Device, 0, len(devices))
+	for _, dev := range devices {
+		list = append(list, Device{
+			DMPS: structs.DMPS{
+				Hostname:       dev.Hostname,
+				Address:        dev.Address,
+				CommandToQuery: dev.CommandToQuery,
+				Port:           dev.Port,
+			},
+			Naming:      dev.Naming,
+			Transport:   dev.Transport,
+			Credentials: dev.Credentials,
+
+			ExpectedResponse:   dev.ExpectedResponse,
+			ForbiddenResponses: dev.ForbiddenResponses,
+		})
+
+		// already checked by read
+		list[len(list)-1].Retry, _ = dev.Retry.policy()
+	}
+
+	return list
+}
+
+// policy converts r into a RetryPolicy, returning nil if r is nil
+func (r *fileRetryPolicy) policy() (*RetryPolicy, error) {
+	if r == nil {
+		return nil, nil
+	}
+
+	p := &RetryPolicy{
+		Multiplier:  r.Multiplier,
+		Jitter:      r.Jitter,
+		MaxAttempts: r.MaxAttempts,
+	}
+
+	var err error
+	if len(r.InitialDelay) > 0 {
+		if p.InitialDelay, err = time.ParseDuration(r.InitialDelay); err != nil {
+			return nil, fmt.Errorf("invalid initial delay: %s", err)
+		}
+	}
+
+	if len(r.MaxDelay) > 0 {
+		if p.MaxDelay, err = time.ParseDuration(r.MaxDelay); err != nil {
+			return nil, fmt.Errorf("invalid max delay: %s", err)
+		}
+	}
+
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
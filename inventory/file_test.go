@@ -0,0 +1,230 @@
+package inventory
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/byuoitav/common/structs"
+	"github.com/byuoitav/crestron-telnet-microservice/naming"
+)
+
+func writeDeviceFile(t *testing.T, name, contents string) (string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return path, func() { os.RemoveAll(dir) }
+}
+
+const deviceYAML = `
+dmps:
+  - hostname: ITB-1101-CP1
+    address: 10.5.34.10
+otherCrestron:
+  - hostname: ITB-1108-CP1
+    address: 10.5.34.12
+    port: "41795"
+    commandToQuery: VERSION
+    expectedResponse: 'Cntrl Eng \[v'
+    forbiddenResponses:
+      - '(?i)error'
+    naming:
+      roomID: ITB-1108A
+  - hostname: ITB-1110-CP1
+    address: 10.5.34.14
+    transport: ssh
+    credentials: secured-processors
+    retry:
+      initialDelay: 30s
+      maxDelay: 10m
+      jitter: 0
+      maxAttempts: 5
+`
+
+func TestFileSource(t *testing.T) {
+	path, cleanup := writeDeviceFile(t, "devices.yaml", deviceYAML)
+	defer cleanup()
+
+	src, err := NewFileSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dmps, err := src.GetDMPSList()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Device{{DMPS: structs.DMPS{Hostname: "ITB-1101-CP1", Address: "10.5.34.10"}}}
+	if !reflect.DeepEqual(dmps, want) {
+		t.Errorf("got dmps\n%+v\nwant\n%+v", dmps, want)
+	}
+
+	other, err := src.GetOtherCrestronList()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jitter, maxAttempts := 0.0, 5
+	want = []Device{
+		{
+			DMPS: structs.DMPS{
+				Hostname:       "ITB-1108-CP1",
+				Address:        "10.5.34.12",
+				Port:           "41795",
+				CommandToQuery: "VERSION",
+			},
+			ExpectedResponse:   `Cntrl Eng \[v`,
+			ForbiddenResponses: []string{"(?i)error"},
+			Naming:             &naming.Override{RoomID: "ITB-1108A"},
+		},
+		{
+			DMPS: structs.DMPS{
+				Hostname: "ITB-1110-CP1",
+				Address:  "10.5.34.14",
+			},
+			Transport:   TransportSSH,
+			Credentials: "secured-processors",
+			Retry: &RetryPolicy{
+				InitialDelay: 30 * time.Second,
+				MaxDelay:     10 * time.Minute,
+				Jitter:       &jitter,
+				MaxAttempts:  &maxAttempts,
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(other, want) {
+		t.Errorf("got other crestron\n%+v\nwant\n%+v", other, want)
+	}
+}
+
+func TestFileSourceParsing(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		contents string
+
+		// err is part of the error NewFileSource should return, or empty if it should work
+		err string
+	}{
+		{
+			name:     "YAMLUnknownField",
+			file:     "devices.yaml",
+			contents: "dmps:\n  - hostname: ITB-1101-CP1\n    adress: 10.5.34.10\n",
+			err:      "field adress not found",
+		},
+		{
+			name:     "JSONUnknownField",
+			file:     "devices.json",
+			contents: `{"dmps": [{"hostname": "ITB-1101-CP1", "address": "10.5.34.10", "notes": "rack 2"}]}`,
+		},
+		{
+			name:     "UnknownExtension",
+			file:     "devices.txt",
+			contents: "dmps: []\n",
+			err:      "unknown extension",
+		},
+		{
+			name:     "InvalidTransport",
+			file:     "devices.yaml",
+			contents: "dmps:\n  - hostname: ITB-1101-CP1\n    address: 10.5.34.10\n    transport: rlogin\n",
+			err:      `invalid transport "rlogin"`,
+		},
+		{
+			name:     "InvalidPattern",
+			file:     "devices.yaml",
+			contents: "otherCrestron:\n  - hostname: ITB-1108-CP1\n    address: 10.5.34.12\n    expectedResponse: '[v'\n",
+			err:      "invalid response pattern",
+		},
+		{
+			name:     "InvalidRetry",
+			file:     "devices.yaml",
+			contents: "dmps:\n  - hostname: ITB-1101-CP1\n    address: 10.5.34.10\n    retry:\n      initialDelay: soon\n",
+			err:      "invalid retry policy",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, cleanup := writeDeviceFile(t, tt.file, tt.contents)
+			defer cleanup()
+
+			_, err := NewFileSource(path)
+			switch {
+			case len(tt.err) == 0 && err != nil:
+				t.Errorf("got error %s, expected none", err)
+			case len(tt.err) > 0 && err == nil:
+				t.Errorf("got no error, expected %q", tt.err)
+			case len(tt.err) > 0 && !strings.Contains(err.Error(), tt.err):
+				t.Errorf("got error %s, expected %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestFileSourceReload(t *testing.T) {
+	path, cleanup := writeDeviceFile(t, "devices.yaml", "dmps:\n  - hostname: ITB-1101-CP1\n    address: 10.5.34.10\n")
+	defer cleanup()
+
+	src, err := NewFileSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src.PollInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := src.Watch(ctx)
+
+	// let Watch see the current modification time before the file changes
+	time.Sleep(20 * time.Millisecond)
+
+	if err := ioutil.WriteFile(path, []byte("dmps:\n  - hostname: ITB-1102-CP1\n    address: 10.5.34.11\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// make sure the modification time changes, even on filesystems that only keep whole seconds
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-changes:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for the change to be noticed")
+	}
+
+	dmps, err := src.GetDMPSList()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dmps) != 1 || dmps[0].Hostname != "ITB-1102-CP1" {
+		t.Errorf("got %+v after the file changed, expected ITB-1102-CP1", dmps)
+	}
+
+	cancel()
+
+	if _, ok := <-changes; ok {
+		t.Errorf("got another change after nothing changed")
+	}
+}
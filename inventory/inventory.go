@@ -0,0 +1,127 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/byuoitav/common/structs"
+	"github.com/byuoitav/crestron-telnet-microservice/naming"
+)
+
+const (
+	// TransportTelnet connects to the device's console over plain telnet (port 23 by default)
+	TransportTelnet = "telnet"
+
+	// TransportSSH connects to the device's console over ssh (port 22 by default)
+	TransportSSH = "ssh"
+)
+
+// Device is a crestron device to monitor, along with any extra settings its source has for it
+type Device struct {
+	structs.DMPS
+
+	// Naming overrides the ids that would otherwise be derived from the hostname
+	Naming *naming.Override `json:"naming,omitempty"`
+
+	// Transport is how to connect to the device's console, either TransportTelnet or TransportSSH.
+	// If it is empty, the service's default transport is used.
+	Transport string `json:"transport,omitempty"`
+
+	// Credentials is the name of the credential set to log in with. If it is empty, the default set is used.
+	Credentials string `json:"credentials,omitempty"`
+
+	// ExpectedResponse is a regex the response to CommandToQuery has to match for the device to be healthy
+	ExpectedResponse string `json:"expectedResponse,omitempty"`
+
+	// ForbiddenResponses are regexes that mark the device as unhealthy if the response matches any of them
+	ForbiddenResponses []string `json:"forbiddenResponses,omitempty"`
+
+	// Retry overrides the service's reconnect policy for this device
+	Retry *RetryPolicy `json:"retry,omitempty"`
+}
+
+// RetryPolicy is how to back off between attempts to reconnect to a device.
+// Any field left as zero (or nil) uses the service's default.
+type RetryPolicy struct {
+	// InitialDelay is how long to wait before the first retry
+	InitialDelay time.Duration `json:"initialDelay,omitempty"`
+
+	// MaxDelay is the longest to wait between retries, before jitter is applied
+	MaxDelay time.Duration `json:"maxDelay,omitempty"`
+
+	// Multiplier is how much the delay grows after each failed retry
+	Multiplier float64 `json:"multiplier,omitempty"`
+
+	// Jitter randomizes each delay by up to this fraction of it, e.g. 0.2 is +/- 20%.
+	// It is a pointer so that a device can turn jitter off by setting it to 0.
+	Jitter *float64 `json:"jitter,omitempty"`
+
+	// MaxAttempts is how many retries in a row can fail before the device is marked offline,
+	// without waiting for the offline grace period. 0 only uses the grace period, and it is
+	// a pointer so that a device can set it to 0 when the default is something else.
+	MaxAttempts *int `json:"maxAttempts,omitempty"`
+}
+
+// Validate makes sure none of the policy's fields are out of range
+func (r RetryPolicy) Validate() error {
+	switch {
+	case r.InitialDelay < 0:
+		return fmt.Errorf("initial delay must not be negative")
+	case r.MaxDelay < 0:
+		return fmt.Errorf("max delay must not be negative")
+	case r.Multiplier != 0 && r.Multiplier < 1:
+		return fmt.Errorf("multiplier must be at least 1")
+	case r.Jitter != nil && (*r.Jitter < 0 || *r.Jitter > 1):
+		return fmt.Errorf("jitter must be between 0 and 1")
+	case r.MaxAttempts != nil && *r.MaxAttempts < 0:
+		return fmt.Errorf("max attempts must not be negative")
+	}
+
+	return nil
+}
+
+// DeviceSource provides the lists of crestron devices that should be monitored
+type DeviceSource interface {
+	// GetDMPSList returns the DMPSes to connect to and pull events from
+	GetDMPSList() ([]Device, error)
+
+	// GetOtherCrestronList returns the other crestron devices to health check
+	GetOtherCrestronList() ([]Device, error)
+}
+
+// Watcher is implemented by sources that can tell when their device lists have changed,
+// so that changes can be picked up without waiting for the next scheduled refresh.
+type Watcher interface {
+	// Watch returns a channel that receives a value every time the source changes.
+	// The channel is closed once ctx is cancelled.
+	Watch(ctx context.Context) <-chan struct{}
+}
+
+// Rejecter is implemented by sources that leave invalid devices out of a list instead of failing the whole list
+type Rejecter interface {
+	// Rejected returns the devices that were left out of list (DMPSList or OtherCrestronList) the last time it was fetched
+	Rejected(list string) []RejectedDevice
+}
+
+// RejectedDevice is a device that was left out of a list because its settings are invalid
+type RejectedDevice struct {
+	Hostname string `json:"hostname"`
+	Reason   string `json:"reason"`
+}
+
+// FromDMPS wraps a list of DMPS in Devices with no extra settings
+func FromDMPS(list []structs.DMPS) []Device {
+	devices := make([]Device, 0, len(list))
+	for _, dmps := range list {
+		devices = append(devices, Device{DMPS: dmps})
+	}
+
+	return devices
+}
+
+func copyDevices(devices []Device) []Device {
+	cp := make([]Device, len(devices))
+	copy(cp, devices)
+	return cp
+}
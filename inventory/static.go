@@ -0,0 +1,119 @@
+package inventory
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/byuoitav/common/structs"
+)
+
+// StaticSource is a fixed set of devices, usually built from env vars or flags
+type StaticSource struct {
+	DMPS          []Device
+	OtherCrestron []Device
+}
+
+// NewStaticSource parses two device lists in the format accepted by ParseDeviceList
+func NewStaticSource(dmps, otherCrestron string) (*StaticSource, error) {
+	var err error
+	s := &StaticSource{}
+
+	s.DMPS, err = ParseDeviceList(dmps)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dmps list: %s", err)
+	}
+
+	s.OtherCrestron, err = ParseDeviceList(otherCrestron)
+	if err != nil {
+		return nil, fmt.Errorf("invalid other crestron list: %s", err)
+	}
+
+	return s, nil
+}
+
+// GetDMPSList returns the static dmps list
+func (s *StaticSource) GetDMPSList() ([]Device, error) {
+	return copyDevices(s.DMPS), nil
+}
+
+// GetOtherCrestronList returns the static other crestron list
+func (s *StaticSource) GetOtherCrestronList() ([]Device, error) {
+	return copyDevices(s.OtherCrestron), nil
+}
+
+// ParseDeviceList parses a comma separated list of devices, each in the form
+// HOSTNAME=ADDRESS[:PORT][/COMMAND], e.g.
+//
+//	ITB-1101-CP1=10.5.34.10,ITB-1108-CP1=10.5.34.12:41795/VERSION
+//
+// An IPv6 address needs brackets to be given a port, e.g. ITB-1110-CP1=[fd00::14]:41795
+func ParseDeviceList(list string) ([]Device, error) {
+	var devices []Device
+
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+
+		split := strings.SplitN(entry, "=", 2)
+		if len(split) != 2 || len(split[0]) == 0 || len(split[1]) == 0 {
+			return nil, fmt.Errorf("%q is not in the form HOSTNAME=ADDRESS[:PORT][/COMMAND]", entry)
+		}
+
+		dev := structs.DMPS{
+			Hostname: strings.TrimSpace(split[0]),
+		}
+
+		addr := split[1]
+		if i := strings.Index(addr, "/"); i >= 0 {
+			dev.CommandToQuery = addr[i+1:]
+			addr = addr[:i]
+		}
+
+		var err error
+		dev.Address, dev.Port, err = splitAddress(strings.TrimSpace(addr))
+		if err != nil {
+			return nil, fmt.Errorf("%q has an invalid address: %s", entry, err)
+		}
+
+		if len(dev.Address) == 0 {
+			return nil, fmt.Errorf("%q is missing an address", entry)
+		}
+
+		devices = append(devices, Device{DMPS: dev})
+	}
+
+	return devices, nil
+}
+
+// splitAddress splits addr into its host and optional port. A bare IPv6 address has
+// more than one colon, so it is only split if it is in brackets, e.g. [fd00::14]:41795.
+func splitAddress(addr string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(addr, "[") && strings.HasSuffix(addr, "]"):
+		return addr[1 : len(addr)-1], "", nil
+	case strings.HasPrefix(addr, "["):
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return "", "", err
+		}
+
+		return host, port, checkPort(port)
+	case strings.Count(addr, ":") == 1:
+		i := strings.Index(addr, ":")
+		return addr[:i], addr[i+1:], checkPort(addr[i+1:])
+	}
+
+	return addr, "", nil
+}
+
+func checkPort(port string) error {
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("port %q must be a number from 1 to 65535", port)
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package inventory
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/byuoitav/common/structs"
+)
+
+func TestParseDeviceList(t *testing.T) {
+	tests := []struct {
+		name string
+		list string
+		want []structs.DMPS
+		err  bool
+	}{
+		{
+			name: "Address",
+			list: "ITB-1101-CP1=10.5.34.10",
+			want: []structs.DMPS{{Hostname: "ITB-1101-CP1", Address: "10.5.34.10"}},
+		},
+		{
+			name: "PortAndCommand",
+			list: " ITB-1101-CP1=10.5.34.10 , ITB-1108-CP1=10.5.34.12:41795/VERSION ,",
+			want: []structs.DMPS{
+				{Hostname: "ITB-1101-CP1", Address: "10.5.34.10"},
+				{Hostname: "ITB-1108-CP1", Address: "10.5.34.12", Port: "41795", CommandToQuery: "VERSION"},
+			},
+		},
+		{
+			name: "HostnameAndPort",
+			list: "ITB-1101-CP1=itb-1101-cp1.byu.edu:23",
+			want: []structs.DMPS{{Hostname: "ITB-1101-CP1", Address: "itb-1101-cp1.byu.edu", Port: "23"}},
+		},
+		{
+			name: "IPv6",
+			list: "ITB-1110-CP1=fd00::14/VERSION",
+			want: []structs.DMPS{{Hostname: "ITB-1110-CP1", Address: "fd00::14", CommandToQuery: "VERSION"}},
+		},
+		{
+			name: "BracketedIPv6",
+			list: "ITB-1110-CP1=[fd00::14]",
+			want: []structs.DMPS{{Hostname: "ITB-1110-CP1", Address: "fd00::14"}},
+		},
+		{
+			name: "BracketedIPv6AndPort",
+			list: "ITB-1110-CP1=[fd00::14]:41795",
+			want: []structs.DMPS{{Hostname: "ITB-1110-CP1", Address: "fd00::14", Port: "41795"}},
+		},
+		{
+			name: "Empty",
+			list: "",
+		},
+		{
+			name: "MissingAddress",
+			list: "ITB-1101-CP1=:23",
+			err:  true,
+		},
+		{
+			name: "MissingHostname",
+			list: "=10.5.34.10",
+			err:  true,
+		},
+		{
+			name: "InvalidPort",
+			list: "ITB-1101-CP1=10.5.34.10:telnet",
+			err:  true,
+		},
+		{
+			name: "UnclosedBracket",
+			list: "ITB-1110-CP1=[fd00::14:41795",
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			devices, err := ParseDeviceList(tt.list)
+			if tt.err {
+				if err == nil {
+					t.Errorf("got %+v, expected an error", devices)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []structs.DMPS
+			for _, dev := range devices {
+				got = append(got, dev.DMPS)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStaticSource(t *testing.T) {
+	src, err := NewStaticSource("ITB-1101-CP1=10.5.34.10", "ITB-1108-CP1=10.5.34.12:41795")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dmps, _ := src.GetDMPSList()
+	other, _ := src.GetOtherCrestronList()
+
+	if len(dmps) != 1 || dmps[0].Hostname != "ITB-1101-CP1" || len(other) != 1 || other[0].Port != "41795" {
+		t.Fatalf("got dmps %+v and other crestron %+v", dmps, other)
+	}
+
+	// callers can't change the source's lists
+	dmps[0].Address = "10.5.34.99"
+	if again, _ := src.GetDMPSList(); again[0].Address != "10.5.34.10" {
+		t.Errorf("changing a returned list changed the source")
+	}
+
+	if _, err := NewStaticSource("ITB-1101-CP1", ""); err == nil {
+		t.Errorf("got no error for an invalid dmps list")
+	}
+
+	if _, err := NewStaticSource("", "ITB-1108-CP1=10.5.34.12:port"); err == nil {
+		t.Errorf("got no error for an invalid other crestron list")
+	}
+}
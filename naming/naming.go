@@ -0,0 +1,217 @@
+package naming
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPattern splits hostnames like ITB-1101-CP1 into building ITB, room 1101, and device CP1
+const DefaultPattern = `^(?P<building>[^-]+)-(?P<room>[^-]+)(?:-(?P<device>.+))?$`
+
+// Names are the ids derived from a hostname
+type Names struct {
+	BuildingID string `json:"buildingID"`
+	RoomID     string `json:"roomID"`
+	DeviceID   string `json:"deviceID"`
+
+	// DeviceSuffix is the device group matched from the hostname, e.g. CP1
+	DeviceSuffix string `json:"deviceSuffix,omitempty"`
+}
+
+// Device returns the id of the device in the same room with suffix, e.g. ITB-1101-D1 for D1.
+// If suffix is the hostname's own device group, DeviceID is returned so that an override applies to it too.
+func (n Names) Device(suffix string) string {
+	if len(n.DeviceSuffix) > 0 && strings.EqualFold(suffix, n.DeviceSuffix) {
+		return n.DeviceID
+	}
+
+	return n.RoomID + "-" + suffix
+}
+
+// Override replaces any of the ids that would otherwise be derived from a device's hostname.
+// Empty fields are ignored.
+type Override struct {
+	BuildingID string `json:"buildingID,omitempty" yaml:"buildingID,omitempty"`
+	RoomID     string `json:"roomID,omitempty" yaml:"roomID,omitempty"`
+	DeviceID   string `json:"deviceID,omitempty" yaml:"deviceID,omitempty"`
+}
+
+// Unresolved is a hostname that couldn't be resolved
+type Unresolved struct {
+	Hostname  string    `json:"hostname"`
+	Error     string    `json:"error"`
+	FirstSeen time.Time `json:"first-seen"`
+	LastSeen  time.Time `json:"last-seen"`
+	Count     int       `json:"count"`
+}
+
+// Resolver maps hostnames to building, room, and device ids using a list of regular expressions.
+// Each pattern must have named groups "building" and "room", and may have a "device" group.
+// Patterns are tried in order and the first match wins.
+type Resolver struct {
+	patterns []pattern
+
+	mu         sync.Mutex
+	unresolved map[string]*Unresolved
+}
+
+type pattern struct {
+	re       *regexp.Regexp
+	building int
+	room     int
+	device   int
+}
+
+// NewResolver compiles patterns into a Resolver. If no patterns are given, DefaultPattern is used.
+func NewResolver(patterns ...string) (*Resolver, error) {
+	if len(patterns) == 0 {
+		patterns = []string{DefaultPattern}
+	}
+
+	r := &Resolver{
+		unresolved: make(map[string]*Unresolved),
+	}
+
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid naming pattern %q: %s", p, err)
+		}
+
+		pat := pattern{
+			re:       re,
+			building: -1,
+			room:     -1,
+			device:   -1,
+		}
+
+		for i, name := range re.SubexpNames() {
+			switch name {
+			case "building":
+				pat.building = i
+			case "room":
+				pat.room = i
+			case "device":
+				pat.device = i
+			}
+		}
+
+		if pat.building < 0 || pat.room < 0 {
+			return nil, fmt.Errorf("naming pattern %q must have named groups 'building' and 'room'", p)
+		}
+
+		r.patterns = append(r.patterns, pat)
+	}
+
+	return r, nil
+}
+
+// Resolve derives ids from hostname, applying override (which may be nil) on top.
+// The room id is always prefixed with the building id, e.g. ITB-1101, and the device id
+// is the room id followed by the device group, or the hostname if there is no device group.
+func (r *Resolver) Resolve(hostname string, override *Override) (Names, error) {
+	hostname = strings.TrimSpace(hostname)
+
+	names, err := r.match(hostname)
+	if override != nil {
+		if len(override.BuildingID) > 0 {
+			names.BuildingID = override.BuildingID
+		}
+
+		if len(override.RoomID) > 0 {
+			names.RoomID = override.RoomID
+		}
+
+		if len(override.DeviceID) > 0 {
+			names.DeviceID = override.DeviceID
+		}
+
+		// the override may have filled in everything we couldn't match
+		if len(names.BuildingID) > 0 && len(names.RoomID) > 0 {
+			err = nil
+		}
+	}
+
+	if len(names.DeviceID) == 0 {
+		names.DeviceID = hostname
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err != nil {
+		u, ok := r.unresolved[hostname]
+		if !ok {
+			u = &Unresolved{
+				Hostname:  hostname,
+				FirstSeen: time.Now(),
+			}
+
+			r.unresolved[hostname] = u
+		}
+
+		u.Error = err.Error()
+		u.LastSeen = time.Now()
+		u.Count++
+
+		return names, err
+	}
+
+	delete(r.unresolved, hostname)
+	return names, nil
+}
+
+// Unresolved returns every hostname that has failed to resolve, sorted by hostname.
+// Hostnames are removed from the list once they resolve successfully.
+func (r *Resolver) Unresolved() []Unresolved {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	list := make([]Unresolved, 0, len(r.unresolved))
+	for _, u := range r.unresolved {
+		list = append(list, *u)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Hostname < list[j].Hostname
+	})
+
+	return list
+}
+
+func (r *Resolver) match(hostname string) (Names, error) {
+	var names Names
+
+	if len(hostname) == 0 {
+		return names, fmt.Errorf("hostname is empty")
+	}
+
+	for _, pat := range r.patterns {
+		match := pat.re.FindStringSubmatch(hostname)
+		if match == nil {
+			continue
+		}
+
+		building := match[pat.building]
+		room := match[pat.room]
+		if len(building) == 0 || len(room) == 0 {
+			continue
+		}
+
+		names.BuildingID = building
+		names.RoomID = building + "-" + room
+
+		if pat.device >= 0 && len(match[pat.device]) > 0 {
+			names.DeviceSuffix = match[pat.device]
+			names.DeviceID = names.RoomID + "-" + names.DeviceSuffix
+		}
+
+		return names, nil
+	}
+
+	return names, fmt.Errorf("hostname %q doesn't match any naming pattern", hostname)
+}
@@ -0,0 +1,161 @@
+package naming
+
+import (
+	"testing"
+)
+
+// threePartRoom is a pattern for hostnames whose room has a dash in it, like TNRB-W-1101-CP1
+const threePartRoom = `^(?P<building>[^-]+)-(?P<room>[^-]+-[^-]+)-(?P<device>[^-]+)$`
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		hostname string
+		override *Override
+		want     Names
+		err      bool
+	}{
+		{
+			name:     "Default",
+			hostname: "ITB-1101-CP1",
+			want:     Names{BuildingID: "ITB", RoomID: "ITB-1101", DeviceID: "ITB-1101-CP1", DeviceSuffix: "CP1"},
+		},
+		{
+			name:     "DefaultWithoutDevice",
+			hostname: " JFSB-B190 ",
+			want:     Names{BuildingID: "JFSB", RoomID: "JFSB-B190", DeviceID: "JFSB-B190"},
+		},
+		{
+			name:     "DefaultThreePartRoom",
+			hostname: "TNRB-W-1101-CP1",
+			want:     Names{BuildingID: "TNRB", RoomID: "TNRB-W", DeviceID: "TNRB-W-1101-CP1", DeviceSuffix: "1101-CP1"},
+		},
+		{
+			name:     "ThreePartRoom",
+			patterns: []string{threePartRoom, DefaultPattern},
+			hostname: "TNRB-W-1101-CP1",
+			want:     Names{BuildingID: "TNRB", RoomID: "TNRB-W-1101", DeviceID: "TNRB-W-1101-CP1", DeviceSuffix: "CP1"},
+		},
+		{
+			name:     "ThreePartRoomFallsBack",
+			patterns: []string{threePartRoom, DefaultPattern},
+			hostname: "ITB-1101-CP1",
+			want:     Names{BuildingID: "ITB", RoomID: "ITB-1101", DeviceID: "ITB-1101-CP1", DeviceSuffix: "CP1"},
+		},
+		{
+			name:     "NoDash",
+			hostname: "DMPS3",
+			want:     Names{DeviceID: "DMPS3"},
+			err:      true,
+		},
+		{
+			name:     "Empty",
+			hostname: "",
+			err:      true,
+		},
+		{
+			name:     "NoDashOverride",
+			hostname: "DMPS3",
+			override: &Override{BuildingID: "ITB", RoomID: "ITB-1101"},
+			want:     Names{BuildingID: "ITB", RoomID: "ITB-1101", DeviceID: "DMPS3"},
+		},
+		{
+			name:     "NoDashPartialOverride",
+			hostname: "DMPS3",
+			override: &Override{RoomID: "ITB-1101"},
+			want:     Names{RoomID: "ITB-1101", DeviceID: "DMPS3"},
+			err:      true,
+		},
+		{
+			name:     "Override",
+			hostname: "ITB-1101-CP1",
+			override: &Override{RoomID: "ITB-1106", DeviceID: "ITB-1106-CP1"},
+			want:     Names{BuildingID: "ITB", RoomID: "ITB-1106", DeviceID: "ITB-1106-CP1", DeviceSuffix: "CP1"},
+		},
+		{
+			name:     "EmptyOverride",
+			hostname: "ITB-1101-CP1",
+			override: &Override{},
+			want:     Names{BuildingID: "ITB", RoomID: "ITB-1101", DeviceID: "ITB-1101-CP1", DeviceSuffix: "CP1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewResolver(tt.patterns...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := r.Resolve(tt.hostname, tt.override)
+			switch {
+			case tt.err && err == nil:
+				t.Fatalf("expected an error, got %+v", got)
+			case !tt.err && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("got %+v, expected %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewResolverRequiresGroups(t *testing.T) {
+	if _, err := NewResolver(`^(?P<building>[^-]+)-(.+)$`); err == nil {
+		t.Errorf("created a resolver from a pattern without a room group")
+	}
+
+	if _, err := NewResolver(`^(?P<building>[^-]+`); err == nil {
+		t.Errorf("created a resolver from an invalid pattern")
+	}
+}
+
+func TestDevice(t *testing.T) {
+	r, err := NewResolver()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := r.Resolve("ITB-1101-CP1", &Override{DeviceID: "ITB-1101-DMPS"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"D1":  "ITB-1101-D1",
+		"CP1": "ITB-1101-DMPS",
+		"cp1": "ITB-1101-DMPS",
+	}
+
+	for suffix, want := range tests {
+		if got := names.Device(suffix); got != want {
+			t.Errorf("Device(%q) = %q, expected %q", suffix, got, want)
+		}
+	}
+}
+
+func TestUnresolved(t *testing.T) {
+	r, err := NewResolver()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Resolve("DMPS3", nil)
+	r.Resolve("DMPS3", nil)
+	r.Resolve("ITB-1101-CP1", nil)
+
+	list := r.Unresolved()
+	if len(list) != 1 || list[0].Hostname != "DMPS3" || list[0].Count != 2 {
+		t.Fatalf("got %+v, expected DMPS3 twice", list)
+	}
+
+	// once it resolves (here through an override) it is forgotten
+	r.Resolve("DMPS3", &Override{BuildingID: "ITB", RoomID: "ITB-1101"})
+
+	if list := r.Unresolved(); len(list) != 0 {
+		t.Errorf("got %+v after resolving, expected nothing", list)
+	}
+}
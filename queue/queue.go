@@ -0,0 +1,593 @@
+package queue
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/byuoitav/common/log"
+	"github.com/byuoitav/common/v2/events"
+)
+
+// syncInterval is how often writes to the queue file are flushed to disk. Writes are synced in
+// batches rather than one at a time, so a crash can lose up to this much of the most recent writes.
+const syncInterval = time.Second
+
+// Entry is a single event in the queue
+type Entry struct {
+	Seq   uint64
+	Event events.Event
+}
+
+// record is a single line in the queue file. Pushes are written with the event,
+// and removals (acks and drops) are written with just the sequence number.
+type record struct {
+	Seq   uint64        `json:"seq"`
+	Event *events.Event `json:"event,omitempty"`
+}
+
+// Queue is a bounded FIFO of events, backed by an append-only file so that queued
+// events survive a restart. Entries are taken off the front, and must be acked once
+// they are delivered or nacked to be put back at the front for a later retry.
+//
+// When the queue is full, the oldest entry is dropped to make room. That is the oldest
+// pending entry, or if every entry is in flight, the oldest in flight entry, which
+// will not be retried if its delivery fails.
+type Queue struct {
+	path string
+	max  int
+
+	mu       sync.Mutex
+	file     *os.File
+	pending  []Entry
+	inflight map[uint64]Entry
+	nextSeq  uint64
+	records  int
+	dropped  uint64
+	closed   bool
+	ready    chan struct{}
+
+	// dirty is set when something has been written to file that hasn't been synced yet
+	dirty  bool
+	done   chan struct{}
+	syncer sync.WaitGroup
+
+	// while the file is being compacted, records written to it are also kept in tail,
+	// so they can be added to the compacted file before it replaces the current one
+	compacting  bool
+	tail        [][]byte
+	compactions sync.WaitGroup
+
+	// after a failed compaction, the next one isn't tried until the file has this many records
+	retryCompactionAt int
+}
+
+// Stats is a snapshot of the state of a queue
+type Stats struct {
+	Depth    int    `json:"depth"`
+	InFlight int    `json:"in-flight"`
+	Max      int    `json:"max"`
+	Dropped  uint64 `json:"dropped"`
+	Path     string `json:"path,omitempty"`
+}
+
+// Open opens (or creates) the queue stored at path, replaying any events that were
+// still queued when it was last closed. If path is empty, the queue is only kept in memory.
+func Open(path string, max int) (*Queue, error) {
+	if max <= 0 {
+		return nil, fmt.Errorf("max queue size must be positive")
+	}
+
+	q := &Queue{
+		path:     path,
+		max:      max,
+		inflight: make(map[uint64]Entry),
+		ready:    make(chan struct{}, 1),
+	}
+
+	if len(path) == 0 {
+		return q, nil
+	}
+
+	if err := q.replay(); err != nil {
+		return nil, err
+	}
+
+	// nothing else can be using the queue yet, so the file is compacted in place
+	if err := q.finishCompaction(q.writeCompacted(q.snapshot())); err != nil {
+		return nil, err
+	}
+
+	if len(q.pending) > 0 {
+		log.L.Infof("Replaying %v queued events from %s", len(q.pending), path)
+		q.signal()
+	}
+
+	q.done = make(chan struct{})
+	q.syncer.Add(1)
+	go q.syncPeriodically()
+
+	return q, nil
+}
+
+// Push adds x to the back of the queue, dropping the oldest entry if the queue is full
+func (q *Queue) Push(x events.Event) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.pending)+len(q.inflight) >= q.max {
+		if err := q.dropOldest(); err != nil {
+			return err
+		}
+	}
+
+	entry := Entry{
+		Seq:   q.nextSeq,
+		Event: x,
+	}
+	q.nextSeq++
+
+	if err := q.write(record{Seq: entry.Seq, Event: &entry.Event}); err != nil {
+		return err
+	}
+
+	q.pending = append(q.pending, entry)
+	q.signal()
+
+	// nothing is acked while the destination is down, so the file has to be compacted here too
+	q.compactIfNeeded()
+	return nil
+}
+
+// Take removes up to n entries from the front of the queue and marks them in flight
+func (q *Queue) Take(n int) []Entry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if n > len(q.pending) {
+		n = len(q.pending)
+	}
+
+	entries := make([]Entry, n)
+	copy(entries, q.pending[:n])
+	q.pending = q.pending[n:]
+
+	for _, e := range entries {
+		q.inflight[e.Seq] = e
+	}
+
+	// wake up anyone else waiting for entries
+	if len(q.pending) > 0 {
+		q.signal()
+	}
+
+	return entries
+}
+
+// Ack permanently removes entries that have been delivered. If their removal can't be
+// written to the queue file, they are still removed, but will be replayed after a restart.
+func (q *Queue) Ack(entries []Entry) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var firstErr error
+	for _, e := range entries {
+		if _, ok := q.inflight[e.Seq]; !ok {
+			continue
+		}
+
+		delete(q.inflight, e.Seq)
+
+		if err := q.write(record{Seq: e.Seq}); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	q.compactIfNeeded()
+	return firstErr
+}
+
+// Nack puts entries that failed to be delivered back at the front of the queue
+func (q *Queue) Nack(entries []Entry) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var back []Entry
+	for _, e := range entries {
+		if _, ok := q.inflight[e.Seq]; !ok {
+			continue
+		}
+
+		delete(q.inflight, e.Seq)
+		back = append(back, e)
+	}
+
+	q.pending = append(back, q.pending...)
+	if len(q.pending) > 0 {
+		q.signal()
+	}
+}
+
+// Ready returns a channel that receives a value when there may be entries to take
+func (q *Queue) Ready() <-chan struct{} {
+	return q.ready
+}
+
+// Len returns the number of pending and in flight entries
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.pending) + len(q.inflight)
+}
+
+// Stats returns a snapshot of the queue's state
+func (q *Queue) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return Stats{
+		Depth:    len(q.pending),
+		InFlight: len(q.inflight),
+		Max:      q.max,
+		Dropped:  q.dropped,
+		Path:     q.path,
+	}
+}
+
+// Close syncs and closes the backing file, after waiting for any compaction to finish.
+// Anything still in the queue will be replayed the next time it is opened.
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+
+	syncErr := q.sync()
+	f := q.file
+	q.file = nil
+	q.closed = true
+	q.mu.Unlock()
+
+	if q.done != nil {
+		close(q.done)
+		q.syncer.Wait()
+	}
+
+	// a compaction that finishes after the file is closed is thrown away
+	q.compactions.Wait()
+
+	if f == nil {
+		return syncErr
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return syncErr
+}
+
+// dropOldest removes the oldest pending entry, or the oldest in flight entry if nothing is pending
+func (q *Queue) dropOldest() error {
+	var oldest Entry
+
+	if len(q.pending) > 0 {
+		oldest = q.pending[0]
+		q.pending = q.pending[1:]
+	} else {
+		first := true
+		for seq, e := range q.inflight {
+			if first || seq < oldest.Seq {
+				oldest = e
+				first = false
+			}
+		}
+
+		delete(q.inflight, oldest.Seq)
+	}
+
+	q.dropped++
+	log.L.Warnf("Event queue is full, dropping oldest event (%s %s=%s)", oldest.Event.TargetDevice.DeviceID, oldest.Event.Key, oldest.Event.Value)
+
+	return q.write(record{Seq: oldest.Seq})
+}
+
+// compactIfNeeded starts compacting the queue file in the background once most of its records
+// are for entries that are gone. The file is rewritten without holding q.mu, so that pushes
+// aren't held up by it.
+func (q *Queue) compactIfNeeded() {
+	if q.compacting || q.file == nil || q.records < q.retryCompactionAt || q.records <= 2*(len(q.pending)+len(q.inflight))+1000 {
+		return
+	}
+
+	q.compacting = true
+	entries := q.snapshot()
+
+	q.compactions.Add(1)
+	go func() {
+		defer q.compactions.Done()
+
+		tmpPath, records, err := q.writeCompacted(entries)
+
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		if err := q.finishCompaction(tmpPath, records, err); err != nil {
+			log.L.Warnf("%s", err)
+			q.retryCompactionAt = q.records + 1000
+		} else {
+			q.retryCompactionAt = 0
+		}
+
+		// more may have been written than was compacted away
+		q.compactIfNeeded()
+	}()
+}
+
+// syncPeriodically syncs the queue file every syncInterval until the queue is closed
+func (q *Queue) syncPeriodically() {
+	defer q.syncer.Done()
+
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.done:
+			return
+		case <-ticker.C:
+			q.mu.Lock()
+			if err := q.sync(); err != nil {
+				log.L.Warnf("%s", err)
+			}
+			q.mu.Unlock()
+		}
+	}
+}
+
+// sync flushes anything written to the queue file since the last sync to disk. q.mu must be held.
+func (q *Queue) sync() error {
+	if !q.dirty || q.file == nil {
+		return nil
+	}
+
+	if err := q.file.Sync(); err != nil {
+		return fmt.Errorf("unable to sync queue %s: %s", q.path, err)
+	}
+
+	q.dirty = false
+	return nil
+}
+
+func (q *Queue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (q *Queue) write(r record) error {
+	if len(q.path) == 0 {
+		return nil
+	}
+
+	if q.file == nil {
+		return fmt.Errorf("queue %s is closed", q.path)
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("unable to marshal queue record: %s", err)
+	}
+
+	b = append(b, '\n')
+	if _, err := q.file.Write(b); err != nil {
+		return fmt.Errorf("unable to write to queue %s: %s", q.path, err)
+	}
+
+	if q.compacting {
+		q.tail = append(q.tail, b)
+	}
+
+	q.dirty = true
+	q.records++
+	return nil
+}
+
+// replay rebuilds the pending entries from the queue file
+func (q *Queue) replay() error {
+	f, err := os.Open(q.path)
+	switch {
+	case os.IsNotExist(err):
+		return nil
+	case err != nil:
+		return fmt.Errorf("unable to open queue %s: %s", q.path, err)
+	}
+	defer f.Close()
+
+	queued := make(map[uint64]events.Event)
+	var order []uint64
+
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var r record
+			if jerr := json.Unmarshal(line, &r); jerr != nil {
+				// most likely a partial write from a crash, skip it
+				log.L.Warnf("skipping corrupt record in queue %s: %s", q.path, jerr)
+			} else {
+				if r.Seq >= q.nextSeq {
+					q.nextSeq = r.Seq + 1
+				}
+
+				if r.Event != nil {
+					queued[r.Seq] = *r.Event
+					order = append(order, r.Seq)
+				} else {
+					delete(queued, r.Seq)
+				}
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return fmt.Errorf("unable to read queue %s: %s", q.path, err)
+		}
+	}
+
+	for _, seq := range order {
+		if x, ok := queued[seq]; ok {
+			q.pending = append(q.pending, Entry{Seq: seq, Event: x})
+		}
+	}
+
+	// respect the max size if it was lowered since the file was written
+	if over := len(q.pending) - q.max; over > 0 {
+		q.pending = q.pending[over:]
+		q.dropped += uint64(over)
+	}
+
+	return nil
+}
+
+// snapshot returns every entry that is still queued, in the order they were pushed
+func (q *Queue) snapshot() []Entry {
+	entries := make([]Entry, 0, len(q.inflight)+len(q.pending))
+	for _, e := range q.inflight {
+		entries = append(entries, e)
+	}
+	entries = append(entries, q.pending...)
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Seq < entries[j].Seq
+	})
+
+	return entries
+}
+
+// writeCompacted writes entries to a temporary file next to the queue file, and returns its path
+// and how many records were written. It doesn't touch any of q's state, so it is safe to call without holding q.mu.
+func (q *Queue) writeCompacted(entries []Entry) (string, int, error) {
+	tmpPath := q.path + ".tmp"
+
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", 0, fmt.Errorf("unable to compact queue %s: %s", q.path, err)
+	}
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+
+	for i := range entries {
+		if err := enc.Encode(record{Seq: entries[i].Seq, Event: &entries[i].Event}); err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return "", 0, fmt.Errorf("unable to compact queue %s: %s", q.path, err)
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return "", 0, fmt.Errorf("unable to compact queue %s: %s", q.path, err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return "", 0, fmt.Errorf("unable to compact queue %s: %s", q.path, err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return "", 0, fmt.Errorf("unable to compact queue %s: %s", q.path, err)
+	}
+
+	return tmpPath, len(entries), nil
+}
+
+// finishCompaction appends anything written since the compaction started to the compacted file
+// at tmpPath, and then swaps it in for the queue file. If anything fails, the current queue file
+// is kept as is. q.mu must be held, unless nothing else can be using the queue yet.
+func (q *Queue) finishCompaction(tmpPath string, records int, err error) error {
+	tail := q.tail
+	q.compacting = false
+	q.tail = nil
+
+	if err != nil {
+		return err
+	}
+
+	// the queue was closed while compacting
+	if q.closed {
+		os.Remove(tmpPath)
+		return nil
+	}
+
+	f, err := os.OpenFile(tmpPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("unable to compact queue %s: %s", q.path, err)
+	}
+
+	for _, b := range tail {
+		if _, err := f.Write(b); err != nil {
+			f.Close()
+			os.Remove(tmpPath)
+			return fmt.Errorf("unable to compact queue %s: %s", q.path, err)
+		}
+	}
+
+	// the compacted file has to be on disk before it replaces the current one
+	if len(tail) > 0 {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			os.Remove(tmpPath)
+			return fmt.Errorf("unable to compact queue %s: %s", q.path, err)
+		}
+	}
+
+	// f stays open across the rename, and is then the handle for the queue file
+	if err := os.Rename(tmpPath, q.path); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("unable to compact queue %s: %s", q.path, err)
+	}
+
+	// the rename itself isn't durable until the directory is synced
+	if err := syncDir(filepath.Dir(q.path)); err != nil {
+		log.L.Warnf("unable to sync queue directory for %s: %s", q.path, err)
+	}
+
+	if q.file != nil {
+		q.file.Close()
+	}
+
+	q.file = f
+	q.records = records + len(tail)
+	q.dirty = false
+	return nil
+}
+
+// syncDir flushes changes to dir's entries (like a rename into it) to disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
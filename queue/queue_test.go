@@ -0,0 +1,330 @@
+package queue
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/byuoitav/common/v2/events"
+)
+
+func tempQueuePath(t *testing.T) (string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return filepath.Join(dir, "queue.ndjson"), func() { os.RemoveAll(dir) }
+}
+
+func event(i int) events.Event {
+	return events.Event{Key: "event", Value: strconv.Itoa(i)}
+}
+
+func push(t *testing.T, q *Queue, from, to int) {
+	t.Helper()
+
+	for i := from; i < to; i++ {
+		if err := q.Push(event(i)); err != nil {
+			t.Fatalf("unable to push %v: %s", i, err)
+		}
+	}
+}
+
+func values(entries []Entry) []string {
+	vals := []string{}
+	for _, e := range entries {
+		vals = append(vals, e.Event.Value)
+	}
+
+	return vals
+}
+
+func lines(t *testing.T, path string) int {
+	t.Helper()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bytes.Count(b, []byte("\n"))
+}
+
+func TestReplayAfterCrash(t *testing.T) {
+	path, cleanup := tempQueuePath(t)
+	defer cleanup()
+
+	q, err := Open(path, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	push(t, q, 0, 5)
+
+	// 0 and 1 are delivered, 2 is still in flight when the service dies
+	if err := q.Ack(q.Take(2)); err != nil {
+		t.Fatal(err)
+	}
+
+	q.Take(1)
+
+	// "kill" the service by opening the file again without closing the old queue
+	replayed, err := Open(path, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayed.Close()
+
+	if got := strings.Join(values(replayed.Take(10)), " "); got != "2 3 4" {
+		t.Fatalf("got %v after replay, want 2 3 4", got)
+	}
+
+	// sequence numbers keep counting up from where they left off
+	push(t, replayed, 5, 6)
+	if e := replayed.Take(1); len(e) != 1 || e[0].Seq != 5 {
+		t.Errorf("got %+v after replay, want seq 5", e)
+	}
+}
+
+func TestReplaySkipsCorruptRecords(t *testing.T) {
+	path, cleanup := tempQueuePath(t)
+	defer cleanup()
+
+	q, err := Open(path, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	push(t, q, 0, 2)
+	q.Close()
+
+	// a partial write from a crash, then another good record after it
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.WriteString(`{"seq":2,"event":{"key":"ev` + "\n")
+	f.WriteString(`{"seq":3,"event":{"key":"event","value":"3"}}` + "\n")
+	f.Close()
+
+	replayed, err := Open(path, 100)
+	if err != nil {
+		t.Fatalf("unable to open queue with a corrupt record: %s", err)
+	}
+	defer replayed.Close()
+
+	if got := strings.Join(values(replayed.Take(10)), " "); got != "0 1 3" {
+		t.Errorf("got %v, want 0 1 3", got)
+	}
+
+	// opening compacts the corrupt record away
+	if n := lines(t, path); n != 3 {
+		t.Errorf("got %v lines after reopening, want 3", n)
+	}
+}
+
+func TestNackRetriesFirst(t *testing.T) {
+	q, err := Open("", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	push(t, q, 0, 3)
+
+	taken := q.Take(2)
+	push(t, q, 3, 4)
+	q.Nack(taken)
+
+	if got := strings.Join(values(q.Take(10)), " "); got != "0 1 2 3" {
+		t.Errorf("got %v after nack, want 0 1 2 3", got)
+	}
+}
+
+func TestOverflowDropsOldest(t *testing.T) {
+	path, cleanup := tempQueuePath(t)
+	defer cleanup()
+
+	q, err := Open(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	push(t, q, 0, 5)
+
+	stats := q.Stats()
+	if stats.Depth != 3 || stats.Dropped != 2 {
+		t.Errorf("got depth %v and %v dropped, want 3 and 2", stats.Depth, stats.Dropped)
+	}
+
+	if got := strings.Join(values(q.Take(10)), " "); got != "2 3 4" {
+		t.Errorf("got %v, want 2 3 4", got)
+	}
+
+	// everything is in flight, so the queue still has to stay within its max
+	push(t, q, 5, 7)
+
+	if n := q.Len(); n != 3 {
+		t.Errorf("got %v entries with everything in flight, want 3", n)
+	}
+
+	// 5 drops the in flight 2, then 6 drops the pending 5 since pending entries go first.
+	// The dropped entries aren't replayed either.
+	replayed, err := Open(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayed.Close()
+
+	if got := strings.Join(values(replayed.Take(10)), " "); got != "3 4 6" {
+		t.Errorf("got %v after replay, want 3 4 6", got)
+	}
+}
+
+func TestFileStaysBoundedWhileUndelivered(t *testing.T) {
+	path, cleanup := tempQueuePath(t)
+	defer cleanup()
+
+	const max = 100
+
+	q, err := Open(path, max)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	// a dead destination never acks anything, so every push past max is a push and a drop
+	push(t, q, 0, 10000)
+	q.compactions.Wait()
+
+	if n := lines(t, path); n > 2*max+1000+2 {
+		t.Errorf("queue file has %v lines for %v queued events, it isn't being compacted", n, q.Len())
+	}
+
+	if got := values(q.Take(1)); got[0] != strconv.Itoa(10000-max) {
+		t.Errorf("got oldest %v, want %v", got[0], 10000-max)
+	}
+}
+
+func TestFailedCompactionKeepsQueue(t *testing.T) {
+	path, cleanup := tempQueuePath(t)
+	defer cleanup()
+
+	q, err := Open(path, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	// the compacted file can't be created while a directory is in its way
+	if err := os.Mkdir(path+".tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// pushes are still written to the current file, and don't see the compaction errors
+	push(t, q, 0, 2000)
+	q.compactions.Wait()
+
+	if n := lines(t, path); n < 2000 {
+		t.Fatalf("got %v lines, want every record to still be in the current file", n)
+	}
+
+	os.Remove(path + ".tmp")
+
+	// it is tried again once enough has been written since it failed
+	push(t, q, 2000, 3000)
+	q.compactions.Wait()
+
+	if n := lines(t, path); n > 2*100+1000+2 {
+		t.Errorf("queue file has %v lines, it wasn't compacted once it could be", n)
+	}
+
+	replayed, err := Open(path, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayed.Close()
+
+	if got := values(replayed.Take(100)); len(got) != 100 || got[0] != "2900" || got[99] != "2999" {
+		t.Errorf("got %v after replay, want 2900 through 2999", got)
+	}
+}
+
+func TestAckRemovesEveryEntryOnError(t *testing.T) {
+	path, cleanup := tempQueuePath(t)
+	defer cleanup()
+
+	q, err := Open(path, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	push(t, q, 0, 3)
+	taken := q.Take(3)
+
+	// nothing can be written once the queue is closed
+	q.Close()
+
+	if err := q.Ack(taken); err == nil {
+		t.Errorf("acked a closed queue without an error")
+	}
+
+	if n := q.Stats().InFlight; n != 0 {
+		t.Errorf("got %v entries still in flight after the ack, want 0", n)
+	}
+}
+
+func TestWritesAreSynced(t *testing.T) {
+	path, cleanup := tempQueuePath(t)
+	defer cleanup()
+
+	q, err := Open(path, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	push(t, q, 0, 3)
+
+	dirty := func() bool {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		return q.dirty
+	}
+
+	if !dirty() {
+		t.Fatalf("pushes weren't waiting to be synced")
+	}
+
+	deadline := time.Now().Add(5 * syncInterval)
+	for dirty() && time.Now().Before(deadline) {
+		time.Sleep(syncInterval / 10)
+	}
+
+	if dirty() {
+		t.Errorf("pushes still weren't synced after %v", 5*syncInterval)
+	}
+
+	push(t, q, 3, 4)
+
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if dirty() {
+		t.Errorf("closing the queue didn't sync it")
+	}
+
+	if err := q.Close(); err != nil {
+		t.Errorf("got %v closing the queue again, want nil", err)
+	}
+}
@@ -0,0 +1,56 @@
+package rules
+
+// Default is used when no rules file is configured. It is also a good starting point for writing one.
+const Default = `
+rules:
+  - name: cp-to-dmps
+    replace:
+      - field: generating-system
+        old: -CP
+        new: -DMPS
+      - field: device-id
+        old: -CP
+        new: -DMPS
+
+  # fix the items destined for static index that aren't coming in with the right tag
+  - name: core-state-keys
+    match:
+      key: [software-version, hardware-version, volume, muted]
+    add-tags: [core-state]
+
+  - name: ip-address
+    match:
+      key: [IP Address]
+    rename-key: ip-address
+    add-tags: [core-state]
+
+  - name: battery-calc
+    match:
+      key: [battery-charge-hours-minutes]
+      value: ^Calc$
+    rename-key: battery-type
+    set-value: ""
+
+  - name: battery-alkaline
+    match:
+      key: [battery-charge-hours-minutes]
+      value: ^AA$
+    rename-key: battery-type
+    set-value: ALKA
+
+  - name: brmb-230-responsive
+    match:
+      key: [responsive]
+      device-id: ^BRMB-230-D1$
+    set-value: Ok
+
+  - name: battery-charge-minutes
+    match:
+      key: [battery-charge-hours-minutes]
+      value: ":"
+    emit:
+      - key: battery-charge-minutes
+        value-from: hours-minutes-to-minutes
+      - key: battery-type
+        value: ""
+`
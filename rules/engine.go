@@ -0,0 +1,176 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/byuoitav/common/log"
+	"github.com/byuoitav/common/v2/events"
+)
+
+// Engine holds the current RuleSet, and can reload it from a file whenever the file changes
+type Engine struct {
+	path string
+
+	mu       sync.RWMutex
+	rules    *RuleSet
+	modTime  time.Time
+	loadedAt time.Time
+	lastErr  error
+}
+
+// EngineStatus describes which rules an Engine is using
+type EngineStatus struct {
+	Path      string    `json:"path,omitempty"`
+	Rules     int       `json:"rules"`
+	LoadedAt  time.Time `json:"loaded-at"`
+	LastError string    `json:"last-error,omitempty"`
+}
+
+// NewEngine loads rules from path. If path is empty, the Default rules are used.
+func NewEngine(path string) (*Engine, error) {
+	e := &Engine{
+		path: path,
+	}
+
+	if len(path) == 0 {
+		config, err := Parse("default.yaml", []byte(Default))
+		if err != nil {
+			return nil, err
+		}
+
+		rs, err := Compile(config)
+		if err != nil {
+			return nil, err
+		}
+
+		e.rules = rs
+		e.loadedAt = time.Now()
+		return e, nil
+	}
+
+	if err := e.Reload(); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// Apply runs x through the current rules
+func (e *Engine) Apply(x events.Event) Result {
+	e.mu.RLock()
+	rs := e.rules
+	e.mu.RUnlock()
+
+	return rs.Apply(x)
+}
+
+// Reload re-reads the rules file. If the file is invalid, the current rules are kept.
+func (e *Engine) Reload() error {
+	if len(e.path) == 0 {
+		return fmt.Errorf("rules were not loaded from a file")
+	}
+
+	info, err := os.Stat(e.path)
+	if err != nil {
+		return e.failed(fmt.Errorf("unable to read rules file: %s", err))
+	}
+
+	b, err := ioutil.ReadFile(e.path)
+	if err != nil {
+		return e.failed(fmt.Errorf("unable to read rules file: %s", err))
+	}
+
+	config, err := Parse(e.path, b)
+	if err != nil {
+		return e.failed(err)
+	}
+
+	rs, err := Compile(config)
+	if err != nil {
+		return e.failed(fmt.Errorf("invalid rules file %s: %s", e.path, err))
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.rules = rs
+	e.modTime = info.ModTime()
+	e.loadedAt = time.Now()
+	e.lastErr = nil
+
+	log.L.Infof("Loaded %v event rules from %s", rs.Len(), e.path)
+	return nil
+}
+
+// Watch checks the rules file for changes every interval, reloading it when it changes, until ctx is cancelled
+func (e *Engine) Watch(ctx context.Context, interval time.Duration) {
+	if len(e.path) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(e.path)
+		if err != nil {
+			log.L.Warnf("unable to stat rules file %s: %s", e.path, err)
+			continue
+		}
+
+		e.mu.RLock()
+		changed := !info.ModTime().Equal(e.modTime)
+		e.mu.RUnlock()
+
+		if !changed {
+			continue
+		}
+
+		if err := e.Reload(); err != nil {
+			log.L.Errorf("unable to reload rules, keeping the current rules: %s", err)
+		}
+	}
+}
+
+// Status returns the state of the engine
+func (e *Engine) Status() EngineStatus {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	status := EngineStatus{
+		Path:     e.path,
+		Rules:    e.rules.Len(),
+		LoadedAt: e.loadedAt,
+	}
+
+	if e.lastErr != nil {
+		status.LastError = e.lastErr.Error()
+	}
+
+	return status
+}
+
+func (e *Engine) failed(err error) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.lastErr = err
+
+	// don't keep retrying a broken file every tick, wait for it to change again
+	if info, serr := os.Stat(e.path); serr == nil {
+		e.modTime = info.ModTime()
+	}
+
+	return err
+}
@@ -0,0 +1,329 @@
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/byuoitav/common/v2/events"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is a list of rules, as read from a rules file
+type Config struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+// Rule changes every event that matches it. The actions of a rule are applied in the
+// order they are listed here: replace, rename-key, map-value, set-value, add-tags, remove-tags, emit, drop.
+type Rule struct {
+	Name  string `json:"name" yaml:"name"`
+	Match Match  `json:"match" yaml:"match"`
+
+	Replace    []Replace         `json:"replace,omitempty" yaml:"replace,omitempty"`
+	RenameKey  string            `json:"rename-key,omitempty" yaml:"rename-key,omitempty"`
+	MapValue   map[string]string `json:"map-value,omitempty" yaml:"map-value,omitempty"`
+	SetValue   *string           `json:"set-value,omitempty" yaml:"set-value,omitempty"`
+	AddTags    []string          `json:"add-tags,omitempty" yaml:"add-tags,omitempty"`
+	RemoveTags []string          `json:"remove-tags,omitempty" yaml:"remove-tags,omitempty"`
+	Emit       []Emit            `json:"emit,omitempty" yaml:"emit,omitempty"`
+
+	// Drop stops the event from being sent. Its value is the reason the event was dropped.
+	Drop string `json:"drop,omitempty" yaml:"drop,omitempty"`
+}
+
+// Match decides which events a rule applies to. Every field that is set must match; an empty Match matches every event.
+type Match struct {
+	// Key matches if the event's key is exactly one of these
+	Key []string `json:"key,omitempty" yaml:"key,omitempty"`
+
+	// Value, DeviceID, and GeneratingSystem are regular expressions
+	Value            string `json:"value,omitempty" yaml:"value,omitempty"`
+	DeviceID         string `json:"device-id,omitempty" yaml:"device-id,omitempty"`
+	GeneratingSystem string `json:"generating-system,omitempty" yaml:"generating-system,omitempty"`
+
+	// Tags matches if the event has all of these tags
+	Tags []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+}
+
+// Replace replaces every instance of Old with New in one of the event's fields.
+// Field can be generating-system, device-id, key, or value.
+type Replace struct {
+	Field string `json:"field" yaml:"field"`
+	Old   string `json:"old" yaml:"old"`
+	New   string `json:"new" yaml:"new"`
+}
+
+// Emit creates an extra event, copied from the matched event with a new key and value.
+// If ValueFrom is set, the value is computed from the matched event's value with that converter.
+type Emit struct {
+	Key       string `json:"key" yaml:"key"`
+	Value     string `json:"value,omitempty" yaml:"value,omitempty"`
+	ValueFrom string `json:"value-from,omitempty" yaml:"value-from,omitempty"`
+}
+
+// Result is what happened to an event that was run through a RuleSet
+type Result struct {
+	Event      events.Event   `json:"event"`
+	Derived    []events.Event `json:"derived,omitempty"`
+	Matched    []string       `json:"matched"`
+	Drop       bool           `json:"drop"`
+	DropReason string         `json:"drop-reason,omitempty"`
+}
+
+// converters compute the value of an emitted event from the value of the event that matched
+var converters = map[string]func(string) string{
+	// 3:45 -> 225
+	"hours-minutes-to-minutes": func(value string) string {
+		hm := strings.Split(value, ":")
+		h, _ := strconv.Atoi(hm[0])
+
+		m := 0
+		if len(hm) > 1 {
+			m, _ = strconv.Atoi(hm[1])
+		}
+
+		return strconv.Itoa(h*60 + m)
+	},
+}
+
+// RuleSet is a compiled, ready to use list of rules
+type RuleSet struct {
+	rules []compiledRule
+}
+
+type compiledRule struct {
+	Rule
+
+	keys             map[string]bool
+	value            *regexp.Regexp
+	deviceID         *regexp.Regexp
+	generatingSystem *regexp.Regexp
+}
+
+// Parse reads a Config from b, using the extension of path to decide if it is yaml or json
+func Parse(path string, b []byte) (Config, error) {
+	var config Config
+	var err error
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = decodeStrict(b, &config)
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(b, &config)
+	default:
+		return config, fmt.Errorf("unknown rules file extension %q (expected .json, .yaml, or .yml)", filepath.Ext(path))
+	}
+
+	if err != nil {
+		return config, fmt.Errorf("unable to parse rules file %s: %s", path, err)
+	}
+
+	return config, nil
+}
+
+// decodeStrict decodes the json in b into v, rejecting fields v doesn't have (like a misspelled
+// "mach") the same way yaml.UnmarshalStrict does, so a typo doesn't quietly change what a rule does
+func decodeStrict(b []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("unexpected data after the rules")
+	}
+
+	return nil
+}
+
+// Compile validates config and compiles its regular expressions
+func Compile(config Config) (*RuleSet, error) {
+	rs := &RuleSet{}
+
+	for i, rule := range config.Rules {
+		if len(rule.Name) == 0 {
+			rule.Name = fmt.Sprintf("rule-%v", i)
+		}
+
+		cr := compiledRule{
+			Rule: rule,
+		}
+
+		var err error
+		if cr.value, err = compileOptional(rule.Match.Value); err != nil {
+			return nil, fmt.Errorf("%s: invalid value regex: %s", rule.Name, err)
+		}
+
+		if cr.deviceID, err = compileOptional(rule.Match.DeviceID); err != nil {
+			return nil, fmt.Errorf("%s: invalid device-id regex: %s", rule.Name, err)
+		}
+
+		if cr.generatingSystem, err = compileOptional(rule.Match.GeneratingSystem); err != nil {
+			return nil, fmt.Errorf("%s: invalid generating-system regex: %s", rule.Name, err)
+		}
+
+		if len(rule.Match.Key) > 0 {
+			cr.keys = make(map[string]bool, len(rule.Match.Key))
+			for _, key := range rule.Match.Key {
+				cr.keys[key] = true
+			}
+		}
+
+		for _, r := range rule.Replace {
+			if _, err := field(&events.Event{}, r.Field); err != nil {
+				return nil, fmt.Errorf("%s: %s", rule.Name, err)
+			}
+		}
+
+		for _, e := range rule.Emit {
+			if len(e.Key) == 0 {
+				return nil, fmt.Errorf("%s: emitted events must have a key", rule.Name)
+			}
+
+			if _, ok := converters[e.ValueFrom]; len(e.ValueFrom) > 0 && !ok {
+				return nil, fmt.Errorf("%s: unknown value-from converter %q", rule.Name, e.ValueFrom)
+			}
+		}
+
+		rs.rules = append(rs.rules, cr)
+	}
+
+	return rs, nil
+}
+
+// Len returns the number of rules in the set
+func (rs *RuleSet) Len() int {
+	return len(rs.rules)
+}
+
+// Apply runs x through every rule, in order. Each rule sees the event as modified by the rules before it.
+// Processing stops at the first rule that drops the event.
+func (rs *RuleSet) Apply(x events.Event) Result {
+	// don't modify the caller's tags
+	x.EventTags = append([]string(nil), x.EventTags...)
+
+	res := Result{
+		Matched: []string{},
+	}
+
+	for _, rule := range rs.rules {
+		if !rule.matches(x) {
+			continue
+		}
+
+		res.Matched = append(res.Matched, rule.Name)
+
+		for _, r := range rule.Replace {
+			f, _ := field(&x, r.Field)
+			*f = strings.Replace(*f, r.Old, r.New, -1)
+		}
+
+		if len(rule.RenameKey) > 0 {
+			x.Key = rule.RenameKey
+		}
+
+		if val, ok := rule.MapValue[x.Value]; ok {
+			x.Value = val
+		}
+
+		if rule.SetValue != nil {
+			x.Value = *rule.SetValue
+		}
+
+		x.AddToTags(rule.AddTags...)
+		x.EventTags = removeTags(x.EventTags, rule.RemoveTags)
+
+		for _, e := range rule.Emit {
+			derived := x
+			derived.EventTags = append([]string(nil), x.EventTags...)
+			derived.Key = e.Key
+			derived.Value = e.Value
+
+			if len(e.ValueFrom) > 0 {
+				derived.Value = converters[e.ValueFrom](x.Value)
+			}
+
+			res.Derived = append(res.Derived, derived)
+		}
+
+		if len(rule.Drop) > 0 {
+			res.Drop = true
+			res.DropReason = rule.Drop
+			break
+		}
+	}
+
+	res.Event = x
+	return res
+}
+
+func (r compiledRule) matches(x events.Event) bool {
+	switch {
+	case r.keys != nil && !r.keys[x.Key]:
+		return false
+	case r.value != nil && !r.value.MatchString(x.Value):
+		return false
+	case r.deviceID != nil && !r.deviceID.MatchString(x.TargetDevice.DeviceID):
+		return false
+	case r.generatingSystem != nil && !r.generatingSystem.MatchString(x.GeneratingSystem):
+		return false
+	case len(r.Match.Tags) > 0 && !events.ContainsAllTags(x, r.Match.Tags...):
+		return false
+	}
+
+	return true
+}
+
+func field(x *events.Event, name string) (*string, error) {
+	switch name {
+	case "generating-system":
+		return &x.GeneratingSystem, nil
+	case "device-id":
+		return &x.TargetDevice.DeviceID, nil
+	case "key":
+		return &x.Key, nil
+	case "value":
+		return &x.Value, nil
+	}
+
+	return nil, fmt.Errorf("unknown field %q", name)
+}
+
+func removeTags(tags, remove []string) []string {
+	if len(remove) == 0 {
+		return tags
+	}
+
+	kept := tags[:0]
+	for _, tag := range tags {
+		drop := false
+		for _, r := range remove {
+			if tag == r {
+				drop = true
+				break
+			}
+		}
+
+		if !drop {
+			kept = append(kept, tag)
+		}
+	}
+
+	return kept
+}
+
+func compileOptional(expr string) (*regexp.Regexp, error) {
+	if len(expr) == 0 {
+		return nil, nil
+	}
+
+	return regexp.Compile(expr)
+}
@@ -0,0 +1,316 @@
+package rules
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/byuoitav/common/v2/events"
+)
+
+func defaultRules(t *testing.T) *Engine {
+	t.Helper()
+
+	e, err := NewEngine("")
+	if err != nil {
+		t.Fatalf("unable to load default rules: %s", err)
+	}
+
+	return e
+}
+
+// dmpsEvent builds an event the way readDMPSEvents does for an event from ITB-1101-CP1
+func dmpsEvent(device, state, key, value string) events.Event {
+	return events.Event{
+		GeneratingSystem: "ITB-1101-CP1",
+		EventTags:        []string{"auto-generated", state, key},
+		TargetDevice: events.BasicDeviceInfo{
+			BasicRoomInfo: events.BasicRoomInfo{
+				BuildingID: "ITB",
+				RoomID:     "ITB-1101",
+			},
+			DeviceID: device,
+		},
+		AffectedRoom: events.BasicRoomInfo{
+			BuildingID: "ITB",
+			RoomID:     "ITB-1101",
+		},
+		Key:   key,
+		Value: value,
+	}
+}
+
+// summary is the part of an event the default rules change
+type summary struct {
+	GeneratingSystem string
+	DeviceID         string
+	Key              string
+	Value            string
+	Tags             []string
+}
+
+func summarize(x events.Event) summary {
+	return summary{
+		GeneratingSystem: x.GeneratingSystem,
+		DeviceID:         x.TargetDevice.DeviceID,
+		Key:              x.Key,
+		Value:            x.Value,
+		Tags:             x.EventTags,
+	}
+}
+
+// TestDefaultRules checks that the default rules do what modifyEvent used to
+func TestDefaultRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   events.Event
+		want    summary
+		derived []summary
+	}{
+		{
+			name:  "RenameToDMPS",
+			event: dmpsEvent("ITB-1101-CP1", "core-state", "responsive", "Ok"),
+			want: summary{
+				GeneratingSystem: "ITB-1101-DMPS1",
+				DeviceID:         "ITB-1101-DMPS1",
+				Key:              "responsive",
+				Value:            "Ok",
+				Tags:             []string{"auto-generated", "core-state", "responsive"},
+			},
+		},
+		{
+			name:  "OtherDevicesKeepTheirID",
+			event: dmpsEvent("ITB-1101-D1", "detail-state", "power", "on"),
+			want: summary{
+				GeneratingSystem: "ITB-1101-DMPS1",
+				DeviceID:         "ITB-1101-D1",
+				Key:              "power",
+				Value:            "on",
+				Tags:             []string{"auto-generated", "detail-state", "power"},
+			},
+		},
+		{
+			name:  "SoftwareVersionIsCoreState",
+			event: dmpsEvent("ITB-1101-D1", "hardware-info", "software-version", "1.502.0004"),
+			want: summary{
+				GeneratingSystem: "ITB-1101-DMPS1",
+				DeviceID:         "ITB-1101-D1",
+				Key:              "software-version",
+				Value:            "1.502.0004",
+				Tags:             []string{"auto-generated", "hardware-info", "software-version", "core-state"},
+			},
+		},
+		{
+			name:  "MutedAlreadyCoreState",
+			event: dmpsEvent("ITB-1101-D1", "core-state", "muted", "false"),
+			want: summary{
+				GeneratingSystem: "ITB-1101-DMPS1",
+				DeviceID:         "ITB-1101-D1",
+				Key:              "muted",
+				Value:            "false",
+				Tags:             []string{"auto-generated", "core-state", "muted"},
+			},
+		},
+		{
+			name:  "IPAddress",
+			event: dmpsEvent("ITB-1101-CP1", "hardware-info", "IP Address", "10.5.34.10"),
+			want: summary{
+				GeneratingSystem: "ITB-1101-DMPS1",
+				DeviceID:         "ITB-1101-DMPS1",
+				Key:              "ip-address",
+				Value:            "10.5.34.10",
+				Tags:             []string{"auto-generated", "hardware-info", "IP Address", "core-state"},
+			},
+		},
+		{
+			name:  "BatteryCalc",
+			event: dmpsEvent("ITB-1101-MIC1", "detail-state", "battery-charge-hours-minutes", "Calc"),
+			want: summary{
+				GeneratingSystem: "ITB-1101-DMPS1",
+				DeviceID:         "ITB-1101-MIC1",
+				Key:              "battery-type",
+				Value:            "",
+				Tags:             []string{"auto-generated", "detail-state", "battery-charge-hours-minutes"},
+			},
+		},
+		{
+			name:  "BatteryAA",
+			event: dmpsEvent("ITB-1101-MIC1", "detail-state", "battery-charge-hours-minutes", "AA"),
+			want: summary{
+				GeneratingSystem: "ITB-1101-DMPS1",
+				DeviceID:         "ITB-1101-MIC1",
+				Key:              "battery-type",
+				Value:            "ALKA",
+				Tags:             []string{"auto-generated", "detail-state", "battery-charge-hours-minutes"},
+			},
+		},
+		{
+			name: "BRMB230D1AlwaysResponsive",
+			event: func() events.Event {
+				x := dmpsEvent("BRMB-230-D1", "core-state", "responsive", "Unresponsive")
+				x.GeneratingSystem = "BRMB-230-CP1"
+				return x
+			}(),
+			want: summary{
+				GeneratingSystem: "BRMB-230-DMPS1",
+				DeviceID:         "BRMB-230-D1",
+				Key:              "responsive",
+				Value:            "Ok",
+				Tags:             []string{"auto-generated", "core-state", "responsive"},
+			},
+		},
+		{
+			name:  "OtherRoomsResponsive",
+			event: dmpsEvent("ITB-1101-D1", "core-state", "responsive", "Unresponsive"),
+			want: summary{
+				GeneratingSystem: "ITB-1101-DMPS1",
+				DeviceID:         "ITB-1101-D1",
+				Key:              "responsive",
+				Value:            "Unresponsive",
+				Tags:             []string{"auto-generated", "core-state", "responsive"},
+			},
+		},
+		{
+			name:  "BatteryHoursMinutes",
+			event: dmpsEvent("ITB-1101-MIC1", "detail-state", "battery-charge-hours-minutes", "3:45"),
+			want: summary{
+				GeneratingSystem: "ITB-1101-DMPS1",
+				DeviceID:         "ITB-1101-MIC1",
+				Key:              "battery-charge-hours-minutes",
+				Value:            "3:45",
+				Tags:             []string{"auto-generated", "detail-state", "battery-charge-hours-minutes"},
+			},
+			derived: []summary{
+				{
+					GeneratingSystem: "ITB-1101-DMPS1",
+					DeviceID:         "ITB-1101-MIC1",
+					Key:              "battery-charge-minutes",
+					Value:            "225",
+					Tags:             []string{"auto-generated", "detail-state", "battery-charge-hours-minutes"},
+				},
+				{
+					GeneratingSystem: "ITB-1101-DMPS1",
+					DeviceID:         "ITB-1101-MIC1",
+					Key:              "battery-type",
+					Value:            "",
+					Tags:             []string{"auto-generated", "detail-state", "battery-charge-hours-minutes"},
+				},
+			},
+		},
+	}
+
+	e := defaultRules(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := e.Apply(tt.event)
+			if res.Drop {
+				t.Fatalf("event was dropped (%s)", res.DropReason)
+			}
+
+			if got := summarize(res.Event); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, expected %+v", got, tt.want)
+			}
+
+			if len(res.Derived) != len(tt.derived) {
+				t.Fatalf("got %v derived events, expected %v: %+v", len(res.Derived), len(tt.derived), res.Derived)
+			}
+
+			for i := range tt.derived {
+				if got := summarize(res.Derived[i]); !reflect.DeepEqual(got, tt.derived[i]) {
+					t.Errorf("derived event %v: got %+v, expected %+v", i, got, tt.derived[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	config, err := Parse("rules.yaml", []byte(Default+`
+  - name: drop-volume
+    match:
+      key: [volume]
+    drop: volume is too noisy
+
+  - name: never-reached
+    match:
+      key: [volume]
+    add-tags: [unreachable]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := Compile(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x := dmpsEvent("ITB-1101-D1", "detail-state", "volume", "30")
+	before := summarize(x)
+	before.Tags = append([]string(nil), x.EventTags...)
+
+	res := rs.Apply(x)
+
+	// applying the rules only reports what would happen, it doesn't change the event it was given
+	if got := summarize(x); !reflect.DeepEqual(got, before) {
+		t.Errorf("event was modified: got %+v, expected %+v", got, before)
+	}
+
+	if !res.Drop || res.DropReason != "volume is too noisy" {
+		t.Errorf("got drop %v (%q), expected it to be dropped because volume is too noisy", res.Drop, res.DropReason)
+	}
+
+	want := []string{"cp-to-dmps", "core-state-keys", "drop-volume"}
+	if !reflect.DeepEqual(res.Matched, want) {
+		t.Errorf("got matched rules %v, expected %v", res.Matched, want)
+	}
+
+	// the event is still returned as modified by the rules before the drop, so the drop can be recorded
+	if !events.ContainsAllTags(res.Event, "core-state") || events.ContainsAllTags(res.Event, "unreachable") {
+		t.Errorf("got tags %v, expected core-state but not unreachable", res.Event.EventTags)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := map[string]Config{
+		"BadRegex":      {Rules: []Rule{{Match: Match{Value: "("}}}},
+		"UnknownField":  {Rules: []Rule{{Replace: []Replace{{Field: "user"}}}}},
+		"EmitNoKey":     {Rules: []Rule{{Emit: []Emit{{Value: "x"}}}}},
+		"BadConverter":  {Rules: []Rule{{Emit: []Emit{{Key: "x", ValueFrom: "nope"}}}}},
+		"BadDeviceID":   {Rules: []Rule{{Match: Match{DeviceID: "["}}}},
+		"BadGenerating": {Rules: []Rule{{Match: Match{GeneratingSystem: "["}}}},
+	}
+
+	for name, config := range tests {
+		if _, err := Compile(config); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestParseUnknownFields(t *testing.T) {
+	tests := map[string]string{
+		"rules.json": `{"rules": [{"name": "typo", "mach": {"key": ["power"]}, "drop": "typo"}]}`,
+		"rules.yaml": "rules:\n- name: typo\n  mach:\n    key: [power]\n  drop: typo\n",
+	}
+
+	for path, b := range tests {
+		if config, err := Parse(path, []byte(b)); err == nil || !strings.Contains(err.Error(), "mach") {
+			t.Errorf("%s: got %+v and error %v, expected an error about mach", path, config, err)
+		}
+	}
+
+	config, err := Parse("rules.json", []byte(`{"rules": [{"name": "power", "match": {"key": ["power"]}, "drop": "ignored"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(config.Rules) != 1 || !reflect.DeepEqual(config.Rules[0].Match.Key, []string{"power"}) || config.Rules[0].Drop != "ignored" {
+		t.Errorf("got %+v, expected the power rule", config)
+	}
+
+	if _, err := Parse("rules.json", []byte(`{"rules": []} {"rules": []}`)); err == nil {
+		t.Errorf("got no error for data after the rules")
+	}
+}
@@ -1,32 +1,229 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
 	"net/http"
 	"os"
-	"sync"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/byuoitav/common"
-	"github.com/byuoitav/common/db/couch"
 	"github.com/byuoitav/common/log"
-	"github.com/byuoitav/common/structs"
+	"github.com/byuoitav/common/v2/events"
+	"github.com/byuoitav/crestron-telnet-microservice/collector"
 	crestrontelnet "github.com/byuoitav/crestron-telnet-microservice/crestron-telnet"
+	"github.com/byuoitav/crestron-telnet-microservice/inventory"
+	"github.com/byuoitav/crestron-telnet-microservice/naming"
+	"github.com/byuoitav/crestron-telnet-microservice/rules"
+	"github.com/byuoitav/crestron-telnet-microservice/supervisor"
 	"github.com/labstack/echo"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	listRefreshInterval = 5 * time.Minute
+	listRetryMin        = 10 * time.Second
 )
 
 var (
 	address  = os.Getenv("DB_ADDRESS")
 	username = os.Getenv("DB_USERNAME")
 	password = os.Getenv("DB_PASSWORD")
+
+	deviceSource          inventory.DeviceSource
+	deviceCache           *inventory.CachedSource
+	dmpsMonitors          = supervisor.New("dmps", crestrontelnet.MonitorDMPS)
+	otherCrestronMonitors = supervisor.New("other-crestron", crestrontelnet.MonitorOtherCrestron)
+
+	// collectors run scheduled console commands on each device, alongside the monitors
+	dmpsCollectors          *supervisor.Supervisor
+	otherCrestronCollectors *supervisor.Supervisor
+	collectors              []*collector.Collector
+	errorLogs               = collector.NewErrorLog()
+
+	// defaultDebugDuration is how long debug logs stay on when started without a duration
+	defaultDebugDuration = crestrontelnet.DefaultDebugDuration
+
+	listRefreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "crestron_telnet",
+		Name:      "device_list_refreshes_total",
+		Help:      "Attempts to refresh each device list, by result: ok, stale (using the cached list), or error.",
+	}, []string{"list", "result"})
+
+	monitoredDevices = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "crestron_telnet",
+		Name:      "monitored_devices",
+		Help:      "Devices in each list that are being monitored.",
+	}, []string{"list"})
 )
 
-func init() {
-	if len(address) == 0 || len(username) == 0 || len(password) == 0 {
-		log.L.Fatalf("One of DB_ADDRESS, DB_USERNAME, DB_PASSWORD is not set. Failing...")
+func main() {
+	if len(os.Getenv("EVENT_PROCESSOR_HOST")) == 0 {
+		log.L.Fatalf("EVENT_PROCESSOR_HOST is not set.")
+	}
+
+	sourceType := flag.String("device-source", envOrDefault("DEVICE_SOURCE", "couch"), "where to get the device lists from: couch, file, or static")
+	deviceFile := flag.String("device-file", os.Getenv("DEVICE_FILE"), "path to a yaml or json device file, used when -device-source=file")
+	staticDMPS := flag.String("static-dmps", os.Getenv("STATIC_DMPS"), "comma separated HOSTNAME=ADDRESS[:PORT][/COMMAND] list of dmps, used when -device-source=static")
+	staticOther := flag.String("static-other-crestron", os.Getenv("STATIC_OTHER_CRESTRON"), "comma separated HOSTNAME=ADDRESS[:PORT][/COMMAND] list of other crestron devices, used when -device-source=static")
+	cacheFile := flag.String("device-cache", envOrDefault("DEVICE_CACHE_FILE", filepath.Join(os.TempDir(), "crestron-telnet-devices.json")), "where to keep the last-known-good device lists. should be on a volume that survives restarts, not the default temp dir")
+	queueDir := flag.String("event-queue-dir", envOrDefault("EVENT_QUEUE_DIR", filepath.Join(os.TempDir(), "crestron-telnet-events")), "where to keep events that haven't been delivered yet, one queue per event processor. should be on a volume that survives restarts, not the default temp dir")
+	queueMax := flag.Int("event-queue-max", envIntOrDefault("EVENT_QUEUE_MAX", 100000), "max number of undelivered events to keep per event processor before dropping the oldest")
+	deliveryWorkers := flag.Int("delivery-workers", envIntOrDefault("EVENT_DELIVERY_WORKERS", 1), "default number of concurrent senders per event processor; more than one gives up delivering each device's events in order")
+	namingPatterns := flag.String("naming-patterns", os.Getenv("NAMING_PATTERNS"), "whitespace separated regexes with named groups building, room, and optionally device, used to get ids from hostnames")
+	rulesFile := flag.String("rules-file", os.Getenv("RULES_FILE"), "yaml or json file of rules to apply to dmps events, reloaded when it changes. the built in rules are used if not set")
+	transport := flag.String("default-transport", envOrDefault("CRESTRON_TRANSPORT", "telnet"), "how to connect to devices that don't specify a transport: telnet or ssh")
+	credentialsDir := flag.String("credentials-dir", os.Getenv("CRESTRON_CREDENTIALS_DIR"), "directory of named credential sets, each a subdirectory with username and password files")
+	knownHosts := flag.String("ssh-known-hosts", os.Getenv("CRESTRON_SSH_KNOWN_HOSTS"), "known_hosts file to verify ssh host keys against. required to connect over ssh unless -ssh-insecure-ignore-host-key is set")
+	insecureHostKeys := flag.Bool("ssh-insecure-ignore-host-key", envBoolOrDefault("CRESTRON_SSH_INSECURE_IGNORE_HOST_KEY", false), "accept any ssh host key instead of verifying it against -ssh-known-hosts. only for testing")
+	allowlist := flag.String("command-allowlist", os.Getenv("COMMAND_ALLOWLIST"), "comma separated console commands that can be run through the api, e.g. IPCONFIG,ERR,PING *. a trailing * allows arguments")
+	metricKeys := flag.String("metric-event-keys", os.Getenv("METRIC_EVENT_KEYS"), "comma separated event keys to give their own label on the event metrics, on top of the built in ones. other keys are counted as other")
+	identityInterval := flag.Duration("identity-interval", envDurationOrDefault("IDENTITY_INTERVAL", time.Hour), "how often to collect model, firmware, and network settings from each device. 0 disables it")
+	dmpsTelemetryInterval := flag.Duration("dmps-telemetry-interval", envDurationOrDefault("DMPS_TELEMETRY_INTERVAL", 5*time.Minute), "how often to collect uptime, cpu, ram, and program status from each dmps. 0 disables it")
+	otherTelemetryInterval := flag.Duration("other-crestron-telemetry-interval", envDurationOrDefault("OTHER_CRESTRON_TELEMETRY_INTERVAL", 5*time.Minute), "how often to collect uptime, cpu, ram, and program status from each other crestron device. 0 disables it")
+	errorLogInterval := flag.Duration("error-log-interval", envDurationOrDefault("ERROR_LOG_INTERVAL", 5*time.Minute), "how often to read each device's error log for new entries. 0 disables it")
+	gracePeriod := flag.Duration("offline-grace-period", envDurationOrDefault("OFFLINE_GRACE_PERIOD", 2*time.Minute), "how long a device has to be unreachable before it is considered offline")
+	retryInitialDelay := flag.Duration("retry-initial-delay", envDurationOrDefault("RETRY_INITIAL_DELAY", 5*time.Second), "how long to wait before the first attempt to reconnect to a device")
+	retryMaxDelay := flag.Duration("retry-max-delay", envDurationOrDefault("RETRY_MAX_DELAY", 5*time.Minute), "the longest to wait between attempts to reconnect to a device, before jitter")
+	retryMultiplier := flag.Float64("retry-multiplier", envFloatOrDefault("RETRY_MULTIPLIER", 2), "how much the delay between reconnect attempts grows after each failure")
+	retryJitter := flag.Float64("retry-jitter", envFloatOrDefault("RETRY_JITTER", 0.2), "fraction to randomize each reconnect delay by, between 0 and 1")
+	retryMaxAttempts := flag.Int("retry-max-attempts", envIntOrDefault("RETRY_MAX_ATTEMPTS", 0), "how many failed reconnects in a row mark a device offline before the grace period is up. 0 only uses the grace period")
+	debugDuration := flag.Duration("debug-log-duration", envDurationOrDefault("DEBUG_LOG_DURATION", crestrontelnet.DefaultDebugDuration), "how long debug logs stay on for a device when no duration is given")
+	flag.Parse()
+
+	defaultDebugDuration = *debugDuration
+
+	rand.Seed(time.Now().UnixNano())
+
+	ruleEngine, err := rules.NewEngine(*rulesFile)
+	if err != nil {
+		log.L.Fatalf("unable to load rules: %s", err)
+	}
+
+	crestrontelnet.SetRuleEngine(ruleEngine)
+	go ruleEngine.Watch(context.Background(), 10*time.Second)
+
+	resolver, err := naming.NewResolver(strings.Fields(*namingPatterns)...)
+	if err != nil {
+		log.L.Fatalf("unable to create name resolver: %s", err)
+	}
+
+	crestrontelnet.SetNameResolver(resolver)
+
+	if err := crestrontelnet.SetDefaultTransport(*transport); err != nil {
+		log.L.Fatalf("unable to set default transport: %s", err)
+	}
+
+	credentials, err := crestrontelnet.LoadCredentials(*credentialsDir)
+	if err != nil {
+		log.L.Fatalf("unable to load credentials: %s", err)
+	}
+
+	crestrontelnet.SetCredentials(credentials)
+	log.L.Infof("Loaded %v credential sets", len(credentials))
+
+	crestrontelnet.SetCommandAllowlist(strings.Split(*allowlist, ","))
+	crestrontelnet.AddMetricKeys(strings.Split(*metricKeys, ","))
+	crestrontelnet.SetOfflineGracePeriod(*gracePeriod)
+
+	err = crestrontelnet.SetRetryPolicy(inventory.RetryPolicy{
+		InitialDelay: *retryInitialDelay,
+		MaxDelay:     *retryMaxDelay,
+		Multiplier:   *retryMultiplier,
+		Jitter:       retryJitter,
+		MaxAttempts:  retryMaxAttempts,
+	})
+	if err != nil {
+		log.L.Fatalf("%s", err)
+	}
+
+	dmpsCollector := &collector.Collector{
+		Name: "dmps-collector",
+		Tasks: []collector.Task{
+			collector.IdentityTask(*identityInterval),
+			collector.TelemetryTask(*dmpsTelemetryInterval),
+			errorLogs.Task(*errorLogInterval),
+		},
+		DMPS: true,
+	}
+
+	otherCrestronCollector := &collector.Collector{
+		Name: "other-crestron-collector",
+		Tasks: []collector.Task{
+			collector.IdentityTask(*identityInterval),
+			collector.TelemetryTask(*otherTelemetryInterval),
+			errorLogs.Task(*errorLogInterval),
+		},
+	}
+
+	collectors = []*collector.Collector{dmpsCollector, otherCrestronCollector}
+	dmpsCollectors = supervisor.New(dmpsCollector.Name, dmpsCollector.Run)
+	otherCrestronCollectors = supervisor.New(otherCrestronCollector.Name, otherCrestronCollector.Run)
+
+	switch {
+	case len(*knownHosts) > 0 && *insecureHostKeys:
+		log.L.Fatalf("only one of CRESTRON_SSH_KNOWN_HOSTS and CRESTRON_SSH_INSECURE_IGNORE_HOST_KEY can be set")
+	case len(*knownHosts) > 0:
+		if err := crestrontelnet.SetSSHKnownHosts(*knownHosts); err != nil {
+			log.L.Fatalf("%s", err)
+		}
+	case *insecureHostKeys:
+		crestrontelnet.SetSSHInsecureIgnoreHostKey()
+		log.L.Warnf("CRESTRON_SSH_INSECURE_IGNORE_HOST_KEY is set, ssh host keys will not be verified")
+	case *transport == inventory.TransportSSH:
+		log.L.Fatalf("CRESTRON_SSH_KNOWN_HOSTS must be set to use ssh as the default transport")
+	default:
+		log.L.Warnf("CRESTRON_SSH_KNOWN_HOSTS is not set, devices that use ssh will not be able to connect")
+	}
+
+	deliveryConfig := crestrontelnet.DeliveryConfig{
+		QueueDir: *queueDir,
+		QueueMax: *queueMax,
+		Workers:  *deliveryWorkers,
+	}
+
+	warnIfTemporary("EVENT_QUEUE_DIR", *queueDir)
+
+	err = crestrontelnet.StartEventDelivery(context.Background(), deliveryConfig)
+
+	var queueErr *crestrontelnet.QueueError
+	switch {
+	case errors.As(err, &queueErr):
+		log.L.Errorf("%s, undelivered events will only be kept in memory", err)
+
+		deliveryConfig.QueueDir = ""
+		err = crestrontelnet.StartEventDelivery(context.Background(), deliveryConfig)
+		if err != nil {
+			log.L.Fatalf("unable to start event delivery: %s", err)
+		}
+	case err != nil:
+		log.L.Fatalf("unable to start event delivery: %s", err)
+	}
+
+	deviceSource, err = newDeviceSource(*sourceType, *deviceFile, *staticDMPS, *staticOther)
+	if err != nil {
+		log.L.Fatalf("unable to create device source: %s", err)
+	}
+
+	log.L.Infof("Using %s device source", *sourceType)
+
+	if *sourceType != "static" {
+		warnIfTemporary("DEVICE_CACHE_FILE", *cacheFile)
+
+		deviceCache = inventory.NewCachedSource(deviceSource, *cacheFile)
+		deviceCache.OnChange = sendDeviceListStatus
+		deviceSource = deviceCache
 	}
-}
 
-func main() {
 	router := common.NewRouter()
 
 	port := ":10015"
@@ -39,24 +236,102 @@ func main() {
 	go launchDMPSMonitors()
 	go launchOtherCrestronMonitors()
 
+	router.GET("/debug-logs", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, crestrontelnet.DebugSessions())
+	})
 	router.PUT("/debug-logs/start/:id", setDebugLogs)
 	router.PUT("/debug-logs/stop/:id", stopDebugLogs)
 
-	router.GET("/healthz", func(c echo.Context) error {
-		return c.String(http.StatusOK, "healthy")
+	router.GET("/reconciliation", getReconciliation)
+	router.GET("/rules", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, ruleEngine.Status())
+	})
+	router.POST("/rules/dry-run", func(c echo.Context) error {
+		var x events.Event
+		if err := c.Bind(&x); err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+
+		return c.JSON(http.StatusOK, ruleEngine.Apply(x))
 	})
+	router.GET("/drops", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, crestrontelnet.AllDroppedEvents())
+	})
+	router.GET("/devices/:hostname/drops", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, crestrontelnet.DroppedEvents(c.Param("hostname")))
+	})
+	router.GET("/devices", getDevices)
+	router.GET("/devices/:hostname", getDevice)
+	router.GET("/devices/:hostname/stream", streamDevice)
+	router.GET("/devices/:hostname/connectivity", func(c echo.Context) error {
+		status, ok := crestrontelnet.ConnectivityStatus(c.Param("hostname"))
+		if !ok {
+			return c.String(http.StatusNotFound, fmt.Sprintf("%s is not being monitored", c.Param("hostname")))
+		}
+
+		return c.JSON(http.StatusOK, status)
+	})
+	router.POST("/devices/:hostname/commands", runCommands)
+	router.GET("/devices/:hostname/errors", func(c echo.Context) error {
+		limit := 50
+		if l := c.QueryParam("limit"); len(l) > 0 {
+			n, err := strconv.Atoi(l)
+			if err != nil || n <= 0 {
+				return c.String(http.StatusBadRequest, "limit must be a positive number")
+			}
+
+			limit = n
+		}
 
-	err := router.StartServer(&server)
+		return c.JSON(http.StatusOK, errorLogs.Recent(c.Param("hostname"), limit))
+	})
+	router.GET("/naming/unresolved", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, crestrontelnet.UnresolvedNames())
+	})
+	router.GET("/destinations", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, crestrontelnet.DestinationStatuses())
+	})
+	router.GET("/device-lists", func(c echo.Context) error {
+		if deviceCache == nil {
+			return c.JSON(http.StatusOK, map[string]inventory.ListStatus{})
+		}
+
+		return c.JSON(http.StatusOK, deviceCache.Status())
+	})
+
+	router.GET("/healthz", healthz)
+	router.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
+
+	err = router.StartServer(&server)
 	if err != nil {
 		log.L.Fatalf("error running server: %s", err)
 	}
 }
 
+// setDebugLogs turns on debug logs for a device, for ?duration= (e.g. 15m) or the default duration
 func setDebugLogs(ctx echo.Context) error {
 	id := ctx.Param("id")
-	log.L.Warnf("Setting debug logs for %v", id)
-	crestrontelnet.StartMonitoringDevice(id)
-	return ctx.JSON(http.StatusOK, "ok")
+
+	if _, ok := monitoredDevice(id); !ok {
+		return ctx.String(http.StatusNotFound, fmt.Sprintf("%s is not being monitored", id))
+	}
+
+	d := defaultDebugDuration
+	if s := ctx.QueryParam("duration"); len(s) > 0 {
+		var err error
+		d, err = time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return ctx.String(http.StatusBadRequest, "duration must be a positive duration, e.g. 15m")
+		}
+	}
+
+	until := crestrontelnet.StartMonitoringDevice(id, d)
+	log.L.Warnf("Setting debug logs for %v until %v", id, until.Format(time.RFC3339))
+
+	return ctx.JSON(http.StatusOK, crestrontelnet.DebugSession{
+		Hostname: id,
+		Until:    until,
+	})
 }
 
 func stopDebugLogs(ctx echo.Context) error {
@@ -66,205 +341,422 @@ func stopDebugLogs(ctx echo.Context) error {
 	return ctx.JSON(http.StatusOK, "ok")
 }
 
-func launchDMPSMonitors() {
+type commandRequest struct {
+	Commands []string `json:"commands"`
+}
 
-	for {
+type commandResponse struct {
+	Hostname string                         `json:"hostname"`
+	Results  []crestrontelnet.CommandResult `json:"results"`
+}
 
-		db := couch.NewDB(address, username, password)
+func runCommands(ctx echo.Context) error {
+	hostname := ctx.Param("hostname")
 
-		dmpsList, err := db.GetDMPSList()
+	dev, ok := monitoredDevice(hostname)
+	if !ok {
+		return ctx.String(http.StatusNotFound, fmt.Sprintf("%s is not being monitored", hostname))
+	}
 
-		if err != nil {
-			log.L.Fatalf("Error retriving DMPS List %v", err)
+	var req commandRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+
+	if len(req.Commands) == 0 {
+		return ctx.String(http.StatusBadRequest, "no commands given")
+	}
+
+	for _, cmd := range req.Commands {
+		if err := crestrontelnet.CommandAllowed(cmd); err != nil {
+			return ctx.String(http.StatusForbidden, err.Error())
 		}
+	}
+
+	results, err := crestrontelnet.RunCommands(ctx.Request().Context(), dev, req.Commands)
+	if err != nil {
+		return ctx.String(http.StatusBadGateway, err.Error())
+	}
+
+	return ctx.JSON(http.StatusOK, commandResponse{
+		Hostname: hostname,
+		Results:  results,
+	})
+}
 
-		killChannel := make(chan bool, len(dmpsList.List))
+type deviceStatus struct {
+	// Type is which list the device is in, dmps or other-crestron
+	Type string `json:"type"`
 
-		go monitorDMPSList(dmpsList, killChannel)
+	crestrontelnet.DeviceStatus
+}
 
-		var waitG sync.WaitGroup
-		waitG.Add(len(dmpsList.List))
+func getDevices(ctx echo.Context) error {
+	statuses := []deviceStatus{}
 
-		for _, dmps := range dmpsList.List {
-			log.L.Debugf("Launching dmps %v", dmps)
-			//if dmps.Hostname == "HFAC-F233-CP1" {
-			go crestrontelnet.MonitorDMPS(dmps, killChannel, &waitG)
-			//}
-		}
+	for _, dev := range dmpsMonitors.Devices() {
+		statuses = append(statuses, deviceStatus{
+			Type:         inventory.DMPSList,
+			DeviceStatus: crestrontelnet.Status(dev),
+		})
+	}
 
-		waitG.Wait()
+	for _, dev := range otherCrestronMonitors.Devices() {
+		statuses = append(statuses, deviceStatus{
+			Type:         inventory.OtherCrestronList,
+			DeviceStatus: crestrontelnet.Status(dev),
+		})
 	}
+
+	return ctx.JSON(http.StatusOK, statuses)
 }
 
-func monitorDMPSList(currentDmpsList structs.DMPSList, killChannel chan bool) {
-	for {
-		//wait 5 minutes
-		log.L.Debugf("Waiting to check for Dmps list changes")
-		time.Sleep(5 * time.Minute)
+func getDevice(ctx echo.Context) error {
+	hostname := ctx.Param("hostname")
 
-		log.L.Debugf("Checking Dmps List for changes")
+	if dev, ok := dmpsMonitors.Device(hostname); ok {
+		return ctx.JSON(http.StatusOK, deviceStatus{
+			Type:         inventory.DMPSList,
+			DeviceStatus: crestrontelnet.Status(dev),
+		})
+	}
 
-		//get list
-		db := couch.NewDB(address, username, password)
+	if dev, ok := otherCrestronMonitors.Device(hostname); ok {
+		return ctx.JSON(http.StatusOK, deviceStatus{
+			Type:         inventory.OtherCrestronList,
+			DeviceStatus: crestrontelnet.Status(dev),
+		})
+	}
 
-		dmpsList, err := db.GetDMPSList()
+	return ctx.String(http.StatusNotFound, fmt.Sprintf("%s is not being monitored", hostname))
+}
 
-		if err != nil {
-			log.L.Warnf("Error retriving DMPS List %v", err)
-		}
+// streamDevice streams every line read from a device's connection, and every event sent about it, as server-sent events
+func streamDevice(ctx echo.Context) error {
+	hostname := ctx.Param("hostname")
 
-		needsToRefresh := false
-
-		if len(dmpsList.List) == len(currentDmpsList.List) {
-			oldList := make([]structs.DMPS, len(currentDmpsList.List))
-			newList := make([]structs.DMPS, len(dmpsList.List))
-			copy(oldList, currentDmpsList.List)
-			copy(newList, dmpsList.List)
-			log.L.Debugf("dmps list compare at start, %v, %v", oldList, newList)
-
-			//compare list
-			for i := 0; i < len(oldList); i++ {
-				//find this one in the new list
-				old := oldList[i]
-				match := false
-
-				for j := range newList {
-					new := newList[j]
-
-					if old.Hostname == new.Hostname && old.Address == new.Address {
-						//match
-						match = true
-						newList = append(newList[:j], newList[j+1:]...)
-
-						break
-					}
-				}
-
-				if match {
-					oldList = append(oldList[:i], oldList[i+1:]...)
-					i--
-				}
-			}
+	if _, ok := monitoredDevice(hostname); !ok {
+		return ctx.String(http.StatusNotFound, fmt.Sprintf("%s is not being monitored", hostname))
+	}
+
+	messages, unsubscribe := crestrontelnet.Subscribe(hostname)
+	defer unsubscribe()
+
+	resp := ctx.Response()
+	resp.Header().Set(echo.HeaderContentType, "text/event-stream")
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.Header().Set("Connection", "keep-alive")
+	resp.Header().Set("X-Accel-Buffering", "no")
+	resp.WriteHeader(http.StatusOK)
+	resp.Flush()
+
+	log.L.Infof("Streaming %s to %s", hostname, ctx.RealIP())
+	defer log.L.Infof("Stopped streaming %s to %s", hostname, ctx.RealIP())
 
-			log.L.Debugf("dmps list compare at end, %v, %v", oldList, newList)
+	// keeps proxies from closing the connection while the device is quiet
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
 
-			if len(oldList) > 0 || len(newList) > 0 {
-				log.L.Debugf("dmps list difference, %v, %v", oldList, newList)
-				needsToRefresh = true
+	enc := json.NewEncoder(resp)
+
+	for {
+		select {
+		case <-ctx.Request().Context().Done():
+			return nil
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(resp, ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+		case msg, ok := <-messages:
+			if !ok {
+				// the device isn't being monitored anymore
+				return nil
 			}
 
-		} else {
-			needsToRefresh = true
-			log.L.Debugf("dmps list length difference, %v, %v", len(dmpsList.List), len(currentDmpsList.List))
-		}
+			// Encode ends the data with a newline, which plus the blank line ends the message
+			if _, err := fmt.Fprintf(resp, "event: %s\ndata: ", msg.Type); err != nil {
+				return nil
+			}
 
-		if needsToRefresh {
-			//send kill signals and return
-			for i := 0; i < len(currentDmpsList.List); i++ {
-				killChannel <- true
+			if err := enc.Encode(msg); err != nil {
+				return nil
 			}
 
-			return
+			if _, err := fmt.Fprint(resp, "\n"); err != nil {
+				return nil
+			}
 		}
+
+		resp.Flush()
 	}
 }
 
-func launchOtherCrestronMonitors() {
-	for {
-		log.L.Debugf("Address %s, User %s", address, username)
-		db := couch.NewDB(address, username, password)
+// monitoredDevice finds hostname in either of the device lists currently being monitored
+func monitoredDevice(hostname string) (inventory.Device, bool) {
+	if dev, ok := dmpsMonitors.Device(hostname); ok {
+		return dev, true
+	}
 
-		otherCrestronList, err := db.GetOtherCrestronList()
+	return otherCrestronMonitors.Device(hostname)
+}
 
-		if err != nil {
-			log.L.Fatalf("Error retriving Other Crestron List %v", err)
+func healthz(ctx echo.Context) error {
+	if deviceCache == nil || !deviceCache.Degraded() {
+		return ctx.String(http.StatusOK, "healthy")
+	}
+
+	var reasons []string
+	for name, status := range deviceCache.Status() {
+		if status.Degraded {
+			reasons = append(reasons, fmt.Sprintf("%s list: %s since %s", name, status.LastError, status.ErrorSince.Format(time.RFC3339)))
 		}
+	}
 
-		killChannel := make(chan bool, len(otherCrestronList.List))
+	// still a 200, we are running fine off of the cached device lists
+	return ctx.String(http.StatusOK, "degraded: "+strings.Join(reasons, "; "))
+}
 
-		go monitorOtherCrestronList(otherCrestronList, killChannel)
+func sendDeviceListStatus(list string, status inventory.ListStatus) {
+	hostname, _ := os.Hostname()
 
-		var waitG sync.WaitGroup
-		waitG.Add(len(otherCrestronList.List))
+	x := events.Event{
+		GeneratingSystem: hostname,
+		Timestamp:        time.Now(),
+		EventTags:        []string{"health", "auto-generated"},
+		Key:              list + "-device-list",
+		Value:            "healthy",
+		Data:             status,
+	}
 
-		for _, dmps := range otherCrestronList.List {
-			log.L.Debugf("Launching other crestron %v", dmps)
-			go crestrontelnet.MonitorOtherCrestron(dmps, killChannel, &waitG)
-		}
+	if status.Degraded {
+		x.Value = "degraded"
+		log.L.Warnf("%s device list is degraded: %s", list, status.LastError)
+	} else {
+		log.L.Infof("%s device list recovered", list)
+	}
 
-		waitG.Wait()
+	if nerr := crestrontelnet.SendEvent(x); nerr != nil {
+		log.L.Warnf("Error sending event %v", nerr.Error())
 	}
 }
 
-func monitorOtherCrestronList(currentList structs.DMPSList, killChannel chan bool) {
-	for {
-		//wait 5 minutes
-		log.L.Debugf("Waiting to check for other crestron list changes")
-		time.Sleep(5 * time.Minute)
+func getReconciliation(ctx echo.Context) error {
+	resp := make(map[string]interface{})
 
-		log.L.Debugf("Checking other crestron List for changes")
+	if r, ok := dmpsMonitors.Reconciliations(); ok {
+		resp["dmps"] = r
+	}
 
-		//get list
-		db := couch.NewDB(address, username, password)
+	if r, ok := otherCrestronMonitors.Reconciliations(); ok {
+		resp["other-crestron"] = r
+	}
 
-		dmpsList, err := db.GetOtherCrestronList()
+	return ctx.JSON(http.StatusOK, resp)
+}
 
-		if err != nil {
-			log.L.Warnf("Error retriving other crestron List %v", err)
+func newDeviceSource(sourceType, deviceFile, staticDMPS, staticOther string) (inventory.DeviceSource, error) {
+	switch sourceType {
+	case "couch":
+		if len(address) == 0 || len(username) == 0 || len(password) == 0 {
+			return nil, fmt.Errorf("one of DB_ADDRESS, DB_USERNAME, DB_PASSWORD is not set")
 		}
 
-		needsToRefresh := false
-
-		if len(dmpsList.List) == len(currentList.List) {
-			oldList := make([]structs.DMPS, len(currentList.List))
-			newList := make([]structs.DMPS, len(dmpsList.List))
-			copy(oldList, currentList.List)
-			copy(newList, dmpsList.List)
-			log.L.Debugf("dmps list compare at start, %v, %v", oldList, newList)
-
-			//compare list
-			for i := 0; i < len(oldList); i++ {
-				//find this one in the new list
-				old := oldList[i]
-				match := false
-
-				for j := range newList {
-					new := newList[j]
-
-					if old.Hostname == new.Hostname && old.Address == new.Address {
-						//match
-						match = true
-						newList = append(newList[:j], newList[j+1:]...)
-
-						break
-					}
-				}
-
-				if match {
-					oldList = append(oldList[:i], oldList[i+1:]...)
-					i--
-				}
-			}
+		return &inventory.CouchSource{
+			Address:  address,
+			Username: username,
+			Password: password,
+		}, nil
+	case "file":
+		if len(deviceFile) == 0 {
+			return nil, fmt.Errorf("DEVICE_FILE must be set to use the file device source")
+		}
+
+		return inventory.NewFileSource(deviceFile)
+	case "static":
+		return inventory.NewStaticSource(staticDMPS, staticOther)
+	default:
+		return nil, fmt.Errorf("unknown device source %q", sourceType)
+	}
+}
+
+// warnIfTemporary warns that path won't survive a restart if it is under the temp dir,
+// which is thrown away along with the container
+func warnIfTemporary(setting, path string) {
+	if !underDir(os.TempDir(), path) {
+		return
+	}
+
+	log.L.Warnf("%s is %s, under the temp dir. It will be lost when the container restarts, set %s to a path on a persistent volume to keep it", setting, path, setting)
+}
+
+// underDir returns true if path is dir or anything inside of it
+func underDir(dir, path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(dir, abs)
+	if err != nil {
+		return false
+	}
 
-			log.L.Debugf("dmps list compare at end, %v, %v", oldList, newList)
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func envOrDefault(key, def string) string {
+	if val := os.Getenv(key); len(val) > 0 {
+		return val
+	}
 
-			if len(oldList) > 0 || len(newList) > 0 {
-				log.L.Debugf("dmps list difference, %v, %v", oldList, newList)
-				needsToRefresh = true
+	return def
+}
+
+// envDurationOrDefault returns the duration in the environment variable key, or def if it isn't set.
+// A value that can't be parsed is fatal, rather than quietly running with def.
+func envDurationOrDefault(key string, def time.Duration) time.Duration {
+	env := os.Getenv(key)
+	if len(env) == 0 {
+		return def
+	}
+
+	val, err := time.ParseDuration(env)
+	if err != nil {
+		log.L.Fatalf("invalid %s %q: %s", key, env, err)
+	}
+
+	return val
+}
+
+// envFloatOrDefault is like envDurationOrDefault, for floats
+func envFloatOrDefault(key string, def float64) float64 {
+	env := os.Getenv(key)
+	if len(env) == 0 {
+		return def
+	}
+
+	val, err := strconv.ParseFloat(env, 64)
+	if err != nil {
+		log.L.Fatalf("invalid %s %q: %s", key, env, err)
+	}
+
+	return val
+}
+
+// envIntOrDefault is like envDurationOrDefault, for ints
+func envIntOrDefault(key string, def int) int {
+	env := os.Getenv(key)
+	if len(env) == 0 {
+		return def
+	}
+
+	val, err := strconv.Atoi(env)
+	if err != nil {
+		log.L.Fatalf("invalid %s %q: %s", key, env, err)
+	}
+
+	return val
+}
+
+// envBoolOrDefault is like envDurationOrDefault, for bools
+func envBoolOrDefault(key string, def bool) bool {
+	env := os.Getenv(key)
+	if len(env) == 0 {
+		return def
+	}
+
+	val, err := strconv.ParseBool(env)
+	if err != nil {
+		log.L.Fatalf("invalid %s %q: %s", key, env, err)
+	}
+
+	return val
+}
+
+// watchDeviceSource returns a channel that fires when the device source changes.
+// Sources that can't be watched return a nil channel, which never fires.
+func watchDeviceSource() <-chan struct{} {
+	if w, ok := deviceSource.(inventory.Watcher); ok {
+		return w.Watch(context.Background())
+	}
+
+	return nil
+}
+
+func launchDMPSMonitors() {
+	monitorDeviceList(inventory.DMPSList, deviceSource.GetDMPSList, dmpsMonitors, dmpsCollectors)
+}
+
+func launchOtherCrestronMonitors() {
+	monitorDeviceList(inventory.OtherCrestronList, deviceSource.GetOtherCrestronList, otherCrestronMonitors, otherCrestronCollectors)
+}
+
+// monitorDeviceList keeps each supervisor in sync with the list returned by get, checking every 5 minutes (or when the source changes).
+// If the list can't be retrieved, the last-known-good list is kept and the fetch is retried with backoff.
+func monitorDeviceList(name string, get func() ([]inventory.Device, error), supervisors ...*supervisor.Supervisor) {
+	changes := watchDeviceSource()
+	retry := listRetryMin
+
+	for {
+		log.L.Debugf("Checking %s list for changes", name)
+
+		wait := listRefreshInterval
+
+		list, err := get()
+		if err != nil {
+			var stale *inventory.StaleError
+
+			if errors.As(err, &stale) {
+				log.L.Warnf("Error retriving %s list: %s", name, err)
+				listRefreshes.WithLabelValues(name, "stale").Inc()
+				reconcile(name, list, supervisors)
+			} else {
+				log.L.Warnf("Error retriving %s list, no cached list available: %s", name, err)
+				listRefreshes.WithLabelValues(name, "error").Inc()
 			}
 
+			wait = retry
+			retry *= 2
+			if retry > listRefreshInterval {
+				retry = listRefreshInterval
+			}
 		} else {
-			needsToRefresh = true
-			log.L.Debugf("dmps list length difference, %v, %v", len(dmpsList.List), len(currentList.List))
+			listRefreshes.WithLabelValues(name, "ok").Inc()
+			reconcile(name, list, supervisors)
+			retry = listRetryMin
 		}
 
-		if needsToRefresh {
-			//send kill signals and return
-			for i := 0; i < len(currentList.List); i++ {
-				killChannel <- true
-			}
+		log.L.Debugf("Waiting %v to check for %s list changes", wait, name)
+		select {
+		case <-time.After(wait):
+		case <-changes:
+		}
+	}
+}
 
-			return
+func reconcile(name string, list []inventory.Device, supervisors []*supervisor.Supervisor) {
+	removed := make(map[string]bool)
+	for _, s := range supervisors {
+		for _, dev := range s.Reconcile(list).Diff.Removed {
+			removed[dev.Hostname] = true
 		}
 	}
+
+	// a device that moved to the other list is still monitored
+	for hostname := range removed {
+		if _, ok := monitoredDevice(hostname); !ok {
+			forgetDevice(hostname)
+		}
+	}
+
+	monitoredDevices.WithLabelValues(name).Set(float64(len(list)))
+}
+
+// forgetDevice drops everything the monitors and collectors kept about hostname once it isn't monitored anymore
+func forgetDevice(hostname string) {
+	crestrontelnet.ForgetDevice(hostname)
+
+	for _, c := range collectors {
+		c.Forget(hostname)
+	}
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/byuoitav/crestron-telnet-microservice/inventory"
+	"github.com/byuoitav/crestron-telnet-microservice/supervisor"
+)
+
+func TestCachedFileSourceChangesTriggerReconcile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crestron-telnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "devices.yaml")
+	write := func(contents string, mod time.Time) {
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		// make sure the change is seen even if the writes land within the same mtime tick
+		if err := os.Chtimes(path, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("dmps:\n  - hostname: ITB-1101-CP1\n    address: 10.5.34.10\n", time.Now().Add(-time.Minute))
+
+	file, err := inventory.NewFileSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file.PollInterval = 10 * time.Millisecond
+	deviceSource = inventory.NewCachedSource(file, filepath.Join(dir, "cache.json"))
+
+	s := supervisor.New("test", func(ctx context.Context, dev inventory.Device) {
+		<-ctx.Done()
+	})
+	defer s.StopAll()
+
+	go monitorDeviceList(inventory.DMPSList, deviceSource.GetDMPSList, s)
+
+	waitForDevices := func(want ...string) {
+		t.Helper()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			var got []string
+			for _, dev := range s.Devices() {
+				got = append(got, dev.Hostname)
+			}
+
+			if reflect.DeepEqual(got, want) {
+				return
+			}
+
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for devices %v, have %v", want, got)
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	waitForDevices("ITB-1101-CP1")
+
+	// the list is only refreshed every 5 minutes otherwise, so this is only seen through Watch
+	write("dmps:\n  - hostname: ITB-1101-CP1\n    address: 10.5.34.10\n  - hostname: ITB-1102-CP1\n    address: 10.5.34.11\n", time.Now())
+	waitForDevices("ITB-1101-CP1", "ITB-1102-CP1")
+}
+
+func TestUnderDir(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/tmp", true},
+		{"/tmp/crestron-telnet-devices.json", true},
+		{"/tmp/../tmp/events/queue", true},
+		{"/tmpfiles/devices.json", false},
+		{"/var/lib/crestron-telnet/devices.json", false},
+		{"/", false},
+	}
+
+	for _, tt := range tests {
+		if got := underDir("/tmp", tt.path); got != tt.want {
+			t.Errorf("underDir(/tmp, %s): got %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
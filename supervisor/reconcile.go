@@ -0,0 +1,192 @@
+package supervisor
+
+import (
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/byuoitav/common/log"
+	"github.com/byuoitav/crestron-telnet-microservice/inventory"
+)
+
+// Diff describes how a device list differs from what is currently running
+type Diff struct {
+	Added   []inventory.Device `json:"added"`
+	Removed []inventory.Device `json:"removed"`
+	Changed []inventory.Device `json:"changed"`
+}
+
+// maxChanges is how many of the reconciliations that changed something are kept
+const maxChanges = 20
+
+// Reconciliation is the result of a single call to Reconcile
+type Reconciliation struct {
+	Time    time.Time `json:"time"`
+	Devices int       `json:"devices"`
+	Diff    Diff      `json:"diff"`
+	Errors  []string  `json:"errors,omitempty"`
+}
+
+// ReconciliationHistory is the most recent call to Reconcile, along with the most recent calls that
+// changed something (or failed to), newest first. Most calls find nothing to change, so without the
+// history a real change would be hidden by the next refresh of the device list.
+type ReconciliationHistory struct {
+	Last    Reconciliation   `json:"last"`
+	Changes []Reconciliation `json:"changes"`
+}
+
+// Empty returns true if there are no differences
+func (d Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Compare returns the devices that were added, removed, or changed between current and next.
+// Devices are matched by hostname, and are considered changed if any of their config (address, port, command to query, etc.) differs.
+func Compare(current, next []inventory.Device) Diff {
+	var diff Diff
+
+	old := make(map[string]inventory.Device, len(current))
+	for _, dev := range current {
+		old[dev.Hostname] = dev
+	}
+
+	seen := make(map[string]bool, len(next))
+	for _, dev := range next {
+		if seen[dev.Hostname] {
+			log.L.Warnf("%s is listed more than once, ignoring duplicate entry", dev.Hostname)
+			continue
+		}
+
+		seen[dev.Hostname] = true
+
+		prev, ok := old[dev.Hostname]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, dev)
+		case !sameConfig(prev, dev):
+			diff.Changed = append(diff.Changed, dev)
+		}
+	}
+
+	for _, dev := range current {
+		if !seen[dev.Hostname] {
+			diff.Removed = append(diff.Removed, dev)
+		}
+	}
+
+	sortDevices(diff.Added)
+	sortDevices(diff.Removed)
+	sortDevices(diff.Changed)
+
+	return diff
+}
+
+// Reconcile brings the running workers in line with list, only starting, stopping,
+// or restarting the devices that actually differ. The devices being stopped are
+// stopped all at once, and can still be looked up until they have exited.
+func (s *Supervisor) Reconcile(list []inventory.Device) Reconciliation {
+	s.ops.Lock()
+	defer s.ops.Unlock()
+
+	s.mu.Lock()
+	current := make([]inventory.Device, 0, len(s.workers))
+	for _, w := range s.workers {
+		current = append(current, w.device)
+	}
+
+	r := Reconciliation{
+		Time: time.Now(),
+		Diff: Compare(current, list),
+	}
+
+	if r.Diff.Empty() {
+		log.L.Debugf("[%s] no device list changes", s.name)
+	} else {
+		log.L.Infof("[%s] device list changed: %v added, %v removed, %v changed", s.name, len(r.Diff.Added), len(r.Diff.Removed), len(r.Diff.Changed))
+	}
+
+	stopping := make([]string, 0, len(r.Diff.Removed)+len(r.Diff.Changed))
+	for _, dev := range r.Diff.Removed {
+		log.L.Infof("[%s] removing %s (%s:%s)", s.name, dev.Hostname, dev.Address, dev.Port)
+		stopping = append(stopping, dev.Hostname)
+	}
+
+	for _, dev := range r.Diff.Changed {
+		prev := s.workers[dev.Hostname].device
+		log.L.Infof("[%s] restarting %s (%s:%s %q -> %s:%s %q)", s.name, dev.Hostname, prev.Address, prev.Port, prev.CommandToQuery, dev.Address, dev.Port, dev.CommandToQuery)
+		stopping = append(stopping, dev.Hostname)
+	}
+	s.mu.Unlock()
+
+	// ops is held, so nothing else can start or stop a worker while these exit
+	for _, err := range s.stop(stopping...) {
+		r.Errors = append(r.Errors, err.Error())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, dev := range r.Diff.Changed {
+		if err := s.start(dev); err != nil {
+			r.Errors = append(r.Errors, err.Error())
+		}
+	}
+
+	for _, dev := range r.Diff.Added {
+		log.L.Infof("[%s] adding %s (%s:%s)", s.name, dev.Hostname, dev.Address, dev.Port)
+
+		if err := s.start(dev); err != nil {
+			r.Errors = append(r.Errors, err.Error())
+		}
+	}
+
+	r.Devices = len(s.workers)
+	s.last = &r
+
+	if !r.Diff.Empty() || len(r.Errors) > 0 {
+		s.changes = append([]Reconciliation{r}, s.changes...)
+		if len(s.changes) > maxChanges {
+			s.changes = s.changes[:maxChanges]
+		}
+	}
+
+	return r
+}
+
+// LastReconciliation returns the result of the most recent call to Reconcile, if there has been one
+func (s *Supervisor) LastReconciliation() (Reconciliation, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.last == nil {
+		return Reconciliation{}, false
+	}
+
+	return *s.last, true
+}
+
+// Reconciliations returns the most recent call to Reconcile and the most recent ones that changed something,
+// or false if Reconcile hasn't been called yet
+func (s *Supervisor) Reconciliations() (ReconciliationHistory, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.last == nil {
+		return ReconciliationHistory{}, false
+	}
+
+	return ReconciliationHistory{
+		Last:    *s.last,
+		Changes: append([]Reconciliation{}, s.changes...),
+	}, true
+}
+
+func sameConfig(a, b inventory.Device) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+func sortDevices(devices []inventory.Device) {
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Hostname < devices[j].Hostname
+	})
+}
@@ -0,0 +1,200 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/byuoitav/common/log"
+	"github.com/byuoitav/crestron-telnet-microservice/inventory"
+)
+
+// RunFunc monitors a single device until ctx is cancelled. It is expected to
+// handle its own reconnects and only return once ctx is done.
+type RunFunc func(ctx context.Context, device inventory.Device)
+
+// Supervisor owns one long-lived worker per device, keyed by hostname
+type Supervisor struct {
+	name string
+	run  RunFunc
+
+	// ops serializes changes to which workers are running, so that mu
+	// doesn't have to be held while waiting for a worker to exit
+	ops sync.Mutex
+
+	mu      sync.Mutex
+	workers map[string]*worker
+	last    *Reconciliation
+
+	// changes is the most recent reconciliations that changed something, newest first
+	changes []Reconciliation
+}
+
+type worker struct {
+	device inventory.Device
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New returns a Supervisor that runs run for each device it is asked to start
+func New(name string, run RunFunc) *Supervisor {
+	return &Supervisor{
+		name:    name,
+		run:     run,
+		workers: make(map[string]*worker),
+	}
+}
+
+// Start launches a worker for device. It is an error to start a device that is already running.
+func (s *Supervisor) Start(device inventory.Device) error {
+	s.ops.Lock()
+	defer s.ops.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.start(device)
+}
+
+// Stop cancels the worker for hostname and waits for it to exit
+func (s *Supervisor) Stop(hostname string) error {
+	s.ops.Lock()
+	defer s.ops.Unlock()
+
+	if errs := s.stop(hostname); len(errs) > 0 {
+		return errs[0]
+	}
+
+	return nil
+}
+
+// Restart stops the worker for device (if there is one) and starts a new one with the given config.
+// The old worker is guaranteed to have exited before the new one starts.
+func (s *Supervisor) Restart(device inventory.Device) error {
+	s.ops.Lock()
+	defer s.ops.Unlock()
+
+	s.mu.Lock()
+	_, running := s.workers[device.Hostname]
+	s.mu.Unlock()
+
+	if running {
+		if errs := s.stop(device.Hostname); len(errs) > 0 {
+			return errs[0]
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.start(device)
+}
+
+// StopAll stops every worker and waits for them all to exit
+func (s *Supervisor) StopAll() {
+	s.ops.Lock()
+	defer s.ops.Unlock()
+
+	s.mu.Lock()
+	hostnames := make([]string, 0, len(s.workers))
+	for hostname := range s.workers {
+		hostnames = append(hostnames, hostname)
+	}
+	s.mu.Unlock()
+
+	s.stop(hostnames...)
+	log.L.Debugf("[%s] stopped all workers", s.name)
+}
+
+// Device returns the config the worker for hostname was started with
+func (s *Supervisor) Device(hostname string) (inventory.Device, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.workers[hostname]
+	if !ok {
+		return inventory.Device{}, false
+	}
+
+	return w.device, true
+}
+
+// Devices returns the config of every running worker, sorted by hostname
+func (s *Supervisor) Devices() []inventory.Device {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	devices := make([]inventory.Device, 0, len(s.workers))
+	for _, w := range s.workers {
+		devices = append(devices, w.device)
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Hostname < devices[j].Hostname
+	})
+
+	return devices
+}
+
+func (s *Supervisor) start(device inventory.Device) error {
+	if len(device.Hostname) == 0 {
+		return fmt.Errorf("device at %q has no hostname", device.Address)
+	}
+
+	if _, ok := s.workers[device.Hostname]; ok {
+		return fmt.Errorf("%s is already running", device.Hostname)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &worker{
+		device: device,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	s.workers[device.Hostname] = w
+
+	log.L.Debugf("[%s] starting worker for %s", s.name, device.Hostname)
+
+	go func() {
+		defer close(w.done)
+		s.run(ctx, device)
+	}()
+
+	return nil
+}
+
+// stop cancels the workers for hostnames, waits for them all to exit, and then removes them.
+// s.ops must be held, but s.mu must not be, so that the workers can still be looked up while they exit.
+func (s *Supervisor) stop(hostnames ...string) []error {
+	var errs []error
+	stopping := make(map[string]*worker, len(hostnames))
+
+	s.mu.Lock()
+	for _, hostname := range hostnames {
+		w, ok := s.workers[hostname]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s is not running", hostname))
+			continue
+		}
+
+		log.L.Debugf("[%s] stopping worker for %s", s.name, hostname)
+
+		w.cancel()
+		stopping[hostname] = w
+	}
+	s.mu.Unlock()
+
+	for _, w := range stopping {
+		<-w.done
+	}
+
+	s.mu.Lock()
+	for hostname := range stopping {
+		delete(s.workers, hostname)
+	}
+	s.mu.Unlock()
+
+	return errs
+}
@@ -0,0 +1,347 @@
+package supervisor
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/byuoitav/common/structs"
+	"github.com/byuoitav/crestron-telnet-microservice/inventory"
+)
+
+// recorder is a RunFunc that records when each worker starts and stops
+type recorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recorder) run(ctx context.Context, dev inventory.Device) {
+	r.record("start " + dev.Hostname + " " + dev.Address)
+	<-ctx.Done()
+	r.record("stop " + dev.Hostname + " " + dev.Address)
+}
+
+func (r *recorder) record(event string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, event)
+}
+
+// wait waits for n events to be recorded, then returns them
+func (r *recorder) wait(t *testing.T, n int) []string {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		r.mu.Lock()
+		events := append([]string(nil), r.events...)
+		r.mu.Unlock()
+
+		if len(events) >= n {
+			return events
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v events, got %v", n, events)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func device(hostname, address string) inventory.Device {
+	return inventory.Device{
+		DMPS: structs.DMPS{
+			Hostname: hostname,
+			Address:  address,
+		},
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	var rec recorder
+	s := New("test", rec.run)
+
+	if err := s.Start(device("ITB-1101-CP1", "10.0.0.1")); err != nil {
+		t.Fatalf("unable to start: %s", err)
+	}
+
+	rec.wait(t, 1)
+
+	if err := s.Start(device("ITB-1101-CP1", "10.0.0.1")); err == nil {
+		t.Errorf("started the same hostname twice")
+	}
+
+	if err := s.Start(device("", "10.0.0.2")); err == nil {
+		t.Errorf("started a device without a hostname")
+	}
+
+	if err := s.Stop("ITB-1101-CP1"); err != nil {
+		t.Fatalf("unable to stop: %s", err)
+	}
+
+	// Stop waits for the worker to exit, so there's nothing to wait for
+	rec.mu.Lock()
+	got := rec.events
+	rec.mu.Unlock()
+
+	want := []string{"start ITB-1101-CP1 10.0.0.1", "stop ITB-1101-CP1 10.0.0.1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if err := s.Stop("ITB-1101-CP1"); err == nil {
+		t.Errorf("stopped a worker that wasn't running")
+	}
+}
+
+func TestRestartStopsBeforeStarting(t *testing.T) {
+	var rec recorder
+	s := New("test", rec.run)
+
+	if err := s.Start(device("ITB-1101-CP1", "10.0.0.1")); err != nil {
+		t.Fatalf("unable to start: %s", err)
+	}
+
+	rec.wait(t, 1)
+
+	if err := s.Restart(device("ITB-1101-CP1", "10.0.0.2")); err != nil {
+		t.Fatalf("unable to restart: %s", err)
+	}
+
+	got := rec.wait(t, 3)
+	want := []string{"start ITB-1101-CP1 10.0.0.1", "stop ITB-1101-CP1 10.0.0.1", "start ITB-1101-CP1 10.0.0.2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if dev, _ := s.Device("ITB-1101-CP1"); dev.Address != "10.0.0.2" {
+		t.Errorf("got address %q after restart, want 10.0.0.2", dev.Address)
+	}
+
+	s.StopAll()
+	if len(s.Devices()) != 0 {
+		t.Errorf("got %v devices after StopAll, want 0", len(s.Devices()))
+	}
+}
+
+func hostnames(devices []inventory.Device) []string {
+	names := []string{}
+	for _, dev := range devices {
+		names = append(names, dev.Hostname)
+	}
+
+	return names
+}
+
+func TestCompare(t *testing.T) {
+	current := []inventory.Device{
+		device("A", "10.0.0.1"),
+		device("B", "10.0.0.2"),
+		device("C", "10.0.0.3"),
+	}
+
+	next := []inventory.Device{
+		device("D", "10.0.0.4"),
+		device("C", "10.0.0.30"),
+		device("A", "10.0.0.1"),
+		device("D", "10.0.0.40"),
+	}
+
+	diff := Compare(current, next)
+
+	if got := hostnames(diff.Added); !reflect.DeepEqual(got, []string{"D"}) {
+		t.Errorf("got added %v, want [D]", got)
+	}
+
+	if got := hostnames(diff.Removed); !reflect.DeepEqual(got, []string{"B"}) {
+		t.Errorf("got removed %v, want [B]", got)
+	}
+
+	if got := hostnames(diff.Changed); !reflect.DeepEqual(got, []string{"C"}) {
+		t.Errorf("got changed %v, want [C]", got)
+	}
+
+	// the first entry for a duplicate hostname wins
+	if diff.Added[0].Address != "10.0.0.4" {
+		t.Errorf("got %s for duplicate D, want the first entry (10.0.0.4)", diff.Added[0].Address)
+	}
+
+	if !Compare(current, current).Empty() {
+		t.Errorf("comparing a list to itself wasn't empty")
+	}
+}
+
+func TestCompareConfig(t *testing.T) {
+	a := device("A", "10.0.0.1")
+	b := device("A", "10.0.0.1")
+	b.Transport = inventory.TransportSSH
+
+	if diff := Compare([]inventory.Device{a}, []inventory.Device{b}); len(diff.Changed) != 1 {
+		t.Errorf("changing only the transport wasn't seen as a change: %+v", diff)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	var rec recorder
+	s := New("test", rec.run)
+
+	s.Reconcile([]inventory.Device{
+		device("A", "10.0.0.1"),
+		device("B", "10.0.0.2"),
+		device("C", "10.0.0.3"),
+	})
+
+	rec.wait(t, 3)
+
+	rec.mu.Lock()
+	rec.events = nil
+	rec.mu.Unlock()
+
+	r := s.Reconcile([]inventory.Device{
+		device("A", "10.0.0.1"),
+		device("C", "10.0.0.30"),
+		device("D", "10.0.0.4"),
+	})
+
+	if len(r.Errors) > 0 {
+		t.Fatalf("got errors: %v", r.Errors)
+	}
+
+	if r.Devices != 3 {
+		t.Errorf("got %v devices, want 3", r.Devices)
+	}
+
+	got := rec.wait(t, 4)
+
+	// B and the old C are stopped together and have exited before anything is started, so they come first.
+	// The new C and D are started in their own goroutines, so each pair can come in either order.
+	stops := map[string]bool{
+		"stop B 10.0.0.2": true,
+		"stop C 10.0.0.3": true,
+	}
+
+	starts := map[string]bool{
+		"start C 10.0.0.30": true,
+		"start D 10.0.0.4":  true,
+	}
+
+	for i, event := range got {
+		switch {
+		case !stops[event] && !starts[event]:
+			t.Errorf("unexpected event %q (unchanged devices shouldn't be touched): %v", event, got)
+		case stops[event] && i >= 2, starts[event] && i < 2:
+			t.Errorf("got %q at %v, want the stops before the starts: %v", event, i, got)
+		}
+	}
+
+	// nothing changed, so nothing should be touched
+	if r := s.Reconcile(s.Devices()); !r.Diff.Empty() {
+		t.Errorf("reconciling the running list changed something: %+v", r.Diff)
+	}
+
+	s.StopAll()
+}
+
+func TestReconciliationsKeepChanges(t *testing.T) {
+	var rec recorder
+	s := New("test", rec.run)
+	defer s.StopAll()
+
+	if _, ok := s.Reconciliations(); ok {
+		t.Errorf("got reconciliations before Reconcile was called")
+	}
+
+	s.Reconcile([]inventory.Device{device("A", "10.0.0.1")})
+	s.Reconcile([]inventory.Device{device("A", "10.0.0.1"), device("B", "10.0.0.2")})
+
+	// the list is refreshed a few more times without anything changing
+	for i := 0; i < 3; i++ {
+		s.Reconcile(s.Devices())
+	}
+
+	history, ok := s.Reconciliations()
+	if !ok {
+		t.Fatalf("got no reconciliations")
+	}
+
+	if !history.Last.Diff.Empty() {
+		t.Errorf("got last diff %+v, want it empty", history.Last.Diff)
+	}
+
+	if len(history.Changes) != 2 {
+		t.Fatalf("got %v changes, want 2", len(history.Changes))
+	}
+
+	if got := hostnames(history.Changes[0].Diff.Added); !reflect.DeepEqual(got, []string{"B"}) {
+		t.Errorf("got %v added by the newest change, want B", got)
+	}
+
+	// only the most recent changes are kept
+	for i := 0; i < maxChanges+5; i++ {
+		if i%2 == 0 {
+			s.Reconcile([]inventory.Device{device("A", "10.0.0.1")})
+		} else {
+			s.Reconcile([]inventory.Device{device("A", "10.0.0.1"), device("B", "10.0.0.2")})
+		}
+	}
+
+	if history, _ := s.Reconciliations(); len(history.Changes) != maxChanges {
+		t.Errorf("got %v changes, want %v", len(history.Changes), maxChanges)
+	}
+}
+
+func TestLookupsDontWaitForReconcile(t *testing.T) {
+	cancelled := make(chan struct{})
+	release := make(chan struct{})
+	exited := make(chan struct{})
+
+	// the worker takes its time exiting
+	s := New("test", func(ctx context.Context, dev inventory.Device) {
+		<-ctx.Done()
+		close(cancelled)
+		<-release
+		close(exited)
+	})
+
+	if err := s.Start(device("A", "10.0.0.1")); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan Reconciliation)
+	go func() {
+		done <- s.Reconcile(nil)
+	}()
+
+	<-cancelled
+
+	// A is still listed while it exits, and looking it up doesn't wait for it
+	lookedUp := make(chan bool)
+	go func() {
+		_, ok := s.Device("A")
+		lookedUp <- ok
+	}()
+
+	select {
+	case ok := <-lookedUp:
+		if !ok {
+			t.Errorf("A wasn't listed while it was stopping")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("looking up a device waited on the reconcile")
+	}
+
+	close(release)
+	<-exited
+
+	if r := <-done; len(r.Errors) > 0 || r.Devices != 0 {
+		t.Errorf("got %+v, want no devices and no errors", r)
+	}
+
+	if _, ok := s.Device("A"); ok {
+		t.Errorf("A was still listed after it was removed")
+	}
+}